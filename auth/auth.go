@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeStatsRead  = "stats:read"
+
+	principalKey = "auth.principal"
+)
+
+// AllScopes lists every scope a credential can be granted.
+var AllScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeStatsRead}
+
+var (
+	// ErrUnrecognized is returned by an Authenticator for tokens it doesn't handle, so the
+	// next one can try.
+	ErrUnrecognized = errors.New("unrecognized credential")
+	// ErrInvalidCredential is returned for tokens that look right but aren't valid.
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller, e.g. "api_key:3".
+	Subject string
+	Scopes  []string
+	// Role names the database role whose permissions apply to the caller.
+	Role string
+	// UserId is the user the caller acts as, or zero when it isn't tied to a user.
+	UserId int64
+	// TenantId is the organization the credential is bound to, or zero for platform
+	// credentials that choose a tenant per request.
+	TenantId int64
+	// Claims holds the verified token claims for JWT callers and is nil for API keys.
+	Claims map[string]any
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Authenticator turns a bearer token into a Principal.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+func SetPrincipal(ctx *gin.Context, principal *Principal) {
+	ctx.Set(principalKey, principal)
+}
+
+func GetPrincipal(ctx *gin.Context) (*Principal, bool) {
+	value, exists := ctx.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// ParseScopes splits a comma or space separated scope list and checks every scope is known.
+func ParseScopes(scopes string) ([]string, error) {
+	fields := strings.FieldsFunc(scopes, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	parsed := make([]string, 0, len(fields))
+	for _, scope := range fields {
+		if !slices.Contains(AllScopes, scope) {
+			return nil, errors.New("unknown scope " + scope)
+		}
+		if !slices.Contains(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	return parsed, nil
+}
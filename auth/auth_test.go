@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/stretchr/testify/require"
+)
+
+// touchCountingStore serves one key and counts the TouchAPIKey calls.
+type touchCountingStore struct {
+	apiKey  db.APIKey
+	touches int
+}
+
+func (s *touchCountingStore) GetAPIKeyByPrefix(_ context.Context, _ string) (*db.APIKey, error) {
+	apiKey := s.apiKey
+	return &apiKey, nil
+}
+
+func (s *touchCountingStore) TouchAPIKey(_ context.Context, _ int64) (sql.Result, error) {
+	s.touches++
+	lastUsedAt := time.Now().Unix()
+	s.apiKey.LastUsedAt = &lastUsedAt
+	return nil, nil
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	r := require.New(t)
+	key, prefix, hash, keyErr := GenerateAPIKey()
+	r.NoError(keyErr)
+	parsedPrefix, ok := ParseAPIKey(key)
+	r.True(ok)
+	r.Equal(prefix, parsedPrefix)
+	r.Equal(hash, HashAPIKey(key))
+	r.NotContains(hash, prefix)
+	otherKey, otherPrefix, _, otherKeyErr := GenerateAPIKey()
+	r.NoError(otherKeyErr)
+	r.NotEqual(key, otherKey)
+	r.NotEqual(prefix, otherPrefix)
+}
+
+func TestAPIKeyAuthenticatorTouch(t *testing.T) {
+	r := require.New(t)
+	key, prefix, hash, keyErr := GenerateAPIKey()
+	r.NoError(keyErr)
+	store := &touchCountingStore{apiKey: db.APIKey{Id: 1, Prefix: prefix, KeyHash: hash, Scopes: ScopeUsersRead}}
+	authenticator := NewAPIKeyAuthenticator(store)
+	for range 3 {
+		_, authErr := authenticator.Authenticate(context.Background(), key)
+		r.NoError(authErr)
+	}
+	r.Equal(1, store.touches)
+
+	// A key last used over a minute ago is touched again
+	lastUsedAt := time.Now().Add(-2 * time.Minute).Unix()
+	store.apiKey.LastUsedAt = &lastUsedAt
+	_, authErr := authenticator.Authenticate(context.Background(), key)
+	r.NoError(authErr)
+	r.Equal(2, store.touches)
+}
+
+func TestParseAPIKey(t *testing.T) {
+	r := require.New(t)
+	for _, token := range []string{"", "gt", "gt__secret", "gt_prefix_", "xx_prefix_secret", "gt_a_b_c"} {
+		_, ok := ParseAPIKey(token)
+		r.False(ok, token)
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	r := require.New(t)
+	scopes, scopesErr := ParseScopes("users:read, stats:read users:read")
+	r.NoError(scopesErr)
+	r.Equal([]string{ScopeUsersRead, ScopeStatsRead}, scopes)
+	_, scopesErr = ParseScopes("users:read,users:admin")
+	r.Error(scopesErr)
+	principal := &Principal{Subject: "api_key:1", Scopes: scopes}
+	r.True(principal.HasScope(ScopeStatsRead))
+	r.False(principal.HasScope(ScopeUsersWrite))
+}
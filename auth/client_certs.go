@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+)
+
+// ClientCertificateStore looks up the identities of client certificates. db.Client implements it.
+type ClientCertificateStore interface {
+	GetClientCertificateBySubject(ctx context.Context, subject string) (*db.ClientCertificate, error)
+}
+
+// ClientCertAuthenticator authenticates client certificates, already verified by the TLS
+// handshake, whose subject was registered with api_key_client.
+type ClientCertAuthenticator struct {
+	store ClientCertificateStore
+}
+
+func NewClientCertAuthenticator(store ClientCertificateStore) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{store: store}
+}
+
+func (a *ClientCertAuthenticator) AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (*Principal, error) {
+	clientCertificate, clientCertificateErr := a.store.GetClientCertificateBySubject(ctx, cert.Subject.String())
+	if errors.Is(clientCertificateErr, sql.ErrNoRows) {
+		return nil, ErrInvalidCredential
+	} else if clientCertificateErr != nil {
+		return nil, clientCertificateErr
+	}
+	if clientCertificate.Revoked() {
+		return nil, ErrInvalidCredential
+	}
+	principal := &Principal{
+		Subject: fmt.Sprintf("client_cert:%d", clientCertificate.Id),
+		Scopes:  clientCertificate.ScopeList(),
+		Role:    clientCertificate.Role,
+	}
+	if clientCertificate.UserId != nil {
+		principal.UserId = *clientCertificate.UserId
+	}
+	if clientCertificate.TenantId != nil {
+		principal.TenantId = *clientCertificate.TenantId
+	}
+	return principal, nil
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+	"github.com/brandonrachal/gin-and-tonic/tracing"
+)
+
+const (
+	DefaultJWKSRefreshInterval = 15 * time.Minute
+	// jwksMinRefreshInterval stops tokens with made up key ids from hammering the JWKS source.
+	jwksMinRefreshInterval = 30 * time.Second
+	maxJWKSSize            = 1 << 20
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses a JSON Web Key Set into public keys by key id. RSA, P-256 EC and Ed25519
+// keys are supported; other keys and keys not meant for signatures are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if unmarshalErr := json.Unmarshal(data, &set); unmarshalErr != nil {
+		return nil, fmt.Errorf("parsing jwks - %w", unmarshalErr)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, publicKeyErr := key.publicKey()
+		if publicKeyErr != nil {
+			return nil, fmt.Errorf("parsing jwk %q - %w", key.Kid, publicKeyErr)
+		} else if publicKey != nil {
+			keys[key.Kid] = publicKey
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, nErr := decodeBigInt(k.N)
+		if nErr != nil {
+			return nil, nErr
+		}
+		e, eErr := decodeBigInt(k.E)
+		if eErr != nil {
+			return nil, eErr
+		} else if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, xErr := base64.RawURLEncoding.DecodeString(k.X)
+		if xErr != nil {
+			return nil, xErr
+		}
+		y, yErr := base64.RawURLEncoding.DecodeString(k.Y)
+		if yErr != nil {
+			return nil, yErr
+		} else if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("bad P-256 coordinates")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, errors.New("P-256 point is not on the curve")
+		}
+		return publicKey, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, xErr := base64.RawURLEncoding.DecodeString(k.X)
+		if xErr != nil {
+			return nil, xErr
+		} else if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, decodeErr := base64.RawURLEncoding.DecodeString(value)
+	if decodeErr != nil {
+		return nil, decodeErr
+	} else if len(decoded) == 0 {
+		return nil, errors.New("empty integer")
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+// JWKSCache loads a key set from a local file or an http(s) URL and keeps it fresh. The set
+// is reloaded every refresh interval, whenever a local file changes, and when a token names
+// a key id the cached set doesn't have, which is how rotated keys get picked up.
+type JWKSCache struct {
+	location        string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	modTime   time.Time
+	// loading is the load in progress, nil when there is none.
+	loading *jwksLoad
+}
+
+// jwksLoad lets the callers that need the key set while it loads wait for the same load.
+type jwksLoad struct {
+	done chan struct{}
+	err  error
+}
+
+func NewJWKSCache(location string, refreshInterval time.Duration) *JWKSCache {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+	return &JWKSCache{
+		location:        location,
+		refreshInterval: refreshInterval,
+		// Unknown key ids trigger a fetch, which carries the request id and trace context of the
+		// request that needed it.
+		client: &http.Client{Timeout: 10 * time.Second, Transport: &tracing.Transport{Base: &requestid.Transport{}}},
+	}
+}
+
+// Key returns the key with id kid. An empty kid matches the only key of a single key set.
+func (c *JWKSCache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	stale, loaded := c.stale(), c.keys != nil
+	c.mu.Unlock()
+	if stale {
+		if loadErr := c.reload(ctx); loadErr != nil && !loaded {
+			return nil, loadErr
+		}
+	}
+	c.mu.Lock()
+	key, found := c.lookup(kid)
+	due := time.Since(c.fetchedAt) >= jwksMinRefreshInterval
+	c.mu.Unlock()
+	if found {
+		return key, nil
+	}
+	if due {
+		// The token may be bad rather than the key set out of date, so a failed reload still
+		// rejects the credential
+		if loadErr := c.reload(ctx); loadErr != nil {
+			return nil, fmt.Errorf("%w: unknown key id %q, reloading the key set - %w", ErrInvalidCredential, kid, loadErr)
+		}
+		c.mu.Lock()
+		key, found = c.lookup(kid)
+		c.mu.Unlock()
+		if found {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidCredential, kid)
+}
+
+// lookup must be called with c.mu held.
+func (c *JWKSCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, found := c.keys[kid]
+	return key, found
+}
+
+func (c *JWKSCache) remote() bool {
+	return strings.HasPrefix(c.location, "https://") || strings.HasPrefix(c.location, "http://")
+}
+
+// stale must be called with c.mu held.
+func (c *JWKSCache) stale() bool {
+	if c.keys == nil || time.Since(c.fetchedAt) >= c.refreshInterval {
+		return true
+	}
+	if c.remote() {
+		return false
+	}
+	info, statErr := os.Stat(c.location)
+	return statErr == nil && !info.ModTime().Equal(c.modTime)
+}
+
+// reload loads the key set, or waits for the load already in progress. c.mu isn't held while
+// loading, so lookups of cached keys don't wait on a slow JWKS source. The load outlives a
+// caller that gives up, since others may be waiting for it, and is bounded by the client
+// timeout instead.
+func (c *JWKSCache) reload(ctx context.Context) error {
+	c.mu.Lock()
+	load := c.loading
+	if load == nil {
+		load = &jwksLoad{done: make(chan struct{})}
+		c.loading, c.fetchedAt = load, time.Now()
+		go c.load(context.WithoutCancel(ctx), load)
+	}
+	c.mu.Unlock()
+	select {
+	case <-load.done:
+		return load.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *JWKSCache) load(ctx context.Context, load *jwksLoad) {
+	keys, modTime, readErr := c.read(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if readErr == nil {
+		c.keys, c.modTime = keys, modTime
+	}
+	c.loading, load.err = nil, readErr
+	close(load.done)
+}
+
+// read returns the key set and, for a local file, its modification time.
+func (c *JWKSCache) read(ctx context.Context) (map[string]crypto.PublicKey, time.Time, error) {
+	var (
+		data    []byte
+		modTime time.Time
+	)
+	if c.remote() {
+		fetched, fetchErr := c.fetch(ctx)
+		if fetchErr != nil {
+			return nil, time.Time{}, fetchErr
+		}
+		data = fetched
+	} else {
+		info, statErr := os.Stat(c.location)
+		if statErr != nil {
+			return nil, time.Time{}, statErr
+		}
+		read, readErr := os.ReadFile(c.location)
+		if readErr != nil {
+			return nil, time.Time{}, readErr
+		}
+		data, modTime = read, info.ModTime()
+	}
+	keys, keysErr := ParseJWKS(data)
+	if keysErr != nil {
+		return nil, time.Time{}, keysErr
+	}
+	return keys, modTime, nil
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) ([]byte, error) {
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, c.location, nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	resp, respErr := c.client.Do(req)
+	if respErr != nil {
+		return nil, respErr
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks from %s - status %d", c.location, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
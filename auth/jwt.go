@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DefaultJWTClockSkew = time.Minute
+
+// JWTConfig holds the claims a token must carry to be accepted.
+type JWTConfig struct {
+	// Issuer must equal the iss claim when set.
+	Issuer string
+	// Audience must be one of the aud claim values when set.
+	Audience string
+	// ClockSkew is the leeway allowed on exp, nbf and iat.
+	ClockSkew time.Duration
+}
+
+// JWTAuthenticator accepts RS256, ES256 and EdDSA signed JWTs whose keys are in a JWKS.
+// Scopes are read from the space separated scope claim or the scp claim, the role from the
+// role claim (RoleUser when missing) and the caller's own user id from the user_id claim.
+// Tokens must carry a tenant_id claim, so an identity provider can't mint platform tokens.
+type JWTAuthenticator struct {
+	keys   *JWKSCache
+	config JWTConfig
+	now    func() time.Time
+}
+
+func NewJWTAuthenticator(keys *JWKSCache, config JWTConfig) *JWTAuthenticator {
+	return &JWTAuthenticator{keys: keys, config: config, now: time.Now}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnrecognized
+	}
+	headerBytes, headerErr := base64.RawURLEncoding.DecodeString(parts[0])
+	if headerErr != nil {
+		return nil, ErrUnrecognized
+	}
+	var header jwtHeader
+	if unmarshalErr := json.Unmarshal(headerBytes, &header); unmarshalErr != nil || header.Alg == "" {
+		return nil, ErrUnrecognized
+	}
+	signature, signatureErr := base64.RawURLEncoding.DecodeString(parts[2])
+	if signatureErr != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidCredential)
+	}
+	key, keyErr := a.keys.Key(ctx, header.Kid)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if verifyErr := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); verifyErr != nil {
+		return nil, verifyErr
+	}
+
+	payload, payloadErr := base64.RawURLEncoding.DecodeString(parts[1])
+	if payloadErr != nil {
+		return nil, fmt.Errorf("%w: bad payload encoding", ErrInvalidCredential)
+	}
+	var claims map[string]any
+	if unmarshalErr := json.Unmarshal(payload, &claims); unmarshalErr != nil {
+		return nil, fmt.Errorf("%w: bad claims", ErrInvalidCredential)
+	}
+	if claimsErr := a.validateClaims(claims); claimsErr != nil {
+		return nil, claimsErr
+	}
+	subject, _ := claims["sub"].(string)
+	role, _ := claims["role"].(string)
+	if role == "" {
+		role = RoleUser
+	}
+	userId, _ := claims["user_id"].(float64)
+	tenantId, _ := claims["tenant_id"].(float64)
+	if tenantId < 1 {
+		return nil, fmt.Errorf("%w: missing tenant_id", ErrInvalidCredential)
+	}
+	return &Principal{
+		Subject:  "jwt:" + subject,
+		Scopes:   jwtScopes(claims),
+		Role:     role,
+		UserId:   int64(userId),
+		TenantId: int64(tenantId),
+		Claims:   claims,
+	}, nil
+}
+
+// verifyJWTSignature checks the signature with the algorithm the key type dictates, so a
+// token can't pick a weaker algorithm for a key.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	valid := false
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		valid = alg == "RS256" && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if alg == "ES256" && len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			valid = ecdsa.Verify(publicKey, digest[:], r, s)
+		}
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(publicKey, []byte(signingInput), signature)
+	}
+	if !valid {
+		return fmt.Errorf("%w: bad %s signature", ErrInvalidCredential, alg)
+	}
+	return nil
+}
+
+func (a *JWTAuthenticator) validateClaims(claims map[string]any) error {
+	now := a.now()
+	skew := a.config.ClockSkew
+	exp, hasExp := numericDate(claims["exp"])
+	if !hasExp {
+		return fmt.Errorf("%w: missing exp", ErrInvalidCredential)
+	} else if now.After(exp.Add(skew)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidCredential)
+	}
+	if nbf, hasNbf := numericDate(claims["nbf"]); hasNbf && now.Add(skew).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidCredential)
+	}
+	if iat, hasIat := numericDate(claims["iat"]); hasIat && now.Add(skew).Before(iat) {
+		return fmt.Errorf("%w: token issued in the future", ErrInvalidCredential)
+	}
+	if a.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.config.Issuer {
+			return fmt.Errorf("%w: unexpected issuer", ErrInvalidCredential)
+		}
+	}
+	if a.config.Audience != "" && !slices.Contains(stringList(claims["aud"]), a.config.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidCredential)
+	}
+	return nil
+}
+
+func numericDate(value any) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// stringList reads a claim that may be a single string or an array of strings.
+func stringList(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []any:
+		values := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func jwtScopes(claims map[string]any) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	if scp, ok := claims["scp"].(string); ok {
+		return strings.Fields(scp)
+	}
+	return stringList(claims["scp"])
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testKey struct {
+	kid    string
+	alg    string
+	signer crypto.Signer
+}
+
+func newTestKeys(r *require.Assertions) []testKey {
+	rsaKey, rsaKeyErr := rsa.GenerateKey(rand.Reader, 2048)
+	r.NoError(rsaKeyErr)
+	ecKey, ecKeyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(ecKeyErr)
+	_, edKey, edKeyErr := ed25519.GenerateKey(rand.Reader)
+	r.NoError(edKeyErr)
+	return []testKey{
+		{kid: "rsa-1", alg: "RS256", signer: rsaKey},
+		{kid: "ec-1", alg: "ES256", signer: ecKey},
+		{kid: "ed-1", alg: "EdDSA", signer: edKey},
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	keys := newTestKeys(r)
+	jwksPath := writeJWKS(r, t.TempDir(), keys...)
+	authenticator := NewJWTAuthenticator(NewJWKSCache(jwksPath, time.Hour), JWTConfig{
+		Issuer:    "https://id.example.com",
+		Audience:  "gin-and-tonic",
+		ClockSkew: DefaultJWTClockSkew,
+	})
+	now := time.Now()
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss":       "https://id.example.com",
+			"aud":       []string{"other", "gin-and-tonic"},
+			"sub":       "user-42",
+			"exp":       now.Add(time.Hour).Unix(),
+			"iat":       now.Unix(),
+			"scope":     "users:read stats:read",
+			"tenant_id": 1,
+		}
+	}
+	for _, key := range keys {
+		principal, authErr := authenticator.Authenticate(ctx, signJWT(r, key, validClaims()))
+		r.NoError(authErr, key.alg)
+		r.Equal("jwt:user-42", principal.Subject)
+		r.Equal([]string{ScopeUsersRead, ScopeStatsRead}, principal.Scopes)
+		r.Equal("user-42", principal.Claims["sub"])
+		r.Equal(int64(1), principal.TenantId)
+	}
+
+	for name, mutate := range map[string]func(claims map[string]any){
+		"expired":          func(claims map[string]any) { claims["exp"] = now.Add(-2 * DefaultJWTClockSkew).Unix() },
+		"missing exp":      func(claims map[string]any) { delete(claims, "exp") },
+		"not yet valid":    func(claims map[string]any) { claims["nbf"] = now.Add(2 * DefaultJWTClockSkew).Unix() },
+		"wrong issuer":     func(claims map[string]any) { claims["iss"] = "https://evil.example.com" },
+		"wrong audience":   func(claims map[string]any) { claims["aud"] = "other" },
+		"issued in future": func(claims map[string]any) { claims["iat"] = now.Add(time.Hour).Unix() },
+		"missing tenant":   func(claims map[string]any) { delete(claims, "tenant_id") },
+	} {
+		claims := validClaims()
+		mutate(claims)
+		_, authErr := authenticator.Authenticate(ctx, signJWT(r, keys[0], claims))
+		r.ErrorIs(authErr, ErrInvalidCredential, name)
+	}
+	// Within the clock skew is fine
+	skewedClaims := validClaims()
+	skewedClaims["exp"] = now.Add(-DefaultJWTClockSkew / 2).Unix()
+	_, authErr := authenticator.Authenticate(ctx, signJWT(r, keys[1], skewedClaims))
+	r.NoError(authErr)
+
+	// Tampered payload
+	token := signJWT(r, keys[2], validClaims())
+	parts := strings.Split(token, ".")
+	tamperedClaims := validClaims()
+	tamperedClaims["scope"] = "users:read users:write stats:read"
+	parts[1] = encodeSegment(r, tamperedClaims)
+	_, authErr = authenticator.Authenticate(ctx, strings.Join(parts, "."))
+	r.ErrorIs(authErr, ErrInvalidCredential)
+
+	// A key can't be used with another algorithm
+	confused := keys[0]
+	confused.alg = "ES256"
+	_, authErr = authenticator.Authenticate(ctx, signJWT(r, confused, validClaims()))
+	r.ErrorIs(authErr, ErrInvalidCredential)
+
+	// Not a JWT at all
+	_, authErr = authenticator.Authenticate(ctx, "gt_abcd_efgh")
+	r.ErrorIs(authErr, ErrUnrecognized)
+}
+
+func TestJWKSCacheRotation(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	keys := newTestKeys(r)
+	dir := t.TempDir()
+	jwksPath := writeJWKS(r, dir, keys[0])
+	authenticator := NewJWTAuthenticator(NewJWKSCache(jwksPath, time.Hour), JWTConfig{})
+	claims := map[string]any{"sub": "user-42", "exp": time.Now().Add(time.Hour).Unix(), "tenant_id": 1}
+	_, authErr := authenticator.Authenticate(ctx, signJWT(r, keys[0], claims))
+	r.NoError(authErr)
+	_, authErr = authenticator.Authenticate(ctx, signJWT(r, keys[1], claims))
+	r.ErrorIs(authErr, ErrInvalidCredential)
+	// Rotate to the EC key. The file change is noticed without waiting for the refresh interval.
+	writeJWKS(r, dir, keys[1])
+	later := time.Now().Add(time.Second)
+	r.NoError(os.Chtimes(jwksPath, later, later))
+	_, authErr = authenticator.Authenticate(ctx, signJWT(r, keys[1], claims))
+	r.NoError(authErr)
+	_, authErr = authenticator.Authenticate(ctx, signJWT(r, keys[0], claims))
+	r.ErrorIs(authErr, ErrInvalidCredential)
+}
+
+func TestJWKSCacheFetchOutsideLock(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	keys := newTestKeys(r)
+	jwks, jwksErr := os.ReadFile(writeJWKS(r, t.TempDir(), keys[0]))
+	r.NoError(jwksErr)
+	fetching, release := make(chan struct{}, 1), make(chan struct{})
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			fetching <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+	cache := NewJWKSCache(server.URL, time.Hour)
+	_, keyErr := cache.Key(ctx, keys[0].kid)
+	r.NoError(keyErr)
+
+	failing.Store(true)
+	cache.mu.Lock()
+	cache.fetchedAt = time.Now().Add(-jwksMinRefreshInterval)
+	cache.mu.Unlock()
+	unknownErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Key(ctx, keys[1].kid)
+		unknownErr <- err
+	}()
+	<-fetching
+	// Cached keys are served while the fetch for the unknown key id is stuck
+	_, keyErr = cache.Key(ctx, keys[0].kid)
+	r.NoError(keyErr)
+	close(release)
+	r.ErrorIs(<-unknownErr, ErrInvalidCredential)
+}
+
+func signJWT(r *require.Assertions, key testKey, claims map[string]any) string {
+	signingInput := encodeSegment(r, map[string]string{"alg": key.alg, "kid": key.kid, "typ": "JWT"}) + "." + encodeSegment(r, claims)
+	var signature []byte
+	switch signer := key.signer.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signingInput))
+		signed, signErr := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+		r.NoError(signErr)
+		signature = signed
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signingInput))
+		rInt, sInt, signErr := ecdsa.Sign(rand.Reader, signer, digest[:])
+		r.NoError(signErr)
+		signature = append(rInt.FillBytes(make([]byte, 32)), sInt.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(signer, []byte(signingInput))
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeSegment(r *require.Assertions, value any) string {
+	data, dataErr := json.Marshal(value)
+	r.NoError(dataErr)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func writeJWKS(r *require.Assertions, dir string, keys ...testKey) string {
+	encode := func(value []byte) string {
+		return base64.RawURLEncoding.EncodeToString(value)
+	}
+	var jwks []map[string]string
+	for _, key := range keys {
+		switch publicKey := key.signer.Public().(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, map[string]string{"kty": "RSA", "kid": key.kid, "use": "sig", "n": encode(publicKey.N.Bytes()), "e": encode(big.NewInt(int64(publicKey.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			jwks = append(jwks, map[string]string{"kty": "EC", "kid": key.kid, "crv": "P-256", "x": encode(publicKey.X.FillBytes(make([]byte, 32))), "y": encode(publicKey.Y.FillBytes(make([]byte, 32)))})
+		case ed25519.PublicKey:
+			jwks = append(jwks, map[string]string{"kty": "OKP", "kid": key.kid, "crv": "Ed25519", "x": encode(publicKey)})
+		}
+	}
+	data, dataErr := json.Marshal(map[string]any{"keys": jwks})
+	r.NoError(dataErr)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	r.NoError(os.WriteFile(jwksPath, data, 0o600))
+	return jwksPath
+}
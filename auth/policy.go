@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Roles seeded by the roles migration.
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleUser    = "user"
+)
+
+// Permissions granted to roles in the role_permissions table. The :own variants only apply
+// to the user the principal acts as.
+const (
+	PermissionUsersCreate    = "users:create"
+	PermissionUsersReadAny   = "users:read:any"
+	PermissionUsersReadOwn   = "users:read:own"
+	PermissionUsersUpdateAny = "users:update:any"
+	PermissionUsersUpdateOwn = "users:update:own"
+	PermissionUsersDeleteAny = "users:delete:any"
+	PermissionUsersList      = "users:list"
+	PermissionUsersPII       = "users:pii"
+	PermissionStatsRead      = "stats:read"
+)
+
+const defaultPolicyCacheTTL = time.Minute
+
+// RoleStore loads role permissions. db.Client implements it.
+type RoleStore interface {
+	GetRolePermissions(ctx context.Context, role string) ([]string, error)
+}
+
+// Policy decides what a principal may do based on the permissions of its role. Permissions
+// are cached for a minute, so role changes take that long to apply.
+type Policy struct {
+	store RoleStore
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedPermissions
+}
+
+type cachedPermissions struct {
+	permissions map[string]bool
+	loadedAt    time.Time
+}
+
+func NewPolicy(store RoleStore) *Policy {
+	return &Policy{
+		store: store,
+		ttl:   defaultPolicyCacheTTL,
+		cache: make(map[string]cachedPermissions),
+	}
+}
+
+// Grants resolves the permissions of principal.
+func (p *Policy) Grants(ctx context.Context, principal *Principal) (*Grants, error) {
+	p.mu.Lock()
+	cached, found := p.cache[principal.Role]
+	p.mu.Unlock()
+	if !found || time.Since(cached.loadedAt) >= p.ttl {
+		permissions, permissionsErr := p.store.GetRolePermissions(ctx, principal.Role)
+		if permissionsErr != nil {
+			return nil, permissionsErr
+		}
+		cached = cachedPermissions{permissions: make(map[string]bool, len(permissions)), loadedAt: time.Now()}
+		for _, permission := range permissions {
+			cached.permissions[permission] = true
+		}
+		p.mu.Lock()
+		p.cache[principal.Role] = cached
+		p.mu.Unlock()
+	}
+	return &Grants{principal: principal, permissions: cached.permissions}, nil
+}
+
+// Grants are the resolved permissions of one principal.
+type Grants struct {
+	principal   *Principal
+	permissions map[string]bool
+}
+
+func (g *Grants) Can(permission string) bool {
+	return g.permissions[permission]
+}
+
+// CanOnUser reports whether the principal holds anyPermission, or holds ownPermission and
+// userId is the user it acts as.
+func (g *Grants) CanOnUser(anyPermission, ownPermission string, userId int64) bool {
+	return g.Can(anyPermission) || (g.Can(ownPermission) && g.owns(userId))
+}
+
+// CanOnSomeUser reports whether CanOnUser holds for at least one user, so callers that can't
+// act on any are refused before their request is read.
+func (g *Grants) CanOnSomeUser(anyPermission, ownPermission string) bool {
+	return g.Can(anyPermission) || (g.Can(ownPermission) && g.principal.UserId != 0)
+}
+
+// CanViewPII reports whether the email and birthday of userId may be shown. Everyone may
+// see their own.
+func (g *Grants) CanViewPII(userId int64) bool {
+	return g.Can(PermissionUsersPII) || g.owns(userId)
+}
+
+func (g *Grants) owns(userId int64) bool {
+	return g.principal.UserId != 0 && g.principal.UserId == userId
+}
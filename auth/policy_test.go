@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRoleStore struct {
+	permissions map[string][]string
+	loads       int
+}
+
+func (s *fakeRoleStore) GetRolePermissions(_ context.Context, role string) ([]string, error) {
+	s.loads++
+	return s.permissions[role], nil
+}
+
+func TestPolicy(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	store := &fakeRoleStore{permissions: map[string][]string{
+		RoleSupport: {PermissionUsersReadAny, PermissionUsersList},
+		RoleUser:    {PermissionUsersReadOwn, PermissionUsersUpdateOwn},
+	}}
+	policy := NewPolicy(store)
+
+	support, supportErr := policy.Grants(ctx, &Principal{Subject: "api_key:1", Role: RoleSupport})
+	r.NoError(supportErr)
+	r.True(support.CanOnUser(PermissionUsersReadAny, PermissionUsersReadOwn, 7))
+	r.False(support.CanOnUser(PermissionUsersUpdateAny, PermissionUsersUpdateOwn, 7))
+	r.False(support.CanViewPII(7))
+	r.False(support.CanOnSomeUser(PermissionUsersUpdateAny, PermissionUsersUpdateOwn))
+
+	user, userErr := policy.Grants(ctx, &Principal{Subject: "jwt:7", Role: RoleUser, UserId: 7})
+	r.NoError(userErr)
+	r.True(user.CanOnUser(PermissionUsersUpdateAny, PermissionUsersUpdateOwn, 7))
+	r.False(user.CanOnUser(PermissionUsersUpdateAny, PermissionUsersUpdateOwn, 8))
+	r.True(user.CanViewPII(7))
+	r.False(user.CanViewPII(8))
+	r.True(user.CanOnSomeUser(PermissionUsersUpdateAny, PermissionUsersUpdateOwn))
+
+	// Users not tied to a user id own nothing
+	anonymous, anonymousErr := policy.Grants(ctx, &Principal{Subject: "jwt:", Role: RoleUser})
+	r.NoError(anonymousErr)
+	r.False(anonymous.CanOnUser(PermissionUsersReadAny, PermissionUsersReadOwn, 0))
+	r.False(anonymous.CanOnSomeUser(PermissionUsersReadAny, PermissionUsersReadOwn))
+
+	// Unknown roles get nothing and permissions are cached per role
+	unknown, unknownErr := policy.Grants(ctx, &Principal{Subject: "jwt:x", Role: "intern"})
+	r.NoError(unknownErr)
+	r.False(unknown.Can(PermissionUsersList))
+	r.Equal(3, store.loads)
+}
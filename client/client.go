@@ -0,0 +1,243 @@
+// Package client calls the /v1.0 API with the models types, retrying failed requests that are
+// safe to repeat.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultTimeout     = 30 * time.Second
+	DefaultMaxAttempts = 3
+	DefaultMinBackoff  = 200 * time.Millisecond
+	DefaultMaxBackoff  = 5 * time.Second
+
+	apiPrefix = "/v1.0"
+)
+
+type Config struct {
+	// BaseURL is where the server is, e.g. https://api.example.com. The /v1.0 prefix is added.
+	BaseURL string
+	// Token is an API key or a JWT sent as a bearer token.
+	Token string
+	// Tenant is sent as X-Tenant, for platform credentials.
+	Tenant string
+	// HTTPClient defaults to a client without a timeout of its own, see Timeout.
+	HTTPClient *http.Client
+	// Timeout bounds each attempt, DefaultTimeout when zero. ListUsers is only bounded by its
+	// context because its response is streamed.
+	Timeout time.Duration
+	Retry   RetryConfig
+}
+
+// RetryConfig retries requests that failed with a network error, 429, 502, 503 or 504. GET, PUT
+// and DELETE are always safe to repeat and POST is too because it is sent with an
+// Idempotency-Key.
+type RetryConfig struct {
+	// MaxAttempts counts the first attempt, DefaultMaxAttempts when zero. 1 disables retries.
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry, doubled for each one after it up to
+	// MaxBackoff, with jitter. A Retry-After header takes precedence, unless it is longer than
+	// MaxBackoff, when the request fails at once instead.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	tenant     string
+	httpClient *http.Client
+	timeout    time.Duration
+	retry      RetryConfig
+}
+
+func New(config Config) (*Client, error) {
+	baseURL, baseURLErr := url.Parse(strings.TrimSuffix(config.BaseURL, "/"))
+	if baseURLErr != nil {
+		return nil, fmt.Errorf("base url: %w", baseURLErr)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("base url %q must be http or https", config.BaseURL)
+	}
+	client := &Client{
+		baseURL:    baseURL,
+		token:      config.Token,
+		tenant:     config.Tenant,
+		httpClient: config.HTTPClient,
+		timeout:    config.Timeout,
+		retry:      config.Retry,
+	}
+	if client.httpClient == nil {
+		client.httpClient = &http.Client{}
+	}
+	if client.timeout <= 0 {
+		client.timeout = DefaultTimeout
+	}
+	if client.retry.MaxAttempts <= 0 {
+		client.retry.MaxAttempts = DefaultMaxAttempts
+	}
+	if client.retry.MinBackoff <= 0 {
+		client.retry.MinBackoff = DefaultMinBackoff
+	}
+	if client.retry.MaxBackoff <= 0 {
+		client.retry.MaxBackoff = DefaultMaxBackoff
+	}
+	return client, nil
+}
+
+// request is one API call, possibly sent several times.
+type request struct {
+	method string
+	path   string
+	// body is encoded as JSON when not nil.
+	body any
+	// header is added to every attempt.
+	header http.Header
+}
+
+// do sends req and decodes a 2xx response into out when it isn't nil. Other responses become an
+// *Error.
+func (c *Client) do(ctx context.Context, req request, out any) (int, error) {
+	resp, cancel, respErr := c.send(ctx, req, true)
+	if respErr != nil {
+		return 0, respErr
+	}
+	defer cancel()
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("decoding the %s %s response: %w", req.method, req.path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// send sends req until it succeeds, fails for good or runs out of attempts, and returns the
+// successful response. Its body must be closed, then cancel called. When bounded is false the
+// attempts are only bounded by ctx.
+func (c *Client) send(ctx context.Context, req request, bounded bool) (*http.Response, context.CancelFunc, error) {
+	var body []byte
+	if req.body != nil {
+		var bodyErr error
+		if body, bodyErr = json.Marshal(req.body); bodyErr != nil {
+			return nil, nil, fmt.Errorf("encoding the %s %s request: %w", req.method, req.path, bodyErr)
+		}
+	}
+	target := c.baseURL.JoinPath(apiPrefix, req.path)
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if bounded {
+			attemptCtx, cancel = context.WithTimeout(ctx, c.timeout)
+		}
+		resp, respErr := c.attempt(attemptCtx, req, target, body)
+		if respErr == nil && resp.StatusCode < 300 {
+			return resp, cancel, nil
+		}
+		var err error
+		var retryAfter time.Duration
+		if respErr != nil {
+			err = fmt.Errorf("%s %s: %w", req.method, req.path, respErr)
+		} else {
+			err = readError(resp)
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		cancel()
+		if ctx.Err() != nil || attempt >= c.retry.MaxAttempts || !retryable(resp, respErr) || retryAfter > c.retry.MaxBackoff {
+			return nil, nil, err
+		}
+		wait := c.backoff(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, req request, target *url.URL, body []byte) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	httpReq, httpReqErr := http.NewRequestWithContext(ctx, req.method, target.String(), bodyReader)
+	if httpReqErr != nil {
+		return nil, httpReqErr
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		httpReq.Header.Set("X-Tenant", c.tenant)
+	}
+	return c.httpClient.Do(httpReq)
+}
+
+// readError turns a failed response into an *Error and closes its body.
+func readError(resp *http.Response) error {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return newError(resp, body)
+}
+
+func retryable(resp *http.Response, respErr error) bool {
+	if respErr != nil {
+		// Network errors, but not a cancelled or expired context
+		return !errors.Is(respErr, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is the wait before retry number attempt, with up to 50% jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.retry.MinBackoff << (attempt - 1)
+	if wait <= 0 || wait > c.retry.MaxBackoff {
+		wait = c.retry.MaxBackoff
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header in seconds. HTTP dates aren't used by the API.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// idempotencyKey makes a POST safe to retry, see middleware.Idempotency.
+func idempotencyKey() http.Header {
+	return http.Header{"Idempotency-Key": {uuid.NewString()}}
+}
@@ -0,0 +1,255 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/client"
+	"github.com/brandonrachal/gin-and-tonic/controllers"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/internal/testenv"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/middleware"
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	dbClient *db.Client
+	router   *gin.Engine
+	apiKey   string
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	exitCode := testMain(m)
+	os.Exit(exitCode)
+}
+
+// testMain serves the real router from a database of its own, so these tests don't share the
+// test database with the controllers tests.
+func testMain(m *testing.M) int {
+	logger := logging.New(os.Stdout, logging.FormatText, slog.LevelError)
+	env, envErr := testenv.New(context.Background(), "client_test", logger, controllers.RouterOptions{
+		Contract: middleware.ContractConfig{Requests: middleware.ContractEnforce, Responses: middleware.ContractEnforce},
+	})
+	if envErr != nil {
+		log.Printf("Error: Couldn't set up the test env - %s\n", envErr)
+		return 1
+	}
+	defer func() {
+		_ = env.Close()
+	}()
+	dbClient, apiKey, router = env.DB, env.APIKey, env.Router
+	return m.Run()
+}
+
+func issueAPIKey(ctx context.Context, scopes []string) (string, error) {
+	key, prefix, hash, keyErr := auth.GenerateAPIKey()
+	if keyErr != nil {
+		return "", keyErr
+	}
+	tenantId := db.DefaultTenantId
+	_, createErr := dbClient.CreateAPIKey(ctx, "client_test", prefix, hash, scopes, auth.RoleAdmin, nil, &tenantId)
+	return key, createErr
+}
+
+// newClient returns a client of a server running handler, the router when nil.
+func newClient(t *testing.T, handler http.Handler, config client.Config) *client.Client {
+	if handler == nil {
+		handler = router
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	config.BaseURL = server.URL
+	if config.Token == "" {
+		config.Token = apiKey
+	}
+	if config.Retry.MinBackoff == 0 {
+		config.Retry.MinBackoff = time.Millisecond
+	}
+	apiClient, apiClientErr := client.New(config)
+	require.NoError(t, apiClientErr)
+	return apiClient
+}
+
+func newUser(r *require.Assertions, email string) models.CreateUser {
+	user, userErr := models.GetCreateUser("Client", "User", email, "1990-04-02")
+	r.NoError(userErr)
+	return *user
+}
+
+func TestUsers(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	apiClient := newClient(t, nil, client.Config{})
+
+	id, createErr := apiClient.CreateUser(ctx, newUser(r, "client.user@example.com"))
+	r.NoError(createErr)
+	user, getErr := apiClient.GetUser(ctx, id)
+	r.NoError(getErr)
+	r.Equal("client.user@example.com", user.Email)
+	r.Equal("1990-04-02", user.Birthday.String())
+
+	user.FirstName = "Updated"
+	r.NoError(apiClient.UpdateUser(ctx, *user))
+	user, getErr = apiClient.GetUser(ctx, id)
+	r.NoError(getErr)
+	r.Equal("Updated", user.FirstName)
+
+	upsert := models.UpsertUser{FirstName: "Upsert", LastName: "User", Birthday: user.Birthday}
+	upsertId, created, upsertErr := apiClient.UpsertUserByEmail(ctx, "upsert+client@example.com", upsert)
+	r.NoError(upsertErr)
+	r.True(created)
+	sameId, created, upsertErr := apiClient.UpsertUserByEmail(ctx, "upsert+client@example.com", upsert)
+	r.NoError(upsertErr)
+	r.False(created)
+	r.Equal(upsertId, sameId)
+
+	var emails []string
+	for listed, err := range apiClient.ListUsers(ctx) {
+		r.NoError(err)
+		emails = append(emails, listed.Email)
+	}
+	r.ElementsMatch([]string{"client.user@example.com", "upsert+client@example.com"}, emails)
+	for _, err := range apiClient.ListUsers(ctx) {
+		// Breaking out early closes the stream
+		r.NoError(err)
+		break
+	}
+
+	usersWithAge, usersWithAgeErr := apiClient.UsersWithAge(ctx)
+	r.NoError(usersWithAgeErr)
+	r.Len(usersWithAge, 2)
+	stats, statsErr := apiClient.AgeStats(ctx)
+	r.NoError(statsErr)
+	r.Equal(2, stats.Thirties)
+
+	r.NoError(apiClient.DeleteUser(ctx, upsertId))
+	_, getErr = apiClient.GetUser(ctx, upsertId)
+	r.ErrorIs(getErr, client.ErrNotFound)
+	r.NoError(apiClient.DeleteUser(ctx, id))
+}
+
+func TestErrors(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	_, err := newClient(t, nil, client.Config{Token: "gt_nope"}).AgeStats(ctx)
+	r.ErrorIs(err, client.ErrUnauthorized)
+	var apiErr *client.Error
+	r.ErrorAs(err, &apiErr)
+	r.Equal(http.StatusUnauthorized, apiErr.StatusCode)
+	r.NotEmpty(apiErr.RequestId)
+
+	readOnlyKey, readOnlyKeyErr := issueAPIKey(ctx, []string{auth.ScopeUsersRead})
+	r.NoError(readOnlyKeyErr)
+	_, err = newClient(t, nil, client.Config{Token: readOnlyKey}).AgeStats(ctx)
+	r.ErrorIs(err, client.ErrForbidden)
+	r.ErrorContains(err, "missing scope "+auth.ScopeStatsRead)
+
+	// Problems list each invalid field
+	invalid := newUser(r, "")
+	_, err = newClient(t, nil, client.Config{}).CreateUser(ctx, invalid)
+	r.ErrorIs(err, client.ErrBadRequest)
+	r.ErrorAs(err, &apiErr)
+	r.Equal("body.email", apiErr.Errors[0].Location)
+
+	_, err = client.New(client.Config{BaseURL: "localhost:8080"})
+	r.Error(err)
+}
+
+func TestRetries(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	// The first response is lost, and the retry with the same Idempotency-Key gets it replayed
+	var attempts atomic.Int32
+	var keys []string
+	flaky := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		keys = append(keys, req.Header.Get(middleware.IdempotencyKeyHeader))
+		if attempts.Add(1) == 1 {
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		router.ServeHTTP(w, req)
+	})
+	id, createErr := newClient(t, flaky, client.Config{}).CreateUser(ctx, newUser(r, "retried@example.com"))
+	r.NoError(createErr)
+	r.Equal(int32(2), attempts.Load())
+	r.Equal(keys[0], keys[1])
+	count := 0
+	for user, err := range newClient(t, nil, client.Config{}).ListUsers(ctx) {
+		r.NoError(err)
+		if user.Email == "retried@example.com" {
+			count++
+		}
+	}
+	r.Equal(1, count)
+	r.NoError(newClient(t, nil, client.Config{}).DeleteUser(ctx, id))
+
+	// Rate limited requests wait for Retry-After, and give up after MaxAttempts
+	attempts.Store(0)
+	limited := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.Header().Set("Content-Type", middleware.ProblemContentType)
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"rate limit exceeded"}`))
+	})
+	_, err := newClient(t, limited, client.Config{Retry: client.RetryConfig{MaxAttempts: 4}}).AgeStats(ctx)
+	r.ErrorIs(err, client.ErrRateLimited)
+	r.ErrorContains(err, "rate limit exceeded")
+	r.Equal(int32(4), attempts.Load())
+
+	// A Retry-After longer than MaxBackoff isn't waited for
+	attempts.Store(0)
+	quota := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	_, err = newClient(t, quota, client.Config{Retry: client.RetryConfig{MaxAttempts: 4}}).AgeStats(ctx)
+	r.ErrorIs(err, client.ErrRateLimited)
+	r.Equal(int32(1), attempts.Load())
+
+	// Client errors aren't retried
+	attempts.Store(0)
+	missing := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts.Add(1)
+		http.NotFound(w, req)
+	})
+	_, err = newClient(t, missing, client.Config{}).AgeStats(ctx)
+	r.ErrorIs(err, client.ErrNotFound)
+	r.Equal(int32(1), attempts.Load())
+
+	// Each attempt has its own timeout
+	attempts.Store(0)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if attempts.Add(1) == 1 {
+			<-req.Context().Done()
+			return
+		}
+		router.ServeHTTP(w, req)
+	})
+	_, err = newClient(t, slow, client.Config{Timeout: 50 * time.Millisecond}).AgeStats(ctx)
+	r.NoError(err)
+	r.Equal(int32(2), attempts.Load())
+
+	// A cancelled context stops the retries
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = newClient(t, nil, client.Config{}).AgeStats(cancelled)
+	r.True(errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+// Error is a response the API failed with. errors.Is matches it with the Err* value of its
+// status, e.g. ErrNotFound.
+type Error struct {
+	StatusCode int
+	// Message is the error or problem detail of the body.
+	Message   string
+	RequestId string
+	// Errors lists each invalid part of the request when the server checks requests against its
+	// OpenAPI document.
+	Errors []api.ProblemError
+}
+
+func newError(resp *http.Response, body []byte) *Error {
+	apiErr := &Error{StatusCode: resp.StatusCode, RequestId: resp.Header.Get("X-Request-ID")}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/problem+json":
+		var problem api.Problem
+		if json.Unmarshal(body, &problem) == nil {
+			apiErr.Message = problem.Detail
+			if apiErr.Message == "" {
+				apiErr.Message = problem.Title
+			}
+			apiErr.Errors = problem.Errors
+		}
+	case mediaType == "application/json":
+		var errorMessage api.ErrorMessage
+		if json.Unmarshal(body, &errorMessage) == nil {
+			apiErr.Message = errorMessage.Error
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+	for _, problemErr := range e.Errors {
+		message += fmt.Sprintf("; %s %s", problemErr.Location, problemErr.Message)
+	}
+	if e.RequestId != "" {
+		message += " (request id " + e.RequestId + ")"
+	}
+	return message
+}
+
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity:
+		return ErrBadRequest
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
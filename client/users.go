@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+)
+
+// CreateUser creates user and returns its id.
+func (c *Client) CreateUser(ctx context.Context, user models.CreateUser) (int64, error) {
+	var message api.IdUserMessage
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/user", body: user, header: idempotencyKey()}, &message)
+	return message.User.Id, err
+}
+
+// GetUser returns the user with id. Fields the caller may not see are empty.
+func (c *Client) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	var message api.UserMessage
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/user", body: models.GetIdUser(id)}, &message); err != nil {
+		return nil, err
+	}
+	return &message.User, nil
+}
+
+// UpdateUser replaces every field of the user with user.Id.
+func (c *Client) UpdateUser(ctx context.Context, user models.User) error {
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/user", body: user}, nil)
+	return err
+}
+
+// UpsertUserByEmail creates the user with email or updates it, and reports whether it was
+// created.
+func (c *Client) UpsertUserByEmail(ctx context.Context, email string, user models.UpsertUser) (int64, bool, error) {
+	var message api.IdUserMessage
+	status, err := c.do(ctx, request{method: http.MethodPut, path: "/users/by_email/" + url.PathEscape(email), body: user}, &message)
+	return message.User.Id, status == http.StatusCreated, err
+}
+
+func (c *Client) DeleteUser(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/user", body: models.GetIdUser(id)}, nil)
+	return err
+}
+
+// ListUsers yields every user as the server streams them, without holding the whole list in
+// memory. Stop ranging to close the connection early. An error ends the sequence, and a
+// connection lost midway is not retried since users were already yielded.
+func (c *Client) ListUsers(ctx context.Context) iter.Seq2[models.User, error] {
+	return func(yield func(models.User, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		resp, _, respErr := c.send(ctx, request{method: http.MethodGet, path: "/users"}, false)
+		if respErr != nil {
+			yield(models.User{}, respErr)
+			return
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		for user, err := range decodeArray[models.User](json.NewDecoder(resp.Body), "users") {
+			if err != nil {
+				err = fmt.Errorf("reading the users: %w", err)
+			}
+			if !yield(user, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) UsersWithAge(ctx context.Context) ([]models.UserWithAge, error) {
+	var message api.UsersWithAgeMessage
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/users_with_age"}, &message); err != nil {
+		return nil, err
+	}
+	return message.Users, nil
+}
+
+func (c *Client) AgeStats(ctx context.Context) (*models.AgeStats, error) {
+	var message api.AgeStatsMessage
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/age_stats"}, &message); err != nil {
+		return nil, err
+	}
+	return &message.AgeStats, nil
+}
+
+// decodeArray yields the items of the array at key in a JSON object one at a time. Other keys
+// are skipped.
+func decodeArray[T any](decoder *json.Decoder, key string) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		if err := expectDelim(decoder, '{'); err != nil {
+			yield(zero, err)
+			return
+		}
+		for decoder.More() {
+			token, tokenErr := decoder.Token()
+			if tokenErr != nil {
+				yield(zero, tokenErr)
+				return
+			}
+			if token != key {
+				var skipped json.RawMessage
+				if err := decoder.Decode(&skipped); err != nil {
+					yield(zero, err)
+					return
+				}
+				continue
+			}
+			start, startErr := decoder.Token()
+			if startErr != nil {
+				yield(zero, startErr)
+				return
+			} else if start == nil {
+				// A null array
+				continue
+			} else if start != json.Delim('[') {
+				yield(zero, fmt.Errorf("expected [, got %v", start))
+				return
+			}
+			for decoder.More() {
+				var item T
+				if err := decoder.Decode(&item); err != nil {
+					yield(zero, err)
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if err := expectDelim(decoder, ']'); err != nil {
+				yield(zero, err)
+				return
+			}
+		}
+		if err := expectDelim(decoder, '}'); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, tokenErr := decoder.Token()
+	if tokenErr != nil {
+		return tokenErr
+	}
+	if token != delim {
+		return fmt.Errorf("expected %s, got %v", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/brandonrachal/go-toolbox/cliutils"
+)
+
+const usage = `Usage: api_key_client [-env prod|dev|test] <command> [arguments]
+
+Commands:
+  issue [-role admin|support|user] [-tenant slug|-platform] [-user-id id] <name> <scopes>
+                         Create a key with comma separated scopes and print it once.
+                         Keys belong to the default organization unless -tenant says otherwise.
+                         -platform keys aren't bound to one and select it with the X-Tenant header.
+                         -user-id ties the key to a user for roles limited to their own record
+  revoke <id>            Revoke a key so it can't be used any more
+  rotate <id>            Replace the secret of a key and print the new key once
+  list                   List all keys as JSON
+  cert-add [-role admin|support|user] [-tenant slug|-platform] [-user-id id] <subject> <scopes>
+                         Give the holders of client certificates with this subject, e.g.
+                         "CN=billing,O=Acme", an identity for mutual TLS
+  cert-revoke <id>       Stop accepting a client certificate subject
+  cert-list              List all client certificate subjects as JSON
+
+Scopes: users:read, users:write, stats:read
+`
+
+func main() {
+	ctx, cancelFunc := cliutils.InitSignals(context.Background())
+	defer cancelFunc()
+
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	env := flag.String("env", "prod", "database environment: prod, dev or test")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dbClient, dbClientErr := internal.DBClient(*env)
+	if dbClientErr != nil {
+		fmt.Printf("error getting the db client - %s\n", dbClientErr)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = dbClient.Close()
+	}()
+
+	var cmdErr error
+	switch args[0] {
+	case "issue":
+		cmdErr = issue(ctx, dbClient, args[1:])
+	case "revoke":
+		cmdErr = revoke(ctx, dbClient, args[1:])
+	case "rotate":
+		cmdErr = rotate(ctx, dbClient, args[1:])
+	case "list":
+		cmdErr = list(ctx, dbClient)
+	case "cert-add":
+		cmdErr = certAdd(ctx, dbClient, args[1:])
+	case "cert-revoke":
+		cmdErr = certRevoke(ctx, dbClient, args[1:])
+	case "cert-list":
+		cmdErr = certList(ctx, dbClient)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	if cmdErr != nil {
+		fmt.Printf("error running %s - %s\n", args[0], cmdErr)
+		os.Exit(1)
+	}
+}
+
+func issue(ctx context.Context, dbClient *db.Client, args []string) error {
+	flags := flag.NewFlagSet("issue", flag.ContinueOnError)
+	identity := addIdentityFlags(flags, "key")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return parseErr
+	}
+	args = flags.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("expected <name> <scopes>")
+	}
+	scopes, userId, tenantId, identityErr := identity.resolve(ctx, dbClient, args[1])
+	if identityErr != nil {
+		return identityErr
+	}
+	key, prefix, hash, keyErr := auth.GenerateAPIKey()
+	if keyErr != nil {
+		return keyErr
+	}
+	result, createErr := dbClient.CreateAPIKey(ctx, args[0], prefix, hash, scopes, *identity.role, userId, tenantId)
+	if createErr != nil {
+		return createErr
+	}
+	id, idErr := result.LastInsertId()
+	if idErr != nil {
+		return idErr
+	}
+	fmt.Printf("Issued key %d. Store it now, it can't be shown again:\n%s\n", id, key)
+	return nil
+}
+
+// identityFlags are the flags of the identity a key or client certificate grants.
+type identityFlags struct {
+	role     *string
+	userId   *int64
+	tenant   *string
+	platform *bool
+}
+
+func addIdentityFlags(flags *flag.FlagSet, credential string) *identityFlags {
+	return &identityFlags{
+		role:     flags.String("role", auth.RoleAdmin, "role whose permissions the "+credential+" gets"),
+		userId:   flags.Int64("user-id", 0, "user the "+credential+" acts as"),
+		tenant:   flags.String("tenant", "default", "slug of the organization the "+credential+" belongs to"),
+		platform: flags.Bool("platform", false, "don't bind the "+credential+" to an organization"),
+	}
+}
+
+// resolve checks the scopes, role, tenant and user exist, returning the parsed scopes and the
+// user and tenant ids to store.
+func (f *identityFlags) resolve(ctx context.Context, dbClient *db.Client, scopeList string) ([]string, *int64, *int64, error) {
+	scopes, scopesErr := auth.ParseScopes(scopeList)
+	if scopesErr != nil {
+		return nil, nil, nil, scopesErr
+	} else if len(scopes) == 0 {
+		return nil, nil, nil, fmt.Errorf("at least one scope is required")
+	}
+	if _, roleErr := dbClient.GetRole(ctx, *f.role); roleErr != nil {
+		return nil, nil, nil, fmt.Errorf("looking up role %q - %w", *f.role, roleErr)
+	}
+	var tenantId *int64
+	if !*f.platform {
+		organization, organizationErr := dbClient.GetOrganizationBySlug(ctx, *f.tenant)
+		if organizationErr != nil {
+			return nil, nil, nil, fmt.Errorf("looking up tenant %q - %w", *f.tenant, organizationErr)
+		}
+		tenantId = &organization.Id
+	}
+	var userId *int64
+	if *f.userId != 0 {
+		if tenantId == nil {
+			return nil, nil, nil, fmt.Errorf("platform credentials can't act as a user")
+		}
+		if _, userErr := dbClient.GetUser(db.WithTenant(ctx, *tenantId), *f.userId); userErr != nil {
+			return nil, nil, nil, fmt.Errorf("looking up user %d - %w", *f.userId, userErr)
+		}
+		userId = f.userId
+	}
+	return scopes, userId, tenantId, nil
+}
+
+func revoke(ctx context.Context, dbClient *db.Client, args []string) error {
+	id, idErr := parseId(args)
+	if idErr != nil {
+		return idErr
+	}
+	result, revokeErr := dbClient.RevokeAPIKey(ctx, id)
+	if revokeErr != nil {
+		return revokeErr
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("no live key with id %d", id)
+	}
+	fmt.Printf("Revoked key %d\n", id)
+	return nil
+}
+
+func rotate(ctx context.Context, dbClient *db.Client, args []string) error {
+	id, idErr := parseId(args)
+	if idErr != nil {
+		return idErr
+	}
+	key, prefix, hash, keyErr := auth.GenerateAPIKey()
+	if keyErr != nil {
+		return keyErr
+	}
+	result, rotateErr := dbClient.RotateAPIKey(ctx, id, prefix, hash)
+	if rotateErr != nil {
+		return rotateErr
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("no live key with id %d", id)
+	}
+	fmt.Printf("Rotated key %d. The old key no longer works. Store the new one now:\n%s\n", id, key)
+	return nil
+}
+
+func list(ctx context.Context, dbClient *db.Client) error {
+	apiKeys, apiKeysErr := dbClient.GetAPIKeys(ctx)
+	if apiKeysErr != nil {
+		return apiKeysErr
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(apiKeys)
+}
+
+func certAdd(ctx context.Context, dbClient *db.Client, args []string) error {
+	flags := flag.NewFlagSet("cert-add", flag.ContinueOnError)
+	identity := addIdentityFlags(flags, "certificate")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return parseErr
+	}
+	args = flags.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("expected <subject> <scopes>")
+	}
+	scopes, userId, tenantId, identityErr := identity.resolve(ctx, dbClient, args[1])
+	if identityErr != nil {
+		return identityErr
+	}
+	result, createErr := dbClient.CreateClientCertificate(ctx, args[0], scopes, *identity.role, userId, tenantId)
+	if createErr != nil {
+		return createErr
+	}
+	id, idErr := result.LastInsertId()
+	if idErr != nil {
+		return idErr
+	}
+	fmt.Printf("Added client certificate %d for %s\n", id, args[0])
+	return nil
+}
+
+func certRevoke(ctx context.Context, dbClient *db.Client, args []string) error {
+	id, idErr := parseId(args)
+	if idErr != nil {
+		return idErr
+	}
+	result, revokeErr := dbClient.RevokeClientCertificate(ctx, id)
+	if revokeErr != nil {
+		return revokeErr
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("no live client certificate with id %d", id)
+	}
+	fmt.Printf("Revoked client certificate %d\n", id)
+	return nil
+}
+
+func certList(ctx context.Context, dbClient *db.Client) error {
+	clientCertificates, clientCertificatesErr := dbClient.GetClientCertificates(ctx)
+	if clientCertificatesErr != nil {
+		return clientCertificatesErr
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(clientCertificates)
+}
+
+func parseId(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected <id>")
+	}
+	return strconv.ParseInt(args[0], 10, 64)
+}
@@ -2,22 +2,70 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/config"
 	"github.com/brandonrachal/gin-and-tonic/controllers"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/health"
+	"github.com/brandonrachal/gin-and-tonic/https"
 	"github.com/brandonrachal/gin-and-tonic/internal"
-	"github.com/gin-gonic/gin"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/metrics"
+	"github.com/brandonrachal/gin-and-tonic/middleware"
+	"github.com/brandonrachal/gin-and-tonic/migrations"
+	"github.com/brandonrachal/gin-and-tonic/ratelimit"
+	"github.com/brandonrachal/gin-and-tonic/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
-	logger.SetOutput(gin.DefaultWriter)
+	// Calling os.Exit() kills the program immediately and doesn't call any deferred methods, like
+	// the trace flush. So the easiest way to fix this is to call another method.
+	exitCode := run()
+	os.Exit(exitCode)
+}
+
+func run() int {
+	appConfig, args, configErr := config.Load("api_server", os.Args[1:])
+	if errors.Is(configErr, flag.ErrHelp) {
+		return 0
+	} else if configErr != nil {
+		fmt.Printf("Could not load the configuration - %s\n", configErr)
+		return 1
+	}
+	if config.IsPrintCommand(args) {
+		if err := appConfig.Print(os.Stdout); err != nil {
+			fmt.Printf("Could not print the configuration - %s\n", err)
+			return 1
+		}
+		return 0
+	} else if len(args) > 0 {
+		fmt.Printf("Unknown command %q, the only one is \"config print\"\n", strings.Join(args, " "))
+		return 1
+	}
+
+	logger, loggerErr := newLogger(appConfig.Log)
+	if loggerErr != nil {
+		fmt.Printf("Could not configure logging - %s\n", loggerErr)
+		return 1
+	}
+	slog.SetDefault(logger)
+	logger.Info("Loaded the configuration", slog.String("env", appConfig.Env), slog.String("file", appConfig.File))
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
@@ -29,32 +77,328 @@ func main() {
 		cancelFunc()
 	}()
 
-	dbClient, dbClientErr := internal.ProdDBClient()
+	// Statements are prepared against the schema, so it is checked first
+	migrateMode, migrateModeErr := migrations.ParseMode(appConfig.Database.Migrate)
+	if migrateModeErr != nil {
+		logger.Error("Invalid database.migrate", slog.Any("error", migrateModeErr))
+		return 1
+	}
+	if err := migrations.Guard(ctx, logger, appConfig.Database.Path, internal.MigrationTable, migrateMode); err != nil {
+		var pendingErr *migrations.PendingError
+		if errors.As(err, &pendingErr) {
+			logger.Error("Refusing to start with pending migrations, run migration_client up-all or set DATABASE_MIGRATE=auto", slog.Any("error", err))
+		} else {
+			logger.Error("Could not check the database schema", slog.Any("error", err))
+		}
+		return 1
+	}
+
+	newDBClient := db.NewClient
+	if migrateMode == migrations.Warn {
+		newDBClient = db.NewLazyClient
+	}
+	dbClient, dbClientErr := newDBClient(appConfig.Database.Path)
 	if dbClientErr != nil {
-		logger.Fatalf("Could not retrieve the db client - %s\n", dbClientErr)
+		logger.Error("Could not retrieve the db client", slog.Any("error", dbClientErr))
+		return 1
+	}
+	defer func() {
+		if err := dbClient.Close(); err != nil {
+			logger.Error("Could not close the db client", slog.Any("error", err))
+		}
+	}()
+
+	var authenticators []auth.Authenticator
+	if jwksLocation := appConfig.Auth.JWKS; jwksLocation != "" {
+		logger.Info("Accepting JWTs", slog.String("jwks", jwksLocation))
+		jwtConfig := auth.JWTConfig{
+			Issuer:    appConfig.Auth.Issuer,
+			Audience:  appConfig.Auth.Audience,
+			ClockSkew: auth.DefaultJWTClockSkew,
+		}
+		authenticators = append(authenticators, auth.NewJWTAuthenticator(auth.NewJWKSCache(jwksLocation, auth.DefaultJWKSRefreshInterval), jwtConfig))
+	}
+
+	rateLimiter, rateLimiterErr := newRateLimiter(dbClient, appConfig.RateLimit)
+	if rateLimiterErr != nil {
+		logger.Error("Could not configure rate limiting", slog.Any("error", rateLimiterErr))
+		return 1
+	}
+
+	tracerProvider, tracerProviderErr := newTracerProvider(ctx, appConfig.Tracing)
+	if tracerProviderErr != nil {
+		logger.Error("Could not configure tracing", slog.Any("error", tracerProviderErr))
+		return 1
 	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), appConfig.Server.ShutdownTimeout)
+		defer cancel()
+		if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Could not flush traces", slog.Any("error", err))
+		}
+	}()
+	dbClient.AddQueryObserver(tracing.NewQueryObserver(tracerProvider))
 
-	router := controllers.GetRouter(logger, dbClient)
+	healthChecker := health.NewChecker(dbClient, health.Config{
+		MigrationTable: internal.MigrationTable,
+		Migrations:     migrations.FS,
+		DataDir:        filepath.Dir(appConfig.Database.Path),
+		MinFreeBytes:   health.DefaultMinFreeBytes,
+		Timeout:        health.DefaultTimeout,
+	})
+
+	// Metrics are served on the main port unless metrics.addr gives them an admin port of their own.
+	appMetrics := metrics.New(dbClient)
+	metricsAddr := appConfig.Metrics.Addr
+	clientAuth, clientAuthErr := https.ParseClientAuth(appConfig.TLS.ClientAuth)
+	if clientAuthErr != nil {
+		logger.Error("Could not configure TLS", slog.Any("error", clientAuthErr))
+		return 1
+	}
+	contract, contractErr := newContractConfig(appConfig.Contract)
+	if contractErr != nil {
+		logger.Error("Could not configure contract checks", slog.Any("error", contractErr))
+		return 1
+	}
+	trustedProxies, trustedProxiesErr := middleware.ParseTrustedProxies(appConfig.Server.TrustedProxies)
+	if trustedProxiesErr != nil {
+		logger.Error("Invalid server.trusted_proxies", slog.Any("error", trustedProxiesErr))
+		return 1
+	}
+	router := controllers.GetRouter(logger, dbClient, controllers.RouterOptions{
+		Authenticators:     authenticators,
+		ClientCertificates: clientAuth != tls.NoClientCert,
+		TenantBaseDomain:   appConfig.Server.TenantBaseDomain,
+		RateLimiter:        rateLimiter,
+		Metrics:            appMetrics,
+		ServeMetrics:       metricsAddr == "",
+		TracerProvider:     tracerProvider,
+		Health:             healthChecker,
+		Contract:           contract,
+		TrustedProxies:     trustedProxies,
+	})
 	srv := &http.Server{
-		Addr:    ":8080",
+		Addr:    appConfig.Server.Addr,
 		Handler: router,
 	}
 
-	logger.Println("Starting server on port 8080")
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Fatalf("failed to start server - %s\n", err)
+	servers := []*http.Server{srv}
+	var http3Server *https.HTTP3Server
+	if appConfig.TLS.Enabled() {
+		reloader, reloaderErr := newCertReloader(ctx, logger, appConfig.TLS)
+		if reloaderErr != nil {
+			logger.Error("Could not load the TLS certificate", slog.Any("error", reloaderErr))
+			return 1
 		}
-	}()
+		srv.TLSConfig = reloader.TLSConfig(clientAuth)
+		if http3Addr := appConfig.Server.HTTP3Addr; http3Addr != "" {
+			var http3Err error
+			if http3Server, http3Err = https.ListenHTTP3(http3Addr, router, srv.TLSConfig); http3Err != nil {
+				logger.Error("Could not listen for HTTP/3", slog.String("addr", http3Addr), slog.Any("error", http3Err))
+				return 1
+			}
+			srv.Handler = http3Server.Advertise(router)
+		}
+		if redirectAddr := appConfig.TLS.RedirectAddr; redirectAddr != "" {
+			servers = append(servers, &http.Server{
+				Addr:    redirectAddr,
+				Handler: https.RedirectHandler(srv.Addr),
+			})
+		}
+	}
+	if metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", appMetrics.Handler())
+		servers = append(servers, &http.Server{
+			Addr:    metricsAddr,
+			Handler: metricsMux,
+		})
+	}
+
+	// A server that can't start shuts the others down, and the process exits with 1 once they are
+	var serveFailed atomic.Bool
+	for _, server := range servers {
+		logger.Info("Starting server", slog.String("addr", server.Addr), slog.Bool("tls", server.TLSConfig != nil))
+		go func() {
+			var err error
+			if server.TLSConfig != nil {
+				// The certificate comes from TLSConfig.GetCertificate.
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Failed to start server", slog.String("addr", server.Addr), slog.Any("error", err))
+				serveFailed.Store(true)
+				cancelFunc()
+			}
+		}()
+	}
+	if http3Server != nil {
+		logger.Info("Starting HTTP/3 server", slog.String("addr", http3Server.Addr().String()))
+		go func() {
+			if err := http3Server.Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Failed to start HTTP/3 server", slog.Any("error", err))
+				serveFailed.Store(true)
+				cancelFunc()
+			}
+		}()
+	}
 	<-ctx.Done()
 	cancelFunc()
 
-	logger.Println("shutting down gracefully, press Ctrl+C again to force")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	logger.Info("Shutting down gracefully, press Ctrl+C again to force")
+	// Fail /readyz first so load balancers stop routing here before connections are refused.
+	healthChecker.ShutDown()
+	time.Sleep(appConfig.Server.ShutdownDrainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), appConfig.Server.ShutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatalf("Server forced to shutdown - %s\n", err)
+	if err := shutDown(ctx, servers, http3Server); err != nil {
+		logger.Error("Servers forced to shutdown", slog.Any("error", err))
+		return 1
+	}
+	logger.Info("Server exiting")
+	if serveFailed.Load() {
+		return 1
+	}
+	return 0
+}
+
+// shutDown shuts every server down at once, so a slow one doesn't use up the others' time, and
+// joins their errors.
+func shutDown(ctx context.Context, servers []*http.Server, http3Server *https.HTTP3Server) error {
+	errs := make([]error, len(servers)+1)
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("%s - %w", server.Addr, err)
+			}
+		}()
+	}
+	if http3Server != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := http3Server.Shutdown(ctx); err != nil {
+				errs[len(servers)] = fmt.Errorf("HTTP/3 %s - %w", http3Server.Addr(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// newCertReloader loads the certificates and reloads them on SIGHUP and, unless
+// tls.reload_interval is 0, whenever their files change.
+func newCertReloader(ctx context.Context, logger *slog.Logger, tlsConfig config.TLSConfig) (*https.Reloader, error) {
+	reloader, reloaderErr := https.NewReloader(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile)
+	if reloaderErr != nil {
+		return nil, reloaderErr
+	}
+	logReload := func(err error) {
+		if err != nil {
+			logger.Error("Could not reload the TLS certificate, still serving the previous one", slog.Any("error", err))
+			return
+		}
+		logger.Info("Reloaded the TLS certificate")
+	}
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				// Stopping would restore the default action and let a SIGHUP during shutdown kill
+				// the process, so it stays ignored until exit
+				signal.Ignore(syscall.SIGHUP)
+				return
+			case <-hups:
+				logReload(reloader.Reload())
+			}
+		}
+	}()
+	if tlsConfig.ReloadInterval > 0 {
+		go reloader.Watch(ctx, tlsConfig.ReloadInterval, logReload)
+	}
+	return reloader, nil
+}
+
+// newRateLimiter returns nil when rate_limit.limit is empty.
+func newRateLimiter(dbClient *db.Client, rateLimitConfig config.RateLimitConfig) (*ratelimit.Limiter, error) {
+	if rateLimitConfig.Limit == "" {
+		return nil, nil
+	}
+	limiterConfig := ratelimit.Config{DailyQuota: rateLimitConfig.DailyQuota}
+	var err error
+	if limiterConfig.Default, err = ratelimit.ParseLimit(rateLimitConfig.Limit); err != nil {
+		return nil, err
+	}
+	if limiterConfig.Routes, err = ratelimit.ParseRouteLimits(rateLimitConfig.Routes); err != nil {
+		return nil, err
+	}
+	if rateLimitConfig.IPLimit != "" {
+		if limiterConfig.IPLimit, err = ratelimit.ParseLimit(rateLimitConfig.IPLimit); err != nil {
+			return nil, err
+		}
+	}
+	if limiterConfig.KeyBy, err = ratelimit.ParseKeyBy(rateLimitConfig.Key); err != nil {
+		return nil, err
+	}
+	switch rateLimitConfig.Store {
+	case "memory":
+		return ratelimit.NewLimiter(ratelimit.NewMemoryStore(), limiterConfig), nil
+	case "sqlite":
+		return ratelimit.NewLimiter(dbClient, limiterConfig), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", rateLimitConfig.Store)
+	}
+}
+
+func newLogger(logConfig config.LogConfig) (*slog.Logger, error) {
+	format, formatErr := logging.ParseFormat(logConfig.Format)
+	if formatErr != nil {
+		return nil, formatErr
+	}
+	level, levelErr := logging.ParseLevel(logConfig.Level)
+	if levelErr != nil {
+		return nil, levelErr
+	}
+	return logging.New(os.Stdout, format, level), nil
+}
+
+// newContractConfig parses the contract.requests and contract.responses modes.
+func newContractConfig(contractConfig config.ContractConfig) (middleware.ContractConfig, error) {
+	requests, requestsErr := middleware.ParseContractMode(contractConfig.Requests)
+	if requestsErr != nil {
+		return middleware.ContractConfig{}, requestsErr
+	}
+	responses, responsesErr := middleware.ParseContractMode(contractConfig.Responses)
+	if responsesErr != nil {
+		return middleware.ContractConfig{}, responsesErr
+	}
+	return middleware.ContractConfig{Requests: requests, Responses: responses}, nil
+}
+
+// newTracerProvider returns a provider that records nothing unless tracing.exporter is set. The
+// otlp exporter also reads the standard OTEL_EXPORTER_OTLP_* variables.
+func newTracerProvider(ctx context.Context, tracingConfig config.TracingConfig) (*sdktrace.TracerProvider, error) {
+	exporter, exporterErr := tracing.ParseExporter(tracingConfig.Exporter)
+	if exporterErr != nil {
+		return nil, exporterErr
+	}
+	headers, headersErr := tracing.ParseHeaders(tracingConfig.Headers)
+	if headersErr != nil {
+		return nil, headersErr
 	}
-	logger.Println("Server exiting")
+	return tracing.NewTracerProvider(ctx, tracing.Config{
+		Exporter:    exporter,
+		File:        tracingConfig.File,
+		ServiceName: tracingConfig.ServiceName,
+		SampleRatio: tracingConfig.SampleRatio,
+		Headers:     headers,
+	})
 }
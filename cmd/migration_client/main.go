@@ -1,39 +1,297 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/brandonrachal/gin-and-tonic/config"
 	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/brandonrachal/gin-and-tonic/migrations"
 	"github.com/brandonrachal/go-toolbox/cliutils"
-	"github.com/brandonrachal/go-toolbox/migrations"
 )
 
+const usage = `Usage: migration_client [-env prod|dev|test] [configuration flags] <command> [arguments]
+
+Commands:
+  up-all                 Apply every pending migration, creating the database file if needed
+  down-all [-yes]        Roll back every applied migration, dropping every table, after asking
+                         for confirmation unless -yes is set
+  status                 List the migrations, applied or pending, with when they were applied
+  dry-run                Print the SQL up-all would run, without running it
+  create [-dir dir] <name>
+                         Write an empty timestamped migration, to the migrations directory of
+                         the source tree by default
+  validate [-dir dir]    Check the migration files parse, each has an Up and a Down section and
+                         no two share a version, then apply and roll them back in a scratch
+                         database. Checks the source tree, else the built in migrations
+  lint [-dir dir]        Report unsafe migrations: not null columns added without a default,
+                         dropped columns and tables, and missing or incomplete Down sections.
+                         Silence a finding with "-- lint:ignore <rule> <reason>" on its line or
+                         just above it. Checks the same migrations as validate
+  config print           Print the configuration
+
+The database commands target the database of -env, prod by default. The migrations are built
+into the program. Run with -h for the configuration flags.
+`
+
 func main() {
 	ctx, cancelFunc := cliutils.InitSignals(context.Background())
 	defer cancelFunc()
 
-	migrateCmdData, migrateCmdDataErr := migrations.GetMigrationCmdData(false)
-	if migrateCmdDataErr != nil {
-		fmt.Printf("error getting migration cmd data - %s\n", migrateCmdDataErr)
+	appConfig, args, configErr := config.Load("migration_client", os.Args[1:])
+	if errors.Is(configErr, flag.ErrHelp) {
+		return
+	} else if configErr != nil {
+		fmt.Printf("error loading the configuration - %s\n", configErr)
 		os.Exit(1)
-	} else if migrateCmdData.ConfirmExit {
-		fmt.Println("Confirmation failed. Skipping operation and exiting.")
+	}
+	if config.IsPrintCommand(args) {
+		if err := appConfig.Print(os.Stdout); err != nil {
+			fmt.Printf("error printing the configuration - %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
 		os.Exit(1)
 	}
 
-	migrateClient, migrateClientErr := internal.ProdDBMigrationClient()
-	if migrateClientErr != nil {
-		fmt.Printf("error getting new migration client - %s\n", migrateClientErr)
+	var cmdErr error
+	switch args[0] {
+	case "up-all", "status", "dry-run":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		cmdErr = runDatabaseCmd(ctx, args[0], appConfig.Database.Path)
+	case "down-all":
+		confirmed, confirmErr := confirmDownAll(args[1:], appConfig.Database.Path)
+		if confirmErr != nil {
+			cmdErr = confirmErr
+		} else if !confirmed {
+			fmt.Println("Confirmation failed. Skipping operation and exiting.")
+			os.Exit(1)
+		} else {
+			cmdErr = runDatabaseCmd(ctx, args[0], appConfig.Database.Path)
+		}
+	case "create":
+		cmdErr = create(args[1:])
+	case "validate":
+		cmdErr = validate(ctx, args[1:])
+	case "lint":
+		cmdErr = lint(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
 		os.Exit(1)
 	}
-
-	runMigrationCmdErr := migrations.RunMigrationCmd(ctx, migrateCmdData, migrateClient)
-	if runMigrationCmdErr != nil {
-		fmt.Printf("error running migration command - %s\n", runMigrationCmdErr)
+	if cmdErr != nil {
+		fmt.Printf("error running %s - %s\n", args[0], cmdErr)
 		os.Exit(1)
 	}
+}
+
+func runDatabaseCmd(ctx context.Context, cmd, path string) error {
+	if cmd == "up-all" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("creating the data directory - %w", err)
+		}
+	} else if _, statErr := os.Stat(path); statErr != nil {
+		// Opening it would create an empty database
+		return fmt.Errorf("checking the database file - %w", statErr)
+	}
+	migrateClient, migrateClientErr := internal.MigrationClient(path)
+	if migrateClientErr != nil {
+		return fmt.Errorf("getting new migration client - %w", migrateClientErr)
+	}
+	defer func() {
+		_ = migrateClient.Close()
+	}()
 
+	switch cmd {
+	case "status":
+		return status(ctx, migrateClient, path)
+	case "dry-run":
+		return dryRun(ctx, migrateClient)
+	case "up-all":
+		applied, upErr := migrateClient.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %s\n", migration)
+		}
+		if upErr != nil {
+			return upErr
+		}
+	case "down-all":
+		if err := migrateClient.Reset(ctx); err != nil {
+			return err
+		}
+	}
 	fmt.Println("Command completed successfully")
+	return nil
+}
+
+// confirmDownAll asks before rolling back the database at path, unless the -yes flag is set.
+func confirmDownAll(args []string, path string) (bool, error) {
+	flags := flag.NewFlagSet("down-all", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "don't ask for confirmation")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return false, parseErr
+	}
+	if flags.NArg() != 0 {
+		return false, fmt.Errorf("expected no arguments")
+	}
+	if *yes {
+		return true, nil
+	}
+	fmt.Printf("Roll back every migration of %s, dropping all its tables and data? [y/N] ", path)
+	answer, answerErr := bufio.NewReader(os.Stdin).ReadString('\n')
+	if answerErr != nil && !errors.Is(answerErr, io.EOF) {
+		return false, answerErr
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func status(ctx context.Context, migrateClient *migrations.Client, path string) error {
+	statuses, statusesErr := migrateClient.Status(ctx)
+	if statusesErr != nil {
+		return statusesErr
+	}
+	version, versionErr := migrateClient.Version(ctx)
+	if versionErr != nil {
+		return versionErr
+	}
+	var pending int
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, migration := range statuses {
+		state, appliedAt := "pending", "-"
+		if migration.Applied() {
+			state, appliedAt = "applied", migration.AppliedAt.UTC().Format(time.RFC3339)
+		} else {
+			pending++
+		}
+		_, _ = fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", migration.Version, migration.Name, state, appliedAt)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%s is at version %d with %d migration(s) pending\n", path, version, pending)
+	return nil
+}
+
+func dryRun(ctx context.Context, migrateClient *migrations.Client) error {
+	pending, pendingErr := migrateClient.Pending(ctx)
+	if pendingErr != nil {
+		return pendingErr
+	}
+	if len(pending) == 0 {
+		fmt.Println("-- No migrations are pending")
+		return nil
+	}
+	for _, migration := range pending {
+		file, fileErr := migrations.ParseFile(migrations.FS, migration.Name)
+		if fileErr != nil {
+			return fileErr
+		}
+		fmt.Printf("-- %s\n", migration.Name)
+		if file.NoTransaction {
+			fmt.Println("-- Runs outside a transaction")
+		}
+		for _, statement := range file.Up {
+			fmt.Printf("%s\n\n", statement.SQL)
+		}
+	}
+	return nil
+}
+
+func create(args []string) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory to write the migration to")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return parseErr
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected <name>")
+	}
+	if *dir == "" {
+		sourceDir, sourceDirErr := internal.MigrationsDir()
+		if sourceDirErr != nil {
+			return fmt.Errorf("%w, set -dir", sourceDirErr)
+		}
+		*dir = sourceDir
+	}
+	path, createErr := migrations.Create(*dir, flags.Arg(0), time.Now())
+	if createErr != nil {
+		return createErr
+	}
+	fmt.Printf("Created %s\n", path)
+	return nil
+}
+
+func validate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory of the migrations to check")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return parseErr
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("expected no arguments")
+	}
+	fsys, source := migrationFiles(*dir)
+	if err := migrations.Validate(fsys); err != nil {
+		return err
+	}
+	if err := migrations.Rehearse(ctx, fsys); err != nil {
+		return err
+	}
+	fmt.Printf("The migrations in %s are valid\n", source)
+	return nil
+}
+
+func lint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory of the migrations to check")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return parseErr
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("expected no arguments")
+	}
+	fsys, source := migrationFiles(*dir)
+	findings, lintErr := migrations.Lint(fsys)
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+	if lintErr != nil {
+		return lintErr
+	} else if len(findings) > 0 {
+		return fmt.Errorf("%d unsafe change(s) in %s", len(findings), source)
+	}
+	fmt.Printf("The migrations in %s are safe\n", source)
+	return nil
+}
+
+// migrationFiles returns the migrations in dir, else in the source tree, else the built in
+// ones, and describes them.
+func migrationFiles(dir string) (fs.FS, string) {
+	if dir == "" {
+		if sourceDir, sourceDirErr := internal.MigrationsDir(); sourceDirErr == nil {
+			dir = sourceDir
+		}
+	}
+	if dir == "" {
+		return migrations.FS, "the built in migrations"
+	}
+	return os.DirFS(dir), dir
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/importer"
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/brandonrachal/go-toolbox/cliutils"
+)
+
+func main() {
+	ctx, cancelFunc := cliutils.InitSignals(context.Background())
+	defer cancelFunc()
+
+	filePath := flag.String("file", "", "CSV, JSON array or NDJSON file to import, - for stdin")
+	formatName := flag.String("format", "", "csv, json or ndjson, guessed from the file extension when empty")
+	columns := flag.String("columns", "", "CSV column mapping, e.g. first_name=First Name,email=E-mail")
+	modeName := flag.String("mode", "insert", "duplicate email handling: insert (reject), upsert or skip")
+	dryRun := flag.Bool("dry-run", false, "validate and import inside rolled back transactions")
+	batchSize := flag.Int("batch-size", importer.DefaultBatchSize, "users per transaction")
+	reportPath := flag.String("report", "-", "where to write the JSON report, - for stdout")
+	env := flag.String("env", "prod", "database environment: prod, dev or test")
+	tenant := flag.String("tenant", "default", "slug of the organization to import into")
+	flag.Parse()
+
+	if *filePath == "" {
+		fmt.Println("error: -file is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	format, formatErr := getFormat(*formatName, *filePath)
+	if formatErr != nil {
+		fmt.Printf("error getting the import format - %s\n", formatErr)
+		os.Exit(1)
+	}
+	columnMapping, columnMappingErr := importer.ParseColumnMapping(*columns)
+	if columnMappingErr != nil {
+		fmt.Printf("error parsing the column mapping - %s\n", columnMappingErr)
+		os.Exit(1)
+	}
+	mode, modeErr := importer.ParseMode(*modeName)
+	if modeErr != nil {
+		fmt.Printf("error parsing the mode - %s\n", modeErr)
+		os.Exit(1)
+	}
+
+	input, inputErr := openInput(*filePath)
+	if inputErr != nil {
+		fmt.Printf("error opening %s - %s\n", *filePath, inputErr)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+
+	dbClient, dbClientErr := internal.DBClient(*env)
+	if dbClientErr != nil {
+		fmt.Printf("error getting the db client - %s\n", dbClientErr)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = dbClient.Close()
+	}()
+
+	organization, organizationErr := dbClient.GetOrganizationBySlug(ctx, *tenant)
+	if organizationErr != nil {
+		fmt.Printf("error looking up tenant %q - %s\n", *tenant, organizationErr)
+		os.Exit(1)
+	}
+	ctx = db.WithTenant(ctx, organization.Id)
+
+	rows := importer.Read(input, format, columnMapping)
+	report, runErr := importer.Run(ctx, dbClient, rows, importer.Options{Mode: mode, DryRun: *dryRun, BatchSize: *batchSize})
+	if report != nil {
+		if writeErr := writeReport(*reportPath, report); writeErr != nil {
+			fmt.Printf("error writing the report - %s\n", writeErr)
+			os.Exit(1)
+		}
+	}
+	if runErr != nil {
+		fmt.Printf("error importing users - %s\n", runErr)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Read %d rows: %d created, %d updated, %d skipped, %d rejected\n",
+		report.Total, report.Created, report.Updated, report.Skipped, len(report.Rejected))
+	if len(report.Rejected) > 0 {
+		os.Exit(2)
+	}
+}
+
+func getFormat(formatName, filePath string) (importer.Format, error) {
+	if formatName != "" {
+		return importer.ParseFormat(formatName)
+	}
+	return importer.FormatFromPath(filePath)
+}
+
+func openInput(filePath string) (io.ReadCloser, error) {
+	if filePath == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filePath)
+}
+
+func writeReport(reportPath string, report *importer.Report) error {
+	var output io.Writer = os.Stdout
+	if reportPath != "-" {
+		file, fileErr := os.Create(reportPath)
+		if fileErr != nil {
+			return fileErr
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		output = file
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
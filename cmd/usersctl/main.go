@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/brandonrachal/gin-and-tonic/usersctl"
+	"github.com/brandonrachal/go-toolbox/cliutils"
+)
+
+func main() {
+	ctx, cancelFunc := cliutils.InitSignals(context.Background())
+	defer cancelFunc()
+
+	// Cobra has printed the error
+	if err := usersctl.NewCommand().ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/https"
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/middleware"
+	"github.com/brandonrachal/gin-and-tonic/migrations"
+	"github.com/brandonrachal/gin-and-tonic/ratelimit"
+	"github.com/brandonrachal/gin-and-tonic/tracing"
+)
+
+// Config is the configuration of api_server and migration_client. Every setting has a key used
+// in config files and by `config print`, and most have an environment variable and a flag.
+type Config struct {
+	// Env is the environment, prod, dev or test. It picks the config file and the default database.
+	Env string
+	// File is the config file that was read, empty when there is none.
+	File string
+
+	Database  DatabaseConfig  `key:"database"`
+	Server    ServerConfig    `key:"server"`
+	TLS       TLSConfig       `key:"tls"`
+	Log       LogConfig       `key:"log"`
+	Auth      AuthConfig      `key:"auth"`
+	RateLimit RateLimitConfig `key:"rate_limit"`
+	Metrics   MetricsConfig   `key:"metrics"`
+	Tracing   TracingConfig   `key:"tracing"`
+	Contract  ContractConfig  `key:"contract"`
+
+	// sources maps each key to where its value came from.
+	sources map[string]string
+}
+
+type DatabaseConfig struct {
+	// DataDir holds the database files. Path defaults to the file of the environment in it.
+	DataDir string `key:"data_dir" env:"DATA_DIR" flag:"data-dir" usage:"directory of the database files"`
+	Path    string `key:"path" env:"DATABASE_PATH" flag:"database-path" usage:"sqlite database file, sqlite_<env>_database.db in data_dir by default"`
+	// Migrate is what api_server does at startup when migrations are pending, see migrations.Guard.
+	Migrate string `key:"migrate" env:"DATABASE_MIGRATE" flag:"database-migrate" usage:"refuse, auto or warn, what to do at startup when migrations are pending"`
+}
+
+type ServerConfig struct {
+	Addr            string        `key:"addr" env:"SERVER_ADDR" flag:"addr" usage:"address the API listens on"`
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long in-flight requests get to finish on shutdown"`
+	// ShutdownDrainDelay is how long /readyz fails before the server stops accepting connections.
+	ShutdownDrainDelay time.Duration `key:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY" flag:"shutdown-drain-delay" usage:"how long /readyz fails before shutting down"`
+	// HTTP3Addr is a UDP address serving HTTP/3 next to HTTP/1.1 and HTTP/2. It needs TLS.
+	HTTP3Addr        string `key:"http3_addr" env:"HTTP3_ADDR" flag:"http3-addr" usage:"UDP address serving HTTP/3, needs TLS"`
+	TenantBaseDomain string `key:"tenant_base_domain" env:"TENANT_BASE_DOMAIN" flag:"tenant-base-domain" usage:"domain whose subdomains select the tenant"`
+	// TrustedProxies may set the client IP with X-Forwarded-For. Empty trusts the connection's
+	// peer address only.
+	TrustedProxies string `key:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trusted-proxies" usage:"comma separated proxy IPs or CIDRs whose X-Forwarded-For is used"`
+}
+
+// TLSConfig serves HTTPS when CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile string `key:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate chain, enables HTTPS"`
+	KeyFile  string `key:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key of the certificate"`
+	// ClientCAFile holds the CAs client certificates must chain to for mutual TLS.
+	ClientCAFile string `key:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca-file" usage:"PEM CAs that sign client certificates"`
+	ClientAuth   string `key:"client_auth" env:"TLS_CLIENT_AUTH" flag:"tls-client-auth" usage:"none, optional or require"`
+	// ReloadInterval is how often the files are checked for changes. SIGHUP reloads them too.
+	ReloadInterval time.Duration `key:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" usage:"how often to check the certificate files for changes, 0 to only reload on SIGHUP"`
+	RedirectAddr   string        `key:"redirect_addr" env:"TLS_REDIRECT_ADDR" flag:"tls-redirect-addr" usage:"address of a plain HTTP listener redirecting to HTTPS"`
+}
+
+// Enabled reports whether the server speaks HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+type LogConfig struct {
+	Format string `key:"format" env:"LOG_FORMAT" flag:"log-format" usage:"json or text"`
+	Level  string `key:"level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+}
+
+type AuthConfig struct {
+	// JWKS is a JWKS file path or URL. JWTs are only accepted when it is set.
+	JWKS     string `key:"jwks" env:"JWT_JWKS" flag:"jwt-jwks" usage:"JWKS file or URL used to verify JWTs"`
+	Issuer   string `key:"issuer" env:"JWT_ISSUER" flag:"jwt-issuer" usage:"required JWT issuer"`
+	Audience string `key:"audience" env:"JWT_AUDIENCE" flag:"jwt-audience" usage:"required JWT audience"`
+}
+
+type RateLimitConfig struct {
+	// Limit is "<rate>:<burst>". Empty disables rate limiting.
+	Limit      string `key:"limit" env:"RATE_LIMIT" flag:"rate-limit" usage:"<rate>:<burst> per client"`
+	Routes     string `key:"routes" env:"RATE_LIMIT_ROUTES" flag:"rate-limit-routes" usage:"per route limits, e.g. \"GET /v1.0/users=1:5\""`
+	DailyQuota int    `key:"daily_quota" env:"RATE_LIMIT_DAILY_QUOTA" flag:"rate-limit-daily-quota" usage:"requests per client per UTC day, 0 for no quota"`
+	Key        string `key:"key" env:"RATE_LIMIT_KEY" flag:"rate-limit-key" usage:"credential or ip"`
+	Store      string `key:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" usage:"memory or sqlite"`
+	// IPLimit is "<rate>:<burst>" for each client IP before authentication. Empty means Limit.
+	IPLimit string `key:"ip_limit" env:"RATE_LIMIT_IP_LIMIT" flag:"rate-limit-ip-limit" usage:"<rate>:<burst> per client IP before authentication, rate_limit.limit by default"`
+}
+
+type MetricsConfig struct {
+	// Addr serves /metrics on an admin port of its own instead of the main one.
+	Addr string `key:"addr" env:"METRICS_ADDR" flag:"metrics-addr" usage:"admin address for /metrics, empty serves them on the main port"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `key:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"none, otlp, stdout or file"`
+	File        string  `key:"file" env:"TRACING_FILE" flag:"tracing-file" usage:"file the file exporter appends spans to"`
+	SampleRatio float64 `key:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" usage:"share of new traces recorded, from 0 to 1"`
+	ServiceName string  `key:"service_name" env:"OTEL_SERVICE_NAME" flag:"tracing-service-name" usage:"service.name of the spans"`
+	// Headers are name=value pairs sent with OTLP requests. They often hold API keys.
+	Headers string `key:"headers" env:"TRACING_OTLP_HEADERS" secret:"true" usage:"comma separated name=value headers for OTLP requests"`
+}
+
+// ContractConfig checks requests and responses against the OpenAPI document, see
+// middleware.Contract.
+type ContractConfig struct {
+	Requests  string `key:"requests" env:"CONTRACT_REQUESTS" flag:"contract-requests" usage:"off, log or enforce, checks requests against the OpenAPI document"`
+	Responses string `key:"responses" env:"CONTRACT_RESPONSES" flag:"contract-responses" usage:"off, log or enforce, checks responses against the OpenAPI document"`
+}
+
+// Default returns the defaults of env.
+func Default(env string) *Config {
+	// The contract is enforced outside of prod, where it would cost every request a check, and
+	// prod schema changes are left to a deliberate migration_client run
+	contract := ContractConfig{Requests: string(middleware.ContractOff), Responses: string(middleware.ContractOff)}
+	migrate := migrations.Refuse
+	switch env {
+	case "dev":
+		contract = ContractConfig{Requests: string(middleware.ContractEnforce), Responses: string(middleware.ContractLog)}
+		migrate = migrations.Auto
+	case "test":
+		contract = ContractConfig{Requests: string(middleware.ContractEnforce), Responses: string(middleware.ContractEnforce)}
+		migrate = migrations.Auto
+	}
+	return &Config{
+		Env: env,
+		Database: DatabaseConfig{
+			DataDir: internal.DataDir(),
+			Path:    internal.DBPath(env),
+			Migrate: string(migrate),
+		},
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ShutdownTimeout: 5 * time.Second,
+		},
+		TLS: TLSConfig{
+			ClientAuth:     https.ClientAuthNone,
+			ReloadInterval: 30 * time.Second,
+		},
+		Log: LogConfig{
+			Format: string(logging.FormatJSON),
+			Level:  "info",
+		},
+		RateLimit: RateLimitConfig{
+			Key:   string(ratelimit.KeyByCredential),
+			Store: "memory",
+		},
+		Tracing: TracingConfig{
+			Exporter:    string(tracing.ExporterNone),
+			SampleRatio: 1,
+			ServiceName: "gin-and-tonic",
+		},
+		Contract: contract,
+	}
+}
+
+// Validate reports every invalid setting.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	if !internal.IsEnv(c.Env) {
+		errs = append(errs, fmt.Errorf("env: must be prod, dev or test, not %q", c.Env))
+	}
+	if c.Database.DataDir == "" {
+		errs = append(errs, errors.New("database.data_dir: is required"))
+	}
+	if c.Database.Path == "" {
+		errs = append(errs, errors.New("database.path: is required"))
+	}
+	_, migrateErr := migrations.ParseMode(c.Database.Migrate)
+	check("database.migrate", migrateErr)
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr: is required"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout: must be positive"))
+	}
+	if c.Server.ShutdownDrainDelay < 0 {
+		errs = append(errs, errors.New("server.shutdown_drain_delay: can't be negative"))
+	}
+	_, proxiesErr := middleware.ParseTrustedProxies(c.Server.TrustedProxies)
+	check("server.trusted_proxies", proxiesErr)
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	clientAuth, clientAuthErr := https.ParseClientAuth(c.TLS.ClientAuth)
+	check("tls.client_auth", clientAuthErr)
+	if clientAuth != tls.NoClientCert && (!c.TLS.Enabled() || c.TLS.ClientCAFile == "") {
+		errs = append(errs, errors.New("tls.client_auth: needs cert_file, key_file and client_ca_file"))
+	}
+	if c.TLS.ClientCAFile != "" && clientAuth == tls.NoClientCert {
+		errs = append(errs, errors.New("tls.client_ca_file: is unused unless client_auth is optional or require"))
+	}
+	if c.TLS.ReloadInterval < 0 {
+		errs = append(errs, errors.New("tls.reload_interval: can't be negative"))
+	}
+	if c.Server.HTTP3Addr != "" && !c.TLS.Enabled() {
+		errs = append(errs, errors.New("server.http3_addr: needs tls.cert_file and tls.key_file"))
+	}
+	if c.TLS.RedirectAddr != "" && !c.TLS.Enabled() {
+		errs = append(errs, errors.New("tls.redirect_addr: needs cert_file and key_file"))
+	}
+	_, formatErr := logging.ParseFormat(c.Log.Format)
+	check("log.format", formatErr)
+	_, levelErr := logging.ParseLevel(c.Log.Level)
+	check("log.level", levelErr)
+	if c.RateLimit.Limit != "" {
+		_, limitErr := ratelimit.ParseLimit(c.RateLimit.Limit)
+		check("rate_limit.limit", limitErr)
+	}
+	if c.RateLimit.IPLimit != "" {
+		_, ipLimitErr := ratelimit.ParseLimit(c.RateLimit.IPLimit)
+		check("rate_limit.ip_limit", ipLimitErr)
+	}
+	_, routesErr := ratelimit.ParseRouteLimits(c.RateLimit.Routes)
+	check("rate_limit.routes", routesErr)
+	if c.RateLimit.DailyQuota < 0 {
+		errs = append(errs, errors.New("rate_limit.daily_quota: can't be negative"))
+	}
+	_, keyErr := ratelimit.ParseKeyBy(c.RateLimit.Key)
+	check("rate_limit.key", keyErr)
+	if c.RateLimit.Store != "memory" && c.RateLimit.Store != "sqlite" {
+		errs = append(errs, fmt.Errorf("rate_limit.store: must be memory or sqlite, not %q", c.RateLimit.Store))
+	}
+	exporter, exporterErr := tracing.ParseExporter(c.Tracing.Exporter)
+	check("tracing.exporter", exporterErr)
+	if exporter == tracing.ExporterFile && c.Tracing.File == "" {
+		errs = append(errs, errors.New("tracing.file: is required by the file exporter"))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio: must be between 0 and 1"))
+	}
+	_, headersErr := tracing.ParseHeaders(c.Tracing.Headers)
+	check("tracing.headers", headersErr)
+	_, requestsErr := middleware.ParseContractMode(c.Contract.Requests)
+	check("contract.requests", requestsErr)
+	_, responsesErr := middleware.ParseContractMode(c.Contract.Responses)
+	check("contract.responses", responsesErr)
+	return errors.Join(errs...)
+}
+
+// Source returns where the value of key came from: "default", "file <path>", "env <NAME>" or
+// "flag -<name>".
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return "default"
+}
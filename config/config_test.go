@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/stretchr/testify/require"
+)
+
+// inTempDir runs the test in an empty directory with none of the config variables set.
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, name := range []string{EnvVar, FileVar} {
+		t.Setenv(name, "")
+		_ = os.Unsetenv(name)
+	}
+	for _, s := range settings(&Config{}) {
+		if s.env != "" {
+			t.Setenv(s.env, "")
+			_ = os.Unsetenv(s.env)
+		}
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestLoadDefaults(t *testing.T) {
+	r := require.New(t)
+	inTempDir(t)
+	config, args, err := Load("api_server", []string{"config", "print"})
+	r.NoError(err)
+	r.Equal([]string{"config", "print"}, args)
+	r.Equal(DefaultEnv, config.Env)
+	r.Equal(internal.DBPath(DefaultEnv), config.Database.Path)
+	r.Equal("refuse", config.Database.Migrate)
+	r.Equal(":8080", config.Server.Addr)
+	r.Equal(5*time.Second, config.Server.ShutdownTimeout)
+	r.Equal("default", config.Source("server.addr"))
+	r.Equal("off", config.Contract.Responses)
+	r.Empty(config.File)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	r := require.New(t)
+	inTempDir(t)
+	writeFile(t, "config/dev.yaml", `
+server:
+  addr: ":9000"
+  shutdown_timeout: 10s
+log:
+  level: debug
+rate_limit:
+  limit: "5:20"
+  daily_quota: 100
+tracing:
+  sample_ratio: 0.5
+`)
+	writeFile(t, ".env", "APP_ENV=dev\nLOG_LEVEL=warn\nRATE_LIMIT_DAILY_QUOTA=200\nSERVER_ADDR=:9001\n")
+	writeFile(t, ".env.dev", "SERVER_ADDR=:9002\n")
+	t.Setenv("RATE_LIMIT_DAILY_QUOTA", "300")
+
+	config, _, err := Load("api_server", []string{"-log-level", "error"})
+	r.NoError(err)
+	r.Equal("dev", config.Env)
+	r.Equal(filepath.Join("config", "dev.yaml"), config.File)
+	r.Equal(internal.DBPath("dev"), config.Database.Path)
+	r.Equal("auto", config.Database.Migrate)
+	// .env.<env> beats .env, which beats the file
+	r.Equal(":9002", config.Server.Addr)
+	r.Equal("env SERVER_ADDR", config.Source("server.addr"))
+	// The file beats the defaults
+	r.Equal(10*time.Second, config.Server.ShutdownTimeout)
+	r.Equal("file config/dev.yaml", config.Source("server.shutdown_timeout"))
+	r.Equal("5:20", config.RateLimit.Limit)
+	r.Equal(0.5, config.Tracing.SampleRatio)
+	// The environment beats .env
+	r.Equal(300, config.RateLimit.DailyQuota)
+	// Flags beat everything
+	r.Equal("error", config.Log.Level)
+	r.Equal("flag -log-level", config.Source("log.level"))
+}
+
+func TestLoadTOML(t *testing.T) {
+	r := require.New(t)
+	dir := inTempDir(t)
+	path := filepath.Join(dir, "server.toml")
+	writeFile(t, path, `
+[database]
+path = "/var/lib/gin-and-tonic/users.db"
+
+[metrics]
+addr = "127.0.0.1:9090"
+`)
+	config, _, err := Load("api_server", []string{"-env", "test", "-config", path})
+	r.NoError(err)
+	r.Equal("test", config.Env)
+	r.Equal("/var/lib/gin-and-tonic/users.db", config.Database.Path)
+	r.Equal("127.0.0.1:9090", config.Metrics.Addr)
+	r.Equal("enforce", config.Contract.Responses)
+}
+
+func TestLoadDataDir(t *testing.T) {
+	r := require.New(t)
+	dir := inTempDir(t)
+	t.Setenv("DATA_DIR", filepath.Join(dir, "var"))
+	config, _, err := Load("migration_client", []string{"-env", "dev"})
+	r.NoError(err)
+	r.Equal(filepath.Join(dir, "var"), config.Database.DataDir)
+	r.Equal(filepath.Join(dir, "var", "sqlite_dev_database.db"), config.Database.Path)
+
+	// The flag beats the environment, and an explicit path beats data_dir
+	config, _, err = Load("migration_client", []string{"-data-dir", "/srv/data"})
+	r.NoError(err)
+	r.Equal(filepath.Join("/srv/data", "sqlite_prod_database.db"), config.Database.Path)
+	config, _, err = Load("migration_client", []string{"-data-dir", "/srv/data", "-database-path", "users.db"})
+	r.NoError(err)
+	r.Equal("users.db", config.Database.Path)
+}
+
+func TestLoadErrors(t *testing.T) {
+	r := require.New(t)
+	inTempDir(t)
+	_, _, err := Load("api_server", []string{"-env", "staging"})
+	r.ErrorContains(err, "env: must be prod, dev or test")
+
+	_, _, err = Load("api_server", []string{"-config", "missing.yaml"})
+	r.ErrorContains(err, "config file")
+
+	writeFile(t, "config/prod.yaml", "server:\n  adr: \":80\"\n  shutdown_timeout: 5\n")
+	_, _, err = Load("api_server", nil)
+	r.ErrorContains(err, "server.adr from file config/prod.yaml: unknown setting")
+	r.ErrorContains(err, "server.shutdown_timeout from file config/prod.yaml")
+
+	r.NoError(os.Remove("config/prod.yaml"))
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("TRACING_EXPORTER", "file")
+	_, _, err = Load("api_server", []string{"-rate-limit", "fast", "-rate-limit-ip-limit", "1:0", "-trusted-proxies", "10.0.0.0/33", "-database-migrate", "always"})
+	r.ErrorContains(err, "log.format")
+	r.ErrorContains(err, "database.migrate")
+	r.ErrorContains(err, "tracing.file: is required")
+	r.ErrorContains(err, "rate_limit.limit")
+	r.ErrorContains(err, "rate_limit.ip_limit")
+	r.ErrorContains(err, "server.trusted_proxies")
+
+	t.Setenv("LOG_FORMAT", "text")
+	t.Setenv("TRACING_EXPORTER", "none")
+	_, _, err = Load("api_server", []string{"-tls-cert-file", "server.pem", "-tls-client-auth", "require", "-tls-redirect-addr", ":80", "-http3-addr", ":443"})
+	r.ErrorContains(err, "tls: cert_file and key_file must be set together")
+	r.ErrorContains(err, "tls.client_auth: needs cert_file, key_file and client_ca_file")
+	r.NotContains(err.Error(), "tls.redirect_addr")
+	r.NotContains(err.Error(), "server.http3_addr")
+
+	_, _, err = Load("api_server", []string{"-http3-addr", ":443"})
+	r.ErrorContains(err, "server.http3_addr: needs tls.cert_file and tls.key_file")
+}
+
+func TestPrint(t *testing.T) {
+	r := require.New(t)
+	inTempDir(t)
+	t.Setenv("TRACING_OTLP_HEADERS", "x-api-key=s3cr3t")
+	config, _, err := Load("api_server", []string{"-addr", ":9000"})
+	r.NoError(err)
+	var buf bytes.Buffer
+	r.NoError(config.Print(&buf))
+	out := buf.String()
+	r.Contains(out, "# env: prod")
+	r.Regexp(`server\.addr = ":9000" +# flag -addr\n`, out)
+	r.Regexp(`server\.shutdown_timeout = "5s" +# default\n`, out)
+	r.Regexp(`tracing\.headers = "\*\*\*\*\*\*\*\*" +# env TRACING_OTLP_HEADERS\n`, out)
+	r.NotContains(out, "s3cr3t")
+}
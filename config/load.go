@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/goccy/go-yaml"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	// EnvVar selects the environment when -env isn't given.
+	EnvVar = "APP_ENV"
+	// FileVar names the config file when -config isn't given.
+	FileVar = "CONFIG_FILE"
+	// DefaultEnv is used when neither -env nor APP_ENV is set.
+	DefaultEnv = "prod"
+	// Dir holds the config files found without -config, config/<env>.yaml, .yml or .toml.
+	Dir = "config"
+
+	masked = "********"
+)
+
+// setting is one configurable field of a Config.
+type setting struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the fields of c that have a key, in declaration order.
+func settings(c *Config) []setting {
+	var list []setting
+	sections := reflect.ValueOf(c).Elem()
+	for i := range sections.NumField() {
+		sectionKey := sections.Type().Field(i).Tag.Get("key")
+		if sectionKey == "" {
+			continue
+		}
+		section := sections.Field(i)
+		for j := range section.NumField() {
+			field := section.Type().Field(j)
+			list = append(list, setting{
+				key:    sectionKey + "." + field.Tag.Get("key"),
+				env:    field.Tag.Get("env"),
+				flag:   field.Tag.Get("flag"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+	return list
+}
+
+func (s setting) set(value, source string) error {
+	var err error
+	switch s.value.Interface().(type) {
+	case time.Duration:
+		var duration time.Duration
+		duration, err = time.ParseDuration(value)
+		s.value.SetInt(int64(duration))
+	case int:
+		var number int
+		number, err = strconv.Atoi(value)
+		s.value.SetInt(int64(number))
+	case float64:
+		var number float64
+		number, err = strconv.ParseFloat(value, 64)
+		s.value.SetFloat(number)
+	case string:
+		s.value.SetString(value)
+	default:
+		err = fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%s from %s: %w", s.key, source, err)
+	}
+	return nil
+}
+
+// Load builds the configuration of program from, in increasing precedence, the defaults of the
+// environment, the config file, environment variables and the flags at the start of args. It
+// returns the arguments left after the flags.
+//
+// The environment comes from -env, then APP_ENV. Variables in .env.<env> and .env are added to
+// the environment without overriding it. The config file is -config, then CONFIG_FILE, then
+// config/<env>.yaml, .yml or .toml when one exists.
+func Load(program string, args []string) (*Config, []string, error) {
+	flags := flag.NewFlagSet(program, flag.ContinueOnError)
+	envFlag := flags.String("env", "", "environment: prod, dev or test (default $APP_ENV or prod)")
+	fileFlag := flags.String("config", "", "YAML or TOML config file (default $CONFIG_FILE or config/<env>.yaml)")
+	flagValues := make(map[string]string)
+	for _, s := range settings(&Config{}) {
+		if s.flag == "" {
+			continue
+		}
+		flags.Func(s.flag, fmt.Sprintf("%s ($%s)", s.usage, s.env), func(value string) error {
+			flagValues[s.key] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	env, envSource, envErr := selectEnv(*envFlag)
+	if envErr != nil {
+		return nil, nil, envErr
+	}
+	if err := loadDotenv(env); err != nil {
+		return nil, nil, err
+	}
+	path, pathErr := findFile(*fileFlag, env)
+	if pathErr != nil {
+		return nil, nil, pathErr
+	}
+	var fileValues map[string]string
+	if path != "" {
+		var fileErr error
+		if fileValues, fileErr = readFile(path); fileErr != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", path, fileErr)
+		}
+	}
+
+	config := Default(env)
+	config.File = path
+	config.sources = map[string]string{"env": envSource}
+	var errs []error
+	apply := func(s setting, value, source string) {
+		if err := s.set(value, source); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		config.sources[s.key] = source
+	}
+	for _, s := range settings(config) {
+		if value, ok := fileValues[s.key]; ok {
+			apply(s, value, "file "+path)
+			delete(fileValues, s.key)
+		}
+		if value, ok := os.LookupEnv(s.env); ok && s.env != "" {
+			apply(s, value, "env "+s.env)
+		}
+		if value, ok := flagValues[s.key]; ok {
+			apply(s, value, "flag -"+s.flag)
+		}
+	}
+	for key := range fileValues {
+		errs = append(errs, fmt.Errorf("%s from file %s: unknown setting", key, path))
+	}
+	// The database file follows data_dir unless it was set itself
+	if _, pathSet := config.sources["database.path"]; !pathSet {
+		config.Database.Path = internal.DBFile(config.Database.DataDir, env)
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return config, flags.Args(), nil
+}
+
+// selectEnv returns the environment and where it came from. APP_ENV may be set by .env.
+func selectEnv(envFlag string) (string, string, error) {
+	if envFlag != "" {
+		return envFlag, "flag -env", nil
+	}
+	if env, ok := os.LookupEnv(EnvVar); ok {
+		return env, "env " + EnvVar, nil
+	}
+	dotenv, dotenvErr := godotenv.Read(".env")
+	if dotenvErr != nil && !errors.Is(dotenvErr, os.ErrNotExist) {
+		return "", "", fmt.Errorf("reading .env: %w", dotenvErr)
+	}
+	if env, ok := dotenv[EnvVar]; ok {
+		return env, "env " + EnvVar, nil
+	}
+	return DefaultEnv, "default", nil
+}
+
+// loadDotenv adds .env.<env> and .env to the environment. Variables that are already set win,
+// then those of .env.<env>.
+func loadDotenv(env string) error {
+	var files []string
+	for _, file := range []string{".env." + env, ".env"} {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return godotenv.Load(files...)
+}
+
+func findFile(fileFlag, env string) (string, error) {
+	path := fileFlag
+	if path == "" {
+		path = os.Getenv(FileVar)
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("config file: %w", err)
+		}
+		return path, nil
+	}
+	for _, ext := range []string{".yaml", ".yml", ".toml"} {
+		candidate := filepath.Join(Dir, env+ext)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+// readFile reads a YAML or TOML file into values by dotted key, e.g. "server.addr".
+func readFile(path string) (map[string]string, error) {
+	data, dataErr := os.ReadFile(path)
+	if dataErr != nil {
+		return nil, dataErr
+	}
+	var tree map[string]any
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unknown config file type %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	return values, flatten("", tree, values)
+}
+
+func flatten(prefix string, tree map[string]any, values map[string]string) error {
+	for name, node := range tree {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		switch node := node.(type) {
+		case map[string]any:
+			if err := flatten(key, node, values); err != nil {
+				return err
+			}
+		case []any:
+			return fmt.Errorf("%s: lists aren't supported", key)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(node)
+		}
+	}
+	return nil
+}
+
+// IsPrintCommand reports whether args, what Load left after the flags, is `config print`.
+func IsPrintCommand(args []string) bool {
+	return len(args) == 2 && args[0] == "config" && args[1] == "print"
+}
+
+// Print writes the effective configuration as TOML with the source of each value. Secrets are
+// masked.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "# env: %s\t# %s\n", c.Env, c.Source("env"))
+	if c.File != "" {
+		_, _ = fmt.Fprintf(tw, "# file: %s\n", c.File)
+	}
+	for _, s := range settings(c) {
+		var value string
+		switch v := s.value.Interface().(type) {
+		case string:
+			if s.secret && v != "" {
+				v = masked
+			}
+			value = strconv.Quote(v)
+		case time.Duration:
+			value = strconv.Quote(v.String())
+		default:
+			value = fmt.Sprint(v)
+		}
+		_, _ = fmt.Fprintf(tw, "%s = %s\t# %s\n", s.key, value, c.Source(s.key))
+	}
+	return tw.Flush()
+}
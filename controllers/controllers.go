@@ -1,32 +1,204 @@
 package controllers
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 
+	"github.com/brandonrachal/gin-and-tonic/auth"
 	"github.com/brandonrachal/gin-and-tonic/controllers/v1"
 	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/health"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/metrics"
+	"github.com/brandonrachal/gin-and-tonic/middleware"
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/brandonrachal/gin-and-tonic/openapi"
+	"github.com/brandonrachal/gin-and-tonic/ratelimit"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func GetRouter(logger *log.Logger, dbClient *db.Client) *gin.Engine {
-	router := gin.Default()
+// RouterOptions holds the optional parts of the router.
+type RouterOptions struct {
+	// Authenticators are tried after the stored API keys, e.g. an auth.JWTAuthenticator.
+	Authenticators []auth.Authenticator
+	// ClientCertificates authenticates requests by their verified TLS client certificate when
+	// they have no bearer token.
+	ClientCertificates bool
+	// TenantBaseDomain lets requests to <slug>.<TenantBaseDomain> select their tenant.
+	TenantBaseDomain string
+	// RateLimiter limits each client's /v1.0 requests. Nil disables rate limiting.
+	RateLimiter *ratelimit.Limiter
+	// Metrics records every request when set.
+	Metrics *metrics.Metrics
+	// ServeMetrics exposes Metrics at /metrics. Leave it off when they're served on an admin port.
+	ServeMetrics bool
+	// TracerProvider records a span for every request when set.
+	TracerProvider trace.TracerProvider
+	// Health serves /healthz and /readyz when set.
+	Health *health.Checker
+	// Contract checks requests and responses against the OpenAPI document.
+	Contract middleware.ContractConfig
+	// TrustedProxies may set the client IP with X-Forwarded-For or X-Real-IP, see
+	// middleware.ParseTrustedProxies. Nil trusts none, so clients can't pick their rate limit
+	// bucket.
+	TrustedProxies []string
+}
+
+// GetRouter builds the router and its OpenAPI document, served at OpenAPIPath and rendered at
+// DocsPath. Routes are registered through documentedRoutes so each one is described.
+func GetRouter(logger *slog.Logger, dbClient *db.Client, options RouterOptions) *gin.Engine {
+	// gin.Default() adds gin's own text logger. RequestLogger logs each request instead.
+	router := gin.New()
+	if err := router.SetTrustedProxies(options.TrustedProxies); err != nil {
+		logger.Error("Invalid trusted proxies, trusting none", slog.Any("error", err))
+		_ = router.SetTrustedProxies(nil)
+	}
+	spec := openapi.NewBuilder(apiInfo)
+	rootRoutes := documentedRoutes{group: &router.RouterGroup, spec: spec}
+	router.Use(middleware.RequestId())
+	if options.TracerProvider != nil {
+		router.Use(middleware.Tracing(options.TracerProvider))
+	}
+	router.Use(middleware.RequestLogger(logger), middleware.Recovery(logger))
+	if options.Metrics != nil {
+		router.Use(middleware.Metrics(options.Metrics))
+		if options.ServeMetrics {
+			rootRoutes.handle(openapi.Route{
+				Method: http.MethodGet, Path: "/metrics", OperationId: "getMetrics", Summary: "Prometheus metrics", Tag: "operations",
+				Responses: map[int]openapi.Body{http.StatusOK: {ContentType: "text/plain", Value: ""}},
+			}, gin.WrapH(options.Metrics.Handler()))
+		}
+	}
+	if options.Contract.Enabled() {
+		router.Use(middleware.Contract(logger, spec.Document(), options.Contract))
+	}
+	// Handlers and middleware pass ctx.Request.Context() to the database and never the gin
+	// context, which gin reuses while database/sql goroutines may still be watching it.
 	// All root routes
-	router.GET("/ping", Ping)
+	rootRoutes.handle(openapi.Route{
+		Method: http.MethodGet, Path: "/ping", OperationId: "ping", Summary: "Check the server answers", Tag: "operations",
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.StatusMessage{}}},
+	}, Ping)
+	if options.Health != nil {
+		healthResponses := map[int]openapi.Body{
+			http.StatusOK:                 {Description: "Every check passed", Value: health.Report{}},
+			http.StatusServiceUnavailable: {Description: "A check failed", Value: health.Report{}},
+		}
+		rootRoutes.handle(openapi.Route{
+			Method: http.MethodGet, Path: "/healthz", OperationId: "getLiveness", Summary: "Check the database and disk", Tag: "operations",
+			Responses: healthResponses,
+		}, HealthReport(logger, options.Health.Live))
+		rootRoutes.handle(openapi.Route{
+			Method: http.MethodGet, Path: "/readyz", OperationId: "getReadiness", Summary: "Check the server is ready for traffic", Tag: "operations",
+			Responses: healthResponses,
+		}, HealthReport(logger, options.Health.Ready))
+	}
+	rootRoutes.handle(openapi.Route{
+		Method: http.MethodGet, Path: OpenAPIPath, OperationId: "getOpenAPI", Summary: "This OpenAPI document", Tag: "documentation",
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: map[string]any{}}},
+	}, gin.WrapH(openapi.Handler(spec.Document())))
+	rootRoutes.handle(openapi.Route{
+		Method: http.MethodGet, Path: DocsPath, OperationId: "getDocs", Summary: "Browse this document", Tag: "documentation",
+		Responses: map[int]openapi.Body{http.StatusOK: {ContentType: "text/html", Value: ""}},
+	}, gin.WrapH(openapi.DocsHandler(apiInfo.Title, OpenAPIPath)))
 	// All v1.0 routes
 	v1Router := router.Group("/v1.0")
+	v1Routes := documentedRoutes{group: v1Router, spec: spec, headers: []openapi.Parameter{tenantHeader}, responses: v1Responses}
+	// Client IPs are limited before authentication, which costs a database lookup, and
+	// principals after it
+	keyByIP := options.RateLimiter != nil && options.RateLimiter.KeyBy() == ratelimit.KeyByIP
+	if options.RateLimiter != nil {
+		if keyByIP {
+			v1Router.Use(middleware.RateLimit(logger, options.RateLimiter))
+		} else {
+			v1Router.Use(middleware.IPRateLimit(logger, options.RateLimiter))
+		}
+		v1Routes.responses = with(v1Responses, map[int]openapi.Body{http.StatusTooManyRequests: rateLimitedResponse})
+	}
+	if options.ClientCertificates {
+		v1Router.Use(middleware.ClientCertificate(logger, auth.NewClientCertAuthenticator(dbClient)))
+		spec.AcceptClientCertificates()
+	}
+	v1Router.Use(middleware.Authenticate(logger, append([]auth.Authenticator{auth.NewAPIKeyAuthenticator(dbClient)}, options.Authenticators...)...))
+	if options.RateLimiter != nil && !keyByIP {
+		v1Router.Use(middleware.RateLimit(logger, options.RateLimiter))
+	}
+	v1Router.Use(
+		middleware.Tenant(logger, dbClient, options.TenantBaseDomain),
+		middleware.Idempotency(logger, dbClient, middleware.DefaultIdempotencyKeyTTL),
+	)
+	usersRead := []string{auth.ScopeUsersRead}
+	usersWrite := []string{auth.ScopeUsersWrite}
+	statsRead := []string{auth.ScopeStatsRead}
 	// User Controller
-	userController := v1.NewUsersController(logger, dbClient)
-	v1Router.POST("/user", userController.CreateUserAction)
-	v1Router.GET("/user", userController.GetUserAction)
-	v1Router.PUT("/user", userController.UpdateUserAction)
-	v1Router.DELETE("/user", userController.DeleteUserAction)
-	v1Router.GET("/users", userController.GetUsersAction)
-	v1Router.GET("/users_with_age", userController.GetUsersWithAgeAction)
-	v1Router.GET("/age_stats", userController.GetAgeStatsAction)
+	userController := v1.NewUsersController(logger, dbClient, auth.NewPolicy(dbClient))
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodPost, Path: "/user", OperationId: "createUser", Summary: "Create a user", Tag: "users", Scopes: usersWrite,
+		Headers: []openapi.Parameter{idempotencyKeyHeader}, Request: models.CreateUser{},
+		Responses: with(idempotencyResponses, map[int]openapi.Body{http.StatusOK: {Value: api.IdUserMessage{}}}),
+	}, userController.CreateUserAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodGet, Path: "/user", OperationId: "getUser", Summary: "Get a user", Tag: "users", Scopes: usersRead,
+		Request: models.IdUser{},
+		Responses: map[int]openapi.Body{
+			http.StatusOK:       {Value: api.UserMessage{}},
+			http.StatusNotFound: jsonError("The user doesn't exist"),
+		},
+	}, userController.GetUserAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodPut, Path: "/user", OperationId: "updateUser", Summary: "Update a user", Tag: "users", Scopes: usersWrite,
+		Request:   models.User{},
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.Message{}}},
+	}, userController.UpdateUserAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodDelete, Path: "/user", OperationId: "deleteUser", Summary: "Delete a user", Tag: "users", Scopes: usersWrite,
+		Request:   models.IdUser{},
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.Message{}}},
+	}, userController.DeleteUserAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodGet, Path: "/users", OperationId: "listUsers", Summary: "List every user, streamed", Tag: "users", Scopes: usersRead,
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.UsersMessage{}}},
+	}, userController.GetUsersAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodPut, Path: "/users/by_email/:email", OperationId: "upsertUserByEmail", Summary: "Create or update the user with an email", Tag: "users", Scopes: usersWrite,
+		Request: models.UpsertUser{},
+		Responses: map[int]openapi.Body{
+			http.StatusOK:      {Description: "The user was updated", Value: api.IdUserMessage{}},
+			http.StatusCreated: {Description: "The user was created", Value: api.IdUserMessage{}},
+		},
+	}, userController.UpsertUserByEmailAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodGet, Path: "/users_with_age", OperationId: "listUsersWithAge", Summary: "List every user with their age", Tag: "users", Scopes: usersRead,
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.UsersWithAgeMessage{}}},
+	}, userController.GetUsersWithAgeAction)
+	v1Routes.handle(openapi.Route{
+		Method: http.MethodGet, Path: "/age_stats", OperationId: "getAgeStats", Summary: "Count users by age group", Tag: "stats", Scopes: statsRead,
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.AgeStatsMessage{}}},
+	}, userController.GetAgeStatsAction)
 	return router
 }
 
 func Ping(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+	ctx.JSON(http.StatusOK, api.StatusMessage{Status: "ok"})
+}
+
+// HealthReport responds with the report of check, 200 when it passes and 503 otherwise. The
+// errors behind failed checks are only logged.
+func HealthReport(logger *slog.Logger, check func(ctx context.Context) *health.Report) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		report := check(ctx.Request.Context())
+		status := http.StatusOK
+		if !report.Ok() {
+			status = http.StatusServiceUnavailable
+			for name, result := range report.Checks {
+				if result.Err != nil {
+					logging.FromContext(ctx.Request.Context(), logger).Warn("Health check failed", slog.String("check", name), slog.Any("error", result.Err))
+				}
+			}
+		}
+		ctx.JSON(status, report)
+	}
 }
@@ -1,30 +1,59 @@
 package controllers_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/brandonrachal/gin-and-tonic/auth"
 	"github.com/brandonrachal/gin-and-tonic/controllers"
 	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/health"
 	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/brandonrachal/gin-and-tonic/internal/testenv"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/metrics"
+	"github.com/brandonrachal/gin-and-tonic/middleware"
+	"github.com/brandonrachal/gin-and-tonic/migrations"
 	"github.com/brandonrachal/gin-and-tonic/models"
 	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/brandonrachal/gin-and-tonic/openapi"
+	"github.com/brandonrachal/gin-and-tonic/ratelimit"
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+	"github.com/brandonrachal/gin-and-tonic/tracing"
 	"github.com/brandonrachal/go-toolbox/jsonutils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var enforceContract = middleware.ContractConfig{Requests: middleware.ContractEnforce, Responses: middleware.ContractEnforce}
+
 var (
 	router   *gin.Engine
 	dbClient *db.Client
+	apiKey   string
 )
 
 func TestMain(m *testing.M) {
@@ -36,39 +65,19 @@ func TestMain(m *testing.M) {
 }
 
 func testMain(m *testing.M) int {
-	ctx := context.Background()
-	migrationClient, migrationClientErr := internal.TestDBMigrationClient()
-	if migrationClientErr != nil {
-		log.Printf("Error: Couldn't get the migration client - %s\n", migrationClientErr)
+	// Every response is checked against the OpenAPI document, so handlers can't drift from it
+	env, envErr := testenv.New(context.Background(), "controllers_test", testLogger(), controllers.RouterOptions{Contract: enforceContract})
+	if envErr != nil {
+		log.Printf("Error: Couldn't set up the test env - %s\n", envErr)
 		return 1
 	}
 	defer func() {
-		if downAllErr := migrationClient.Reset(ctx); downAllErr != nil {
-			log.Printf("Error: Couldn't down all migrations - %s\n", downAllErr)
-		}
-		if closeErr := migrationClient.Close(); closeErr != nil {
-			log.Printf("Error: Couldn't close migration client - %s\n", closeErr)
+		if closeErr := env.Close(); closeErr != nil {
+			log.Printf("Error: Couldn't close the test env - %s\n", closeErr)
 		}
 	}()
-	if upAllErr := migrationClient.UpAll(ctx); upAllErr != nil {
-		log.Printf("Error: Couldn't up all migrations - %s\n", upAllErr)
-		return 1
-	}
-	var dbClientErr error
-	dbClient, dbClientErr = internal.TestDBClient()
-	if dbClientErr != nil {
-		log.Printf("Error: Couldn't retrieve the db client - %s\n", dbClientErr)
-		return 1
-	}
-	defer func() {
-		if dbCloseErr := dbClient.Close(); dbCloseErr != nil {
-			log.Printf("Error: Couldn't close the db client - %s\n", dbCloseErr)
-		}
-	}()
-	logger := log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds)
-	router = controllers.GetRouter(logger, dbClient)
-	exitCode := m.Run()
-	return exitCode
+	dbClient, apiKey, router = env.DB, env.APIKey, env.Router
+	return m.Run()
 }
 
 func TestPing(t *testing.T) {
@@ -86,9 +95,651 @@ func TestPing(t *testing.T) {
 	r.JSONEq(`{"status": "ok"}`, string(bodyBytes))
 }
 
+func TestHealth(t *testing.T) {
+	r := require.New(t)
+	checker := health.NewChecker(dbClient, health.Config{
+		MigrationTable: internal.MigrationTable,
+		Migrations:     migrations.FS,
+		DataDir:        internal.DataDir(),
+	})
+	healthRouter := controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{Health: checker})
+	call := func(url string) (int, health.Report) {
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", url, nil)
+		r.NoError(reqErr)
+		healthRouter.ServeHTTP(w, req)
+		var report health.Report
+		r.NoError(json.Unmarshal(w.Body.Bytes(), &report))
+		return w.Code, report
+	}
+
+	// No credentials are needed
+	code, report := call("/healthz")
+	r.Equal(http.StatusOK, code)
+	r.Equal(health.StatusOk, report.Status)
+	r.Equal(health.StatusOk, report.Checks["database"].Status)
+	r.NotContains(report.Checks, "disk")
+	r.NotContains(report.Checks, "migrations")
+
+	code, report = call("/readyz")
+	r.Equal(http.StatusOK, code)
+	r.Equal(health.StatusOk, report.Checks["disk"].Status)
+	migrations := report.Checks["migrations"]
+	r.Equal(health.StatusOk, migrations.Status)
+	r.NotNil(migrations.CurrentVersion)
+	r.Equal(migrations.LatestVersion, migrations.CurrentVersion)
+
+	// Readiness fails once shutdown starts while liveness still passes
+	checker.ShutDown()
+	code, report = call("/readyz")
+	r.Equal(http.StatusServiceUnavailable, code)
+	r.Equal(health.StatusFail, report.Status)
+	r.Equal(health.StatusFail, report.Checks["shutdown"].Status)
+	code, _ = call("/healthz")
+	r.Equal(http.StatusOK, code)
+}
+
+// updateOpenAPI rewrites the checked-in document: go test ./controllers -run TestOpenAPI -update
+var updateOpenAPI = flag.Bool("update", false, "rewrite openapi.json from the router")
+
+func TestOpenAPI(t *testing.T) {
+	r := require.New(t)
+	// Every optional route is on so the document describes them all
+	fullRouter := controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{
+		ClientCertificates: true,
+		RateLimiter:        ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Config{Default: ratelimit.Limit{Rate: 1, Burst: 1}}),
+		Metrics:            metrics.New(dbClient),
+		ServeMetrics:       true,
+		Health:             health.NewChecker(dbClient, health.Config{}),
+	})
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", url, nil)
+		r.NoError(reqErr)
+		fullRouter.ServeHTTP(w, req)
+		r.Equal(http.StatusOK, w.Code)
+		return w
+	}
+	body := get(controllers.OpenAPIPath).Body.Bytes()
+	var document openapi.Document
+	r.NoError(json.Unmarshal(body, &document))
+	r.Equal(openapi.Version, document.OpenAPI)
+
+	// The routes and the operations match one to one
+	operations := 0
+	for _, item := range document.Paths {
+		operations += len(*item)
+	}
+	routes := fullRouter.Routes()
+	for _, route := range routes {
+		r.NotNil(document.Operation(route.Method, route.Path), "%s %s isn't in the OpenAPI document", route.Method, route.Path)
+	}
+	r.Len(routes, operations)
+
+	createUser := document.Operation("POST", "/v1.0/user")
+	r.Equal([]map[string][]string{{openapi.BearerAuth: {auth.ScopeUsersWrite}}, {openapi.ClientCertificate: {auth.ScopeUsersWrite}}}, createUser.Security)
+	request := createUser.RequestBody.Content[openapi.ContentTypeJSON].Schema
+	r.Equal([]string{"first_name", "last_name", "email", "birthday"}, request.Required)
+	r.Equal("date", request.Properties["birthday"].Format)
+	r.Contains(createUser.Responses, "429")
+	upsert := document.Operation("PUT", "/v1.0/users/by_email/:email")
+	r.Equal("email", upsert.Parameters[0].Name)
+	r.Equal("path", upsert.Parameters[0].In)
+
+	// The checked-in document is up to date
+	path := filepath.Join("..", "openapi.json")
+	if *updateOpenAPI {
+		r.NoError(os.WriteFile(path, append(body, '\n'), 0o644))
+	}
+	checkedIn, checkedInErr := os.ReadFile(path)
+	r.NoError(checkedInErr)
+	r.JSONEq(string(body), string(checkedIn), "openapi.json is out of date, run go test ./controllers -run TestOpenAPI -update")
+
+	r.Contains(get(controllers.DocsPath).Body.String(), `"/openapi.json"`)
+}
+
+func TestContract(t *testing.T) {
+	r := require.New(t)
+	// Invalid requests get a problem listing every error
+	resp := callRequest(r, "POST", "/v1.0/user", gin.H{"first_name": "Contract", "last_name": 7, "birthday": "1990-13-01"})
+	r.Equal(http.StatusBadRequest, resp.StatusCode)
+	r.Equal(middleware.ProblemContentType, resp.Header.Get("Content-Type"))
+	var problem api.Problem
+	r.NoError(json.NewDecoder(resp.Body).Decode(&problem))
+	r.NoError(resp.Body.Close())
+	r.Equal([]api.ProblemError{
+		{Location: "body.email", Message: "is required"},
+		{Location: "body.birthday", Message: `"1990-13-01" isn't a date`},
+		{Location: "body.last_name", Message: "is integer, not string"},
+	}, problem.Errors)
+	r.Equal(resp.Header.Get(requestid.Header), problem.RequestId)
+	// Bodies over the limit aren't read in full
+	resp = callRequest(r, "POST", "/v1.0/user", gin.H{"first_name": strings.Repeat("a", middleware.ContractMaxBodyBytes)})
+	r.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+	r.NoError(resp.Body.Close())
+
+	// Responses that drift from the document are replaced, or only logged
+	spec := openapi.NewBuilder(openapi.Info{Title: "drift", Version: "1"})
+	spec.Add(openapi.Route{
+		Method: "GET", Path: "/user", OperationId: "getUser",
+		Responses: map[int]openapi.Body{http.StatusOK: {Value: api.UserMessage{}}},
+	})
+	lastName := "User"
+	drifted := func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"user": gin.H{"id": "1", "first_name": "Drift", "last_name": lastName}})
+	}
+	call := func(contract middleware.ContractConfig) *httptest.ResponseRecorder {
+		driftRouter := gin.New()
+		driftRouter.Use(middleware.Contract(testLogger(), spec.Document(), contract))
+		driftRouter.GET("/user", drifted)
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", "/user", nil)
+		r.NoError(reqErr)
+		driftRouter.ServeHTTP(w, req)
+		return w
+	}
+	w := call(enforceContract)
+	r.Equal(http.StatusInternalServerError, w.Code)
+	r.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	r.Equal([]api.ProblemError{{Location: "response.user.id", Message: "is string, not integer"}}, problem.Errors)
+	w = call(middleware.ContractConfig{Responses: middleware.ContractLog})
+	r.Equal(http.StatusOK, w.Code)
+	r.Contains(w.Body.String(), "Drift")
+	// Logging only keeps a copy of responses up to the limit, and passes larger ones on whole
+	lastName = strings.Repeat("a", middleware.ContractMaxBodyBytes)
+	w = call(middleware.ContractConfig{Responses: middleware.ContractLog})
+	r.Equal(http.StatusOK, w.Code)
+	r.Greater(w.Body.Len(), middleware.ContractMaxBodyBytes)
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	statsKey, statsKeyErr := issueAPIKey(ctx, []string{auth.ScopeStatsRead}, auth.RoleAdmin, nil)
+	r.NoError(statsKeyErr)
+	prefix, ok := auth.ParseAPIKey(statsKey)
+	r.True(ok)
+	storedKey, storedKeyErr := dbClient.GetAPIKeyByPrefix(ctx, prefix)
+	r.NoError(storedKeyErr)
+	r.Nil(storedKey.LastUsedAt)
+	for _, tc := range []struct {
+		name          string
+		url           string
+		authorization string
+		status        int
+	}{
+		{"no key", "/v1.0/users", "", http.StatusUnauthorized},
+		{"not bearer", "/v1.0/users", "Basic " + statsKey, http.StatusUnauthorized},
+		{"unknown key", "/v1.0/users", "Bearer gt_00000000_0000", http.StatusUnauthorized},
+		{"foreign token", "/v1.0/users", "Bearer some.other.token", http.StatusUnauthorized},
+		{"missing scope", "/v1.0/users", "Bearer " + statsKey, http.StatusForbidden},
+		{"has scope", "/v1.0/age_stats", "Bearer " + statsKey, http.StatusOK},
+	} {
+		resp := callRequestWithHeaders(r, "GET", tc.url, nil, map[string]string{"Authorization": tc.authorization})
+		closeErr := resp.Body.Close()
+		r.NoError(closeErr)
+		r.Equal(tc.status, resp.StatusCode, tc.name)
+		if tc.status == http.StatusUnauthorized {
+			r.NotEmpty(resp.Header.Get("WWW-Authenticate"), tc.name)
+		}
+	}
+	// Last use is tracked
+	storedKey, storedKeyErr = dbClient.GetAPIKeyByPrefix(ctx, prefix)
+	r.NoError(storedKeyErr)
+	r.NotNil(storedKey.LastUsedAt)
+	// Rotated keys stop working right away
+	rotatedKey, rotatedPrefix, rotatedHash, rotatedKeyErr := auth.GenerateAPIKey()
+	r.NoError(rotatedKeyErr)
+	_, rotateErr := dbClient.RotateAPIKey(ctx, storedKey.Id, rotatedPrefix, rotatedHash)
+	r.NoError(rotateErr)
+	oldResp := callRequestWithHeaders(r, "GET", "/v1.0/age_stats", nil, map[string]string{"Authorization": "Bearer " + statsKey})
+	r.NoError(oldResp.Body.Close())
+	r.Equal(http.StatusUnauthorized, oldResp.StatusCode)
+	rotatedResp := callRequestWithHeaders(r, "GET", "/v1.0/age_stats", nil, map[string]string{"Authorization": "Bearer " + rotatedKey})
+	r.NoError(rotatedResp.Body.Close())
+	r.Equal(http.StatusOK, rotatedResp.StatusCode)
+	// Revoked keys are rejected
+	_, revokeErr := dbClient.RevokeAPIKey(ctx, storedKey.Id)
+	r.NoError(revokeErr)
+	revokedResp := callRequestWithHeaders(r, "GET", "/v1.0/age_stats", nil, map[string]string{"Authorization": "Bearer " + rotatedKey})
+	r.NoError(revokedResp.Body.Close())
+	r.Equal(http.StatusUnauthorized, revokedResp.StatusCode)
+	// Ping stays open
+	pingResp := callRequestWithHeaders(r, "GET", "/ping", nil, map[string]string{"Authorization": ""})
+	r.NoError(pingResp.Body.Close())
+	r.Equal(http.StatusOK, pingResp.StatusCode)
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	tenantId := db.DefaultTenantId
+	_, createErr := dbClient.CreateClientCertificate(ctx, "CN=billing,O=Acme", []string{auth.ScopeStatsRead}, auth.RoleAdmin, nil, &tenantId)
+	r.NoError(createErr)
+	certRouter := controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{ClientCertificates: true})
+	call := func(url, subject, authorization string) int {
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", url, nil)
+		r.NoError(reqErr)
+		if subject != "" {
+			// The TLS handshake has already verified the chain
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: subject, Organization: []string{"Acme"}}}
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		certRouter.ServeHTTP(w, req)
+		return w.Code
+	}
+	r.Equal(http.StatusOK, call("/v1.0/age_stats", "billing", ""))
+	r.Equal(http.StatusForbidden, call("/v1.0/users", "billing", ""))
+	r.Equal(http.StatusUnauthorized, call("/v1.0/age_stats", "intruder", ""))
+	r.Equal(http.StatusUnauthorized, call("/v1.0/age_stats", "", ""))
+	// A bearer token takes precedence over the certificate
+	r.Equal(http.StatusOK, call("/v1.0/users", "billing", "Bearer "+apiKey))
+
+	clientCertificates, clientCertificatesErr := dbClient.GetClientCertificates(ctx)
+	r.NoError(clientCertificatesErr)
+	for _, clientCertificate := range clientCertificates {
+		_, revokeErr := dbClient.RevokeClientCertificate(ctx, clientCertificate.Id)
+		r.NoError(revokeErr)
+	}
+	r.Equal(http.StatusUnauthorized, call("/v1.0/age_stats", "billing", ""))
+}
+
+func TestRateLimit(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	routeLimits, routeLimitsErr := ratelimit.ParseRouteLimits("GET /v1.0/users_with_age=0.001:1")
+	r.NoError(routeLimitsErr)
+	limiter := ratelimit.NewLimiter(dbClient, ratelimit.Config{
+		Default: ratelimit.Limit{Rate: 0.001, Burst: 2},
+		Routes:  routeLimits,
+		IPLimit: ratelimit.Limit{Rate: 0.001, Burst: 10},
+	})
+	limitedRouter := controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{RateLimiter: limiter})
+	limitedKey, limitedKeyErr := issueAPIKey(ctx, auth.AllScopes, auth.RoleAdmin, nil)
+	r.NoError(limitedKeyErr)
+	otherKey, otherKeyErr := issueAPIKey(ctx, auth.AllScopes, auth.RoleAdmin, nil)
+	r.NoError(otherKeyErr)
+	call := func(url, key string) *http.Response {
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", url, nil)
+		r.NoError(reqErr)
+		req.Header.Set("Authorization", "Bearer "+key)
+		limitedRouter.ServeHTTP(w, req)
+		return w.Result()
+	}
+	for i, remaining := range []string{"1", "0"} {
+		resp := call("/v1.0/age_stats", limitedKey)
+		r.NoError(resp.Body.Close())
+		r.Equal(http.StatusOK, resp.StatusCode, i)
+		r.Equal("2", resp.Header.Get(middleware.RateLimitLimitHeader))
+		r.Equal(remaining, resp.Header.Get(middleware.RateLimitRemainingHeader))
+	}
+	limitedResp := call("/v1.0/age_stats", limitedKey)
+	r.Equal(http.StatusTooManyRequests, limitedResp.StatusCode)
+	r.Equal(middleware.ProblemContentType, limitedResp.Header.Get("Content-Type"))
+	r.NotEmpty(limitedResp.Header.Get("Retry-After"))
+	r.Equal("0", limitedResp.Header.Get(middleware.RateLimitRemainingHeader))
+	var problem api.Problem
+	r.NoError(json.NewDecoder(limitedResp.Body).Decode(&problem))
+	r.NoError(limitedResp.Body.Close())
+	r.Equal(http.StatusTooManyRequests, problem.Status)
+	// Overridden routes have their own bucket
+	routeResp := call("/v1.0/users_with_age", limitedKey)
+	r.NoError(routeResp.Body.Close())
+	r.Equal(http.StatusOK, routeResp.StatusCode)
+	r.Equal("1", routeResp.Header.Get(middleware.RateLimitLimitHeader))
+	routeResp = call("/v1.0/users_with_age", limitedKey)
+	r.NoError(routeResp.Body.Close())
+	r.Equal(http.StatusTooManyRequests, routeResp.StatusCode)
+	// Other credentials aren't affected
+	otherResp := call("/v1.0/age_stats", otherKey)
+	r.NoError(otherResp.Body.Close())
+	r.Equal(http.StatusOK, otherResp.StatusCode)
+}
+
+func TestIPRateLimit(t *testing.T) {
+	r := require.New(t)
+	newRouter := func(trustedProxies []string) *gin.Engine {
+		limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Config{
+			Default: ratelimit.Limit{Rate: 0.001, Burst: 2},
+		})
+		return controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{RateLimiter: limiter, TrustedProxies: trustedProxies})
+	}
+	limitedRouter := newRouter(nil)
+	call := func(router *gin.Engine, key, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", "/v1.0/age_stats", nil)
+		r.NoError(reqErr)
+		req.RemoteAddr = "192.0.2.10:40000"
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	// Bad credentials are limited before they are checked, and a made up X-Forwarded-For
+	// doesn't get a fresh bucket
+	r.Equal(http.StatusUnauthorized, call(limitedRouter, "not-a-key", "198.51.100.1"))
+	r.Equal(http.StatusUnauthorized, call(limitedRouter, "not-a-key", "198.51.100.2"))
+	r.Equal(http.StatusTooManyRequests, call(limitedRouter, "not-a-key", "198.51.100.3"))
+	r.Equal(http.StatusTooManyRequests, call(limitedRouter, apiKey, "198.51.100.4"))
+
+	// Behind a trusted proxy each forwarded client has its own bucket
+	proxiedRouter := newRouter([]string{"192.0.2.0/24"})
+	for i := range 3 {
+		r.Equal(http.StatusUnauthorized, call(proxiedRouter, "not-a-key", fmt.Sprintf("198.51.100.%d", i)))
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	r := require.New(t)
+	var logs bytes.Buffer
+	loggedRouter := controllers.GetRouter(logging.New(&logs, logging.FormatJSON, slog.LevelInfo), dbClient, controllers.RouterOptions{})
+	w := httptest.NewRecorder()
+	req, reqErr := http.NewRequest("PUT", "/v1.0/users/by_email/jane@example.com", strings.NewReader("{"))
+	r.NoError(reqErr)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set(requestid.Header, "request-1")
+	loggedRouter.ServeHTTP(w, req)
+	r.Equal(http.StatusBadRequest, w.Code)
+
+	var lines []map[string]any
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var line map[string]any
+		r.NoError(decoder.Decode(&line))
+		lines = append(lines, line)
+	}
+	r.Len(lines, 2)
+	for _, line := range lines {
+		r.Equal("request-1", line["request_id"])
+		r.Equal("PUT", line["method"])
+		r.Equal("/v1.0/users/by_email/:email", line["route"])
+	}
+	r.Equal("Error binding user", lines[0]["msg"])
+	r.NotEmpty(lines[0]["error"])
+	r.Equal("request", lines[1]["msg"])
+	r.Equal("WARN", lines[1]["level"])
+	r.EqualValues(http.StatusBadRequest, lines[1]["status"])
+	r.Contains(lines[1], "latency")
+	r.NotContains(logs.String(), "jane@example.com")
+}
+
+func TestRequestId(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	// Generated ids are echoed in the header and error bodies
+	unauthorizedResp := callRequestWithHeaders(r, "GET", "/v1.0/users", nil, map[string]string{"Authorization": ""})
+	r.Equal(http.StatusUnauthorized, unauthorizedResp.StatusCode)
+	generatedId := unauthorizedResp.Header.Get(requestid.Header)
+	_, parseErr := uuid.Parse(generatedId)
+	r.NoError(parseErr)
+	var errorMessage api.ErrorMessage
+	r.NoError(json.NewDecoder(unauthorizedResp.Body).Decode(&errorMessage))
+	r.NoError(unauthorizedResp.Body.Close())
+	r.Equal(generatedId, errorMessage.RequestId)
+	// Callers' ids are kept and stored with the records they create
+	_, deleteResultErr := dbClient.DeleteAllUsers(ctx)
+	r.NoError(deleteResultErr)
+	newUser, newUserErr := GetFirstNewUser()
+	r.NoError(newUserErr)
+	createResp := callRequestWithHeaders(r, "POST", "/v1.0/user", newUser, map[string]string{
+		requestid.Header:                "customer-request-9",
+		middleware.IdempotencyKeyHeader: "create-with-request-id",
+	})
+	r.NoError(createResp.Body.Close())
+	r.Equal(http.StatusOK, createResp.StatusCode)
+	r.Equal("customer-request-9", createResp.Header.Get(requestid.Header))
+	prefix, _ := auth.ParseAPIKey(apiKey)
+	storedKey, storedKeyErr := dbClient.GetAPIKeyByPrefix(ctx, prefix)
+	r.NoError(storedKeyErr)
+	tenantId := db.DefaultTenantId
+	idempotencyKey := middleware.ScopedIdempotencyKey(fmt.Sprintf("api_key:%d", storedKey.Id), &tenantId, "create-with-request-id")
+	record, reserved, reserveErr := dbClient.ReserveIdempotencyKey(ctx, idempotencyKey, "", time.Minute)
+	r.NoError(reserveErr)
+	r.False(reserved)
+	r.NotNil(record.RequestId)
+	r.Equal("customer-request-9", *record.RequestId)
+	// Ids that can't be echoed safely are replaced
+	invalidResp := callRequestWithHeaders(r, "GET", "/ping", nil, map[string]string{requestid.Header: strings.Repeat("x", 200)})
+	r.NoError(invalidResp.Body.Close())
+	_, parseErr = uuid.Parse(invalidResp.Header.Get(requestid.Header))
+	r.NoError(parseErr)
+}
+
+func TestMetrics(t *testing.T) {
+	r := require.New(t)
+	appMetrics := metrics.New(dbClient)
+	metricsRouter := controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{Metrics: appMetrics, ServeMetrics: true})
+	call := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, reqErr := http.NewRequest("GET", url, nil)
+		r.NoError(reqErr)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		metricsRouter.ServeHTTP(w, req)
+		return w
+	}
+	r.Equal(http.StatusOK, call("/v1.0/age_stats").Code)
+	r.Equal(http.StatusNotFound, call("/v1.0/no/such/route").Code)
+
+	w := call("/metrics")
+	r.Equal(http.StatusOK, w.Code)
+	body := w.Body.String()
+	r.Contains(body, `http_request_duration_seconds_count{method="GET",route="/v1.0/age_stats",status="200"} 1`)
+	r.Contains(body, `http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+	r.Contains(body, `db_query_duration_seconds_count{statement="get_age_stats"} 1`)
+	r.Contains(body, "go_sql_open_connections")
+	r.Contains(body, "go_goroutines")
+	users, usersErr := dbClient.CountAllUsers(context.Background())
+	r.NoError(usersErr)
+	r.Contains(body, fmt.Sprintf("\nusers %d\n", users))
+}
+
+func TestTracing(t *testing.T) {
+	r := require.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	dbClient.AddQueryObserver(tracing.NewQueryObserver(provider))
+	tracedRouter := controllers.GetRouter(testLogger(), dbClient, controllers.RouterOptions{TracerProvider: provider})
+	w := httptest.NewRecorder()
+	req, reqErr := http.NewRequest("GET", "/v1.0/age_stats", nil)
+	r.NoError(reqErr)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tracedRouter.ServeHTTP(w, req)
+	r.Equal(http.StatusOK, w.Code)
+
+	var server sdktrace.ReadOnlySpan
+	var queries []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			continue
+		}
+		if span.SpanKind() == trace.SpanKindServer {
+			server = span
+		} else {
+			queries = append(queries, span)
+		}
+	}
+	r.NotNil(server)
+	r.Equal("GET /v1.0/age_stats", server.Name())
+	r.Equal("00f067aa0ba902b7", server.Parent().SpanID().String())
+	r.True(server.Parent().IsRemote())
+	r.Contains(server.Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+	r.Contains(server.Attributes(), attribute.String("http.route", "/v1.0/age_stats"))
+	queryNames := make([]string, len(queries))
+	for i, query := range queries {
+		queryNames[i] = query.Name()
+		r.Equal(server.SpanContext().SpanID(), query.Parent().SpanID())
+	}
+	r.Contains(queryNames, "db api_keys_get_by_prefix")
+	r.Contains(queryNames, "db get_age_stats")
+}
+
+func TestRolePermissions(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	createUsers(ctx, r)
+	users, usersErr := dbClient.GetUsers(ctx)
+	r.NoError(usersErr)
+	ownUser, otherUser := users[0], users[1]
+	supportKey, supportKeyErr := issueAPIKey(ctx, auth.AllScopes, auth.RoleSupport, nil)
+	r.NoError(supportKeyErr)
+	userKey, userKeyErr := issueAPIKey(ctx, auth.AllScopes, auth.RoleUser, &ownUser.Id)
+	r.NoError(userKeyErr)
+	keys := map[string]string{auth.RoleAdmin: apiKey, auth.RoleSupport: supportKey, auth.RoleUser: userKey}
+	updatedUser := func(user models.User) *models.User {
+		user.LastName = "Updated"
+		return &user
+	}
+	newUser, newUserErr := models.GetCreateUser("Role", "Matrix", "role.matrix@gmail.com", "1970-01-01")
+	r.NoError(newUserErr)
+	for _, tc := range []struct {
+		name   string
+		method string
+		url    string
+		data   any
+		status map[string]int
+	}{
+		{"read own", "GET", "/v1.0/user", models.GetIdUser(ownUser.Id), map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 200, auth.RoleUser: 200}},
+		{"read other", "GET", "/v1.0/user", models.GetIdUser(otherUser.Id), map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 200, auth.RoleUser: 403}},
+		{"list", "GET", "/v1.0/users", nil, map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 200, auth.RoleUser: 403}},
+		{"list with age", "GET", "/v1.0/users_with_age", nil, map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 200, auth.RoleUser: 403}},
+		{"stats", "GET", "/v1.0/age_stats", nil, map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 200, auth.RoleUser: 403}},
+		{"update own", "PUT", "/v1.0/user", updatedUser(ownUser), map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 403, auth.RoleUser: 200}},
+		{"update other", "PUT", "/v1.0/user", updatedUser(otherUser), map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 403, auth.RoleUser: 403}},
+		// Callers who can't update anyone are refused before their request is validated
+		{"update invalid", "PUT", "/v1.0/user", gin.H{"id": ownUser.Id, "first_name": "", "last_name": "B", "email": ownUser.Email, "birthday": "1970-01-01"}, map[string]int{auth.RoleAdmin: 400, auth.RoleSupport: 403, auth.RoleUser: 400}},
+		{"upsert", "PUT", "/v1.0/users/by_email/" + otherUser.Email, gin.H{"first_name": "A", "last_name": "B", "birthday": "1970-01-01"}, map[string]int{auth.RoleAdmin: 200, auth.RoleSupport: 403, auth.RoleUser: 403}},
+		{"create", "POST", "/v1.0/user", newUser, map[string]int{auth.RoleSupport: 403, auth.RoleUser: 403}},
+		{"delete", "DELETE", "/v1.0/user", models.GetIdUser(otherUser.Id), map[string]int{auth.RoleSupport: 403, auth.RoleUser: 403}},
+	} {
+		for role, status := range tc.status {
+			resp := callRequestWithHeaders(r, tc.method, tc.url, tc.data, map[string]string{"Authorization": "Bearer " + keys[role]})
+			r.NoError(resp.Body.Close())
+			r.Equal(status, resp.StatusCode, "%s as %s", tc.name, role)
+		}
+	}
+	// Personal fields are hidden from roles without PII access, except on the caller's own user
+	for role, pii := range map[string]map[int64]bool{
+		auth.RoleAdmin:   {ownUser.Id: true, otherUser.Id: true},
+		auth.RoleSupport: {ownUser.Id: false, otherUser.Id: false},
+		auth.RoleUser:    {ownUser.Id: true},
+	} {
+		for userId, visible := range pii {
+			resp := callRequestWithHeaders(r, "GET", "/v1.0/user", models.GetIdUser(userId), map[string]string{"Authorization": "Bearer " + keys[role]})
+			var body struct {
+				User map[string]any `json:"user"`
+			}
+			r.NoError(json.NewDecoder(resp.Body).Decode(&body))
+			r.NoError(resp.Body.Close())
+			_, hasEmail := body.User["email"]
+			_, hasBirthday := body.User["birthday"]
+			r.Equal(visible, hasEmail, "email of %d as %s", userId, role)
+			r.Equal(visible, hasBirthday, "birthday of %d as %s", userId, role)
+			r.NotEmpty(body.User["first_name"])
+		}
+	}
+	supportResp := callRequestWithHeaders(r, "GET", "/v1.0/users", nil, map[string]string{"Authorization": "Bearer " + supportKey})
+	var supportUsers struct {
+		Users []map[string]any `json:"users"`
+	}
+	r.NoError(json.NewDecoder(supportResp.Body).Decode(&supportUsers))
+	r.NoError(supportResp.Body.Close())
+	r.NotEmpty(supportUsers.Users)
+	for _, user := range supportUsers.Users {
+		r.NotContains(user, "email")
+		r.NotContains(user, "birthday")
+	}
+	supportResp = callRequestWithHeaders(r, "GET", "/v1.0/users_with_age", nil, map[string]string{"Authorization": "Bearer " + supportKey})
+	r.NoError(json.NewDecoder(supportResp.Body).Decode(&supportUsers))
+	r.NoError(supportResp.Body.Close())
+	r.NotEmpty(supportUsers.Users)
+	for _, user := range supportUsers.Users {
+		r.NotContains(user, "email")
+		r.NotContains(user, "birthday")
+		r.NotContains(user, "age_in_years")
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	createUsers(ctx, r)
+	defaultUser, defaultUserErr := dbClient.GetFirstUser(ctx)
+	r.NoError(defaultUserErr)
+	result, orgErr := dbClient.CreateOrganization(ctx, "acme", "Acme")
+	r.NoError(orgErr)
+	acmeId, acmeIdErr := result.LastInsertId()
+	r.NoError(acmeIdErr)
+	acmeKey, acmeKeyErr := issueTenantAPIKey(ctx, auth.AllScopes, auth.RoleAdmin, nil, &acmeId)
+	r.NoError(acmeKeyErr)
+	platformKey, platformKeyErr := issueTenantAPIKey(ctx, auth.AllScopes, auth.RoleAdmin, nil, nil)
+	r.NoError(platformKeyErr)
+	acmeHeaders := map[string]string{"Authorization": "Bearer " + acmeKey}
+	countUsers := func(headers map[string]string) int {
+		resp := callRequestWithHeaders(r, "GET", "/v1.0/users", nil, headers)
+		defer func() {
+			closeErr := resp.Body.Close()
+			if closeErr != nil {
+				log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+			}
+		}()
+		r.Equal(http.StatusOK, resp.StatusCode)
+		var apiUsers api.UsersMessage
+		r.NoError(json.NewDecoder(resp.Body).Decode(&apiUsers))
+		return len(apiUsers.Users)
+	}
+	// Acme starts empty and can't reach default users
+	r.Equal(0, countUsers(acmeHeaders))
+	getResp := callRequestWithHeaders(r, "GET", "/v1.0/user", models.GetIdUser(defaultUser.Id), acmeHeaders)
+	r.NoError(getResp.Body.Close())
+	r.Equal(http.StatusNotFound, getResp.StatusCode)
+	deleteResp := callRequestWithHeaders(r, "DELETE", "/v1.0/user", models.GetIdUser(defaultUser.Id), acmeHeaders)
+	r.NoError(deleteResp.Body.Close())
+	_, stillThereErr := dbClient.GetUser(ctx, defaultUser.Id)
+	r.NoError(stillThereErr)
+	statsResp := callRequestWithHeaders(r, "GET", "/v1.0/age_stats", nil, acmeHeaders)
+	var ageStats api.AgeStatsMessage
+	r.NoError(json.NewDecoder(statsResp.Body).Decode(&ageStats))
+	r.NoError(statsResp.Body.Close())
+	r.Equal(models.AgeStats{}, ageStats.AgeStats)
+	// Emails are only unique within a tenant
+	newUser, newUserErr := GetFirstNewUser()
+	r.NoError(newUserErr)
+	createResp := callRequestWithHeaders(r, "POST", "/v1.0/user", newUser, acmeHeaders)
+	r.NoError(createResp.Body.Close())
+	r.Equal(http.StatusOK, createResp.StatusCode)
+	r.Equal(1, countUsers(acmeHeaders))
+	r.Equal(3, countUsers(nil))
+	// Bound keys can't switch tenants
+	switchResp := callRequestWithHeaders(r, "GET", "/v1.0/users", nil, map[string]string{"Authorization": "Bearer " + acmeKey, middleware.TenantHeader: "default"})
+	r.NoError(switchResp.Body.Close())
+	r.Equal(http.StatusForbidden, switchResp.StatusCode)
+	// Platform keys have to pick one
+	platformResp := callRequestWithHeaders(r, "GET", "/v1.0/users", nil, map[string]string{"Authorization": "Bearer " + platformKey})
+	r.NoError(platformResp.Body.Close())
+	r.Equal(http.StatusBadRequest, platformResp.StatusCode)
+	unknownResp := callRequestWithHeaders(r, "GET", "/v1.0/users", nil, map[string]string{"Authorization": "Bearer " + platformKey, middleware.TenantHeader: "nope"})
+	r.NoError(unknownResp.Body.Close())
+	r.Equal(http.StatusBadRequest, unknownResp.StatusCode)
+	r.Equal(1, countUsers(map[string]string{"Authorization": "Bearer " + platformKey, middleware.TenantHeader: "acme"}))
+	// Queries without a tenant are refused
+	_, noTenantErr := dbClient.GetUsers(context.Background())
+	r.ErrorIs(noTenantErr, db.ErrNoTenant)
+	_, noTenantErr = dbClient.GetAgeStats(context.Background())
+	r.ErrorIs(noTenantErr, db.ErrNoTenant)
+	// Clean up acme's user so other tests only see the default tenant
+	_, deleteAllErr := dbClient.DeleteAllUsers(db.WithTenant(ctx, acmeId))
+	r.NoError(deleteAllErr)
+}
+
 func TestCreateUserAction(t *testing.T) {
 	r := require.New(t)
-	ctx := context.Background()
+	ctx := testContext()
 	_, deleteResultErr := dbClient.DeleteAllUsers(ctx)
 	r.NoError(deleteResultErr)
 	// Bad user data
@@ -130,9 +781,77 @@ func TestCreateUserAction(t *testing.T) {
 	r.Equal(http.StatusInternalServerError, dupResp.StatusCode)
 }
 
+func TestCreateUserActionIdempotency(t *testing.T) {
+	r := require.New(t)
+	ctx := testContext()
+	_, deleteResultErr := dbClient.DeleteAllUsers(ctx)
+	r.NoError(deleteResultErr)
+	newUser, newUserErr := GetFirstNewUser()
+	r.NoError(newUserErr)
+	headers := map[string]string{middleware.IdempotencyKeyHeader: "create-testy-1"}
+	// First request creates the user
+	firstResp := callRequestWithHeaders(r, "POST", "/v1.0/user", newUser, headers)
+	defer func() {
+		closeErr := firstResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusOK, firstResp.StatusCode)
+	firstBytes, firstBytesErr := io.ReadAll(firstResp.Body)
+	r.NoError(firstBytesErr)
+	// A retry replays the original response instead of failing on the unique email
+	retryResp := callRequestWithHeaders(r, "POST", "/v1.0/user", newUser, headers)
+	defer func() {
+		closeErr := retryResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusOK, retryResp.StatusCode)
+	r.Equal("true", retryResp.Header.Get(middleware.IdempotentReplayedHeader))
+	retryBytes, retryBytesErr := io.ReadAll(retryResp.Body)
+	r.NoError(retryBytesErr)
+	r.JSONEq(string(firstBytes), string(retryBytes))
+	users, usersErr := dbClient.GetUsers(ctx)
+	r.NoError(usersErr)
+	r.Len(users, 1)
+	// Reusing the key for another payload
+	secondUser, secondUserErr := GetSecondNewUser()
+	r.NoError(secondUserErr)
+	mismatchResp := callRequestWithHeaders(r, "POST", "/v1.0/user", secondUser, headers)
+	defer func() {
+		closeErr := mismatchResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusUnprocessableEntity, mismatchResp.StatusCode)
+	// A duplicate of a request that is still running
+	// Keys are stored per caller
+	prefix, _ := auth.ParseAPIKey(apiKey)
+	storedKey, storedKeyErr := dbClient.GetAPIKeyByPrefix(ctx, prefix)
+	r.NoError(storedKeyErr)
+	tenantId := db.DefaultTenantId
+	inFlightKey := middleware.ScopedIdempotencyKey(fmt.Sprintf("api_key:%d", storedKey.Id), &tenantId, "create-john-1")
+	// The parts can't run into each other
+	r.NotEqual(inFlightKey, middleware.ScopedIdempotencyKey(fmt.Sprintf("api_key:%d:%d", storedKey.Id, tenantId), nil, "create-john-1"))
+	_, reserved, reserveErr := dbClient.ReserveIdempotencyKey(ctx, inFlightKey, "in-flight", time.Minute)
+	r.NoError(reserveErr)
+	r.True(reserved)
+	inFlightResp := callRequestWithHeaders(r, "POST", "/v1.0/user", secondUser, map[string]string{middleware.IdempotencyKeyHeader: "create-john-1"})
+	defer func() {
+		closeErr := inFlightResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusConflict, inFlightResp.StatusCode)
+}
+
 func TestGetUserAction(t *testing.T) {
 	r := require.New(t)
-	ctx := context.Background()
+	ctx := testContext()
 	user, userErr := getFirstOrCreateUser(ctx)
 	r.NoError(userErr)
 	userId := models.GetIdUser(user.Id)
@@ -158,7 +877,7 @@ func TestGetUserAction(t *testing.T) {
 }
 
 func TestUpdateUserAction(t *testing.T) {
-	ctx := context.Background()
+	ctx := testContext()
 	r := require.New(t)
 	user, userErr := getFirstOrCreateUser(ctx)
 	r.NoError(userErr)
@@ -180,7 +899,7 @@ func TestUpdateUserAction(t *testing.T) {
 }
 
 func TestDeleteUserAction(t *testing.T) {
-	ctx := context.Background()
+	ctx := testContext()
 	r := require.New(t)
 	user, userErr := getFirstOrCreateUser(ctx)
 	r.NoError(userErr)
@@ -199,8 +918,53 @@ func TestDeleteUserAction(t *testing.T) {
 	r.True(errors.Is(userErr, sql.ErrNoRows))
 }
 
+func TestUpsertUserByEmailAction(t *testing.T) {
+	ctx := testContext()
+	r := require.New(t)
+	_, deleteResultErr := dbClient.DeleteAllUsers(ctx)
+	r.NoError(deleteResultErr)
+	upsertUser := gin.H{"first_name": "Upsert", "last_name": "User", "birthday": "1985-05-05"}
+	// Unknown email creates the user
+	createdResp := callRequest(r, "PUT", "/v1.0/users/by_email/upsert.user@gmail.com", upsertUser)
+	defer func() {
+		closeErr := createdResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusCreated, createdResp.StatusCode)
+	var createdUser api.IdUserMessage
+	r.NoError(json.NewDecoder(createdResp.Body).Decode(&createdUser))
+	// Known email updates the same user
+	upsertUser["first_name"] = "Updated"
+	updatedResp := callRequest(r, "PUT", "/v1.0/users/by_email/upsert.user@gmail.com", upsertUser)
+	defer func() {
+		closeErr := updatedResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusOK, updatedResp.StatusCode)
+	var updatedUser api.IdUserMessage
+	r.NoError(json.NewDecoder(updatedResp.Body).Decode(&updatedUser))
+	r.Equal(createdUser.User.Id, updatedUser.User.Id)
+	user, userErr := dbClient.GetUser(ctx, updatedUser.User.Id)
+	r.NoError(userErr)
+	r.Equal("Updated", user.FirstName)
+	r.Equal("upsert.user@gmail.com", user.Email)
+	// Bad data
+	badResp := callRequest(r, "PUT", "/v1.0/users/by_email/upsert.user@gmail.com", gin.H{"first_name": "Updated"})
+	defer func() {
+		closeErr := badResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusBadRequest, badResp.StatusCode)
+}
+
 func TestGetUsersAction(t *testing.T) {
-	ctx := context.Background()
+	ctx := testContext()
 	r := require.New(t)
 	createUsers(ctx, r)
 	// Get users
@@ -220,8 +984,49 @@ func TestGetUsersAction(t *testing.T) {
 	r.Equal(3, len(apiUsers.Users))
 }
 
+func TestGetUsersActionStreaming(t *testing.T) {
+	ctx := testContext()
+	r := require.New(t)
+	_, deleteResultErr := dbClient.DeleteAllUsers(ctx)
+	r.NoError(deleteResultErr)
+	// An empty table is still a valid document
+	emptyResp := callRequest(r, "GET", "/v1.0/users", nil)
+	defer func() {
+		closeErr := emptyResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusOK, emptyResp.StatusCode)
+	emptyBytes, emptyBytesErr := io.ReadAll(emptyResp.Body)
+	r.NoError(emptyBytesErr)
+	r.JSONEq(`{"users": []}`, string(emptyBytes))
+	// Enough users to span several flushes
+	for i := 0; i < 250; i++ {
+		newUser, newUserErr := models.GetCreateUser("Stream", "User", fmt.Sprintf("stream.user.%d@gmail.com", i), "1990-01-01")
+		r.NoError(newUserErr)
+		_, createErr := createUser(ctx, newUser)
+		r.NoError(createErr)
+	}
+	goodResp := callRequest(r, "GET", "/v1.0/users", nil)
+	defer func() {
+		closeErr := goodResp.Body.Close()
+		if closeErr != nil {
+			log.Printf("Error: Couldn't close the response body - %s\n", closeErr)
+		}
+	}()
+	r.Equal(http.StatusOK, goodResp.StatusCode)
+	r.Empty(goodResp.Header.Get("Content-Length"))
+	bodyBytes, bodyBytesErr := io.ReadAll(goodResp.Body)
+	r.NoError(bodyBytesErr)
+	var apiUsers api.UsersMessage
+	jsonErr := json.Unmarshal(bodyBytes, &apiUsers)
+	r.NoError(jsonErr)
+	r.Equal(250, len(apiUsers.Users))
+}
+
 func TestGetUsersWithAgeAction(t *testing.T) {
-	ctx := context.Background()
+	ctx := testContext()
 	r := require.New(t)
 	createUsers(ctx, r)
 	// Get Users with age
@@ -242,7 +1047,7 @@ func TestGetUsersWithAgeAction(t *testing.T) {
 }
 
 func TestGetAgeStatsAction(t *testing.T) {
-	ctx := context.Background()
+	ctx := testContext()
 	r := require.New(t)
 	createUsers(ctx, r)
 	// Users with age
@@ -331,12 +1136,50 @@ func createUsers(ctx context.Context, r *require.Assertions) {
 	r.NoError(createErr)
 }
 
+// testContext scopes db.Client calls to the default tenant, like the router does for the test key.
+func testContext() context.Context {
+	return db.WithTenant(context.Background(), db.DefaultTenantId)
+}
+
+// issueAPIKey issues a key bound to the default tenant.
+func issueAPIKey(ctx context.Context, scopes []string, role string, userId *int64) (string, error) {
+	tenantId := db.DefaultTenantId
+	return issueTenantAPIKey(ctx, scopes, role, userId, &tenantId)
+}
+
+func issueTenantAPIKey(ctx context.Context, scopes []string, role string, userId, tenantId *int64) (string, error) {
+	key, prefix, hash, keyErr := auth.GenerateAPIKey()
+	if keyErr != nil {
+		return "", keyErr
+	}
+	_, createErr := dbClient.CreateAPIKey(ctx, "controllers_test", prefix, hash, scopes, role, userId, tenantId)
+	if createErr != nil {
+		return "", createErr
+	}
+	return key, nil
+}
+
+func testLogger() *slog.Logger {
+	return logging.New(os.Stdout, logging.FormatText, slog.LevelWarn)
+}
+
 func callRequest(r *require.Assertions, method, url string, data any) *http.Response {
+	return callRequestWithHeaders(r, method, url, data, nil)
+}
+
+// callRequestWithHeaders authenticates with the all scopes test key unless headers has its own
+// Authorization.
+func callRequestWithHeaders(r *require.Assertions, method, url string, data any, headers map[string]string) *http.Response {
 	jsonBodyReader, jsonBodyReaderErr := jsonutils.ToJsonReader(data)
 	r.NoError(jsonBodyReaderErr)
 	w := httptest.NewRecorder()
 	req, reqErr := http.NewRequest(method, url, jsonBodyReader)
 	r.NoError(reqErr)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 	router.ServeHTTP(w, req)
 	return w.Result()
 }
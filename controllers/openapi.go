@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+
+	"github.com/brandonrachal/gin-and-tonic/middleware"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/brandonrachal/gin-and-tonic/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	OpenAPIPath = "/openapi.json"
+	DocsPath    = "/docs"
+)
+
+var apiInfo = openapi.Info{
+	Title:       "gin-and-tonic",
+	Version:     "1.0",
+	Description: "A go api server for fun",
+}
+
+// documentedRoutes registers routes with a router group and describes them in the OpenAPI
+// document at the same time, so the document can't miss a route.
+type documentedRoutes struct {
+	group *gin.RouterGroup
+	spec  *openapi.Builder
+	// headers and responses are added to every route, e.g. those of the group's middleware.
+	headers   []openapi.Parameter
+	responses map[int]openapi.Body
+}
+
+// handle registers route, checking its scopes before handlers run.
+func (d documentedRoutes) handle(route openapi.Route, handlers ...gin.HandlerFunc) {
+	if len(route.Scopes) > 0 {
+		handlers = append([]gin.HandlerFunc{middleware.RequireScopes(route.Scopes...)}, handlers...)
+	}
+	d.group.Handle(route.Method, route.Path, handlers...)
+	route.Path = path.Join(d.group.BasePath(), route.Path)
+	route.Headers = append(slices.Clone(d.headers), route.Headers...)
+	responses := make(map[int]openapi.Body)
+	maps.Copy(responses, d.responses)
+	maps.Copy(responses, route.Responses)
+	route.Responses = responses
+	d.spec.Add(route)
+}
+
+// jsonError is a response with an api.ErrorMessage body.
+func jsonError(description string) openapi.Body {
+	return openapi.Body{Description: description, Value: api.ErrorMessage{}}
+}
+
+var (
+	tenantHeader = openapi.Parameter{
+		Name:        middleware.TenantHeader,
+		In:          "header",
+		Description: "Slug of the organization, for platform credentials",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	idempotencyKeyHeader = openapi.Parameter{
+		Name:        middleware.IdempotencyKeyHeader,
+		In:          "header",
+		Description: "Makes retries replay the first response",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	// idempotencyResponses are those of middleware.Idempotency.
+	idempotencyResponses = map[int]openapi.Body{
+		http.StatusConflict:            jsonError("A request with the same Idempotency-Key is still running"),
+		http.StatusUnprocessableEntity: jsonError("The Idempotency-Key was used with another request"),
+	}
+	// v1Responses are those of the /v1.0 middleware.
+	v1Responses = map[int]openapi.Body{
+		http.StatusBadRequest:          jsonError("The request or its tenant is invalid"),
+		http.StatusUnauthorized:        jsonError("The credentials are missing or invalid"),
+		http.StatusForbidden:           jsonError("The credentials don't allow this"),
+		http.StatusInternalServerError: jsonError(""),
+	}
+	rateLimitedResponse = openapi.Body{
+		Description: "The client is over its rate limit or quota",
+		ContentType: middleware.ProblemContentType,
+		Value:       api.Problem{},
+	}
+)
+
+// with returns responses and extra, extra winning.
+func with(responses map[int]openapi.Body, extra map[int]openapi.Body) map[int]openapi.Body {
+	merged := maps.Clone(responses)
+	maps.Copy(merged, extra)
+	return merged
+}
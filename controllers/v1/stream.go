@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamFlushRows is how many items are written between flushes of a streamed response.
+const streamFlushRows = 100
+
+// streamJSONArray writes {"key": [...]} to the response one item at a time as seq yields them,
+// flushing periodically so the body goes out with chunked transfer encoding instead of being
+// buffered. If seq fails before anything is written the error is returned and the caller can
+// still send a normal error response; once the body has started the response is abandoned and
+// the client sees truncated JSON.
+func streamJSONArray[T any](ctx *gin.Context, key string, seq iter.Seq2[T, error]) error {
+	keyBytes, keyErr := json.Marshal(key)
+	if keyErr != nil {
+		return keyErr
+	}
+	started := false
+	start := func() error {
+		started = true
+		ctx.Header("Content-Type", "application/json; charset=utf-8")
+		ctx.Status(http.StatusOK)
+		_, err := fmt.Fprintf(ctx.Writer, "{%s:[", keyBytes)
+		return err
+	}
+	count := 0
+	for item, err := range seq {
+		if err != nil {
+			return err
+		}
+		itemBytes, itemErr := json.Marshal(item)
+		if itemErr != nil {
+			return itemErr
+		}
+		if !started {
+			if startErr := start(); startErr != nil {
+				return startErr
+			}
+		} else if _, writeErr := ctx.Writer.Write([]byte{','}); writeErr != nil {
+			return writeErr
+		}
+		if _, writeErr := ctx.Writer.Write(itemBytes); writeErr != nil {
+			return writeErr
+		}
+		count++
+		if count%streamFlushRows == 0 {
+			ctx.Writer.Flush()
+		}
+	}
+	if !started {
+		if startErr := start(); startErr != nil {
+			return startErr
+		}
+	}
+	if _, writeErr := ctx.Writer.Write([]byte("]}")); writeErr != nil {
+		return writeErr
+	}
+	ctx.Writer.Flush()
+	return nil
+}
@@ -1,10 +1,16 @@
 package v1
 
 import (
-	"log"
+	"database/sql"
+	"errors"
+	"iter"
+	"log/slog"
 	"net/http"
+	"strings"
 
+	"github.com/brandonrachal/gin-and-tonic/auth"
 	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/logging"
 	"github.com/brandonrachal/gin-and-tonic/models"
 	"github.com/brandonrachal/gin-and-tonic/models/api"
 	"github.com/gin-gonic/gin"
@@ -12,107 +18,257 @@ import (
 
 type UsersController struct {
 	DBClient *db.Client
-	logger   *log.Logger
+	logger   *slog.Logger
+	policy   *auth.Policy
 }
 
-func NewUsersController(logger *log.Logger, dbClient *db.Client) *UsersController {
+func NewUsersController(logger *slog.Logger, dbClient *db.Client, policy *auth.Policy) *UsersController {
 	return &UsersController{
 		DBClient: dbClient,
 		logger:   logger,
+		policy:   policy,
 	}
 }
 
+// log returns the request's logger, see middleware.RequestLogger.
+func (c *UsersController) log(ctx *gin.Context) *slog.Logger {
+	return logging.FromContext(ctx.Request.Context(), c.logger)
+}
+
+// grants resolves what the caller may do. When that fails the error response has already been
+// written and ok is false.
+func (c *UsersController) grants(ctx *gin.Context) (*auth.Grants, bool) {
+	principal, ok := auth.GetPrincipal(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, api.NewErrorMessage(ctx.Request.Context(), "missing bearer token"))
+		return nil, false
+	}
+	grants, grantsErr := c.policy.Grants(ctx.Request.Context(), principal)
+	if grantsErr != nil {
+		c.log(ctx).Error("Error resolving permissions", slog.String("subject", principal.Subject), slog.Any("error", grantsErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		return nil, false
+	}
+	return grants, true
+}
+
+func forbidden(ctx *gin.Context) {
+	ctx.JSON(http.StatusForbidden, api.NewErrorMessage(ctx.Request.Context(), "You are not allowed to do that."))
+}
+
 func (c *UsersController) CreateUserAction(ctx *gin.Context) {
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.Can(auth.PermissionUsersCreate) {
+		forbidden(ctx)
+		return
+	}
 	var user models.CreateUser
 	if err := ctx.ShouldBindJSON(&user); err != nil {
-		c.logger.Printf("Error binding user - %s\n", err.Error())
-		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(err.Error()))
+		c.log(ctx).Warn("Error binding user", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), err.Error()))
 		return
 	}
-	result, resultErr := c.DBClient.CreateUser(ctx, user.FirstName, user.LastName, user.Email, user.Birthday.ToTime())
+	result, resultErr := c.DBClient.CreateUser(ctx.Request.Context(), user.FirstName, user.LastName, user.Email, user.Birthday.ToTime())
 	if resultErr != nil {
-		c.logger.Printf("Error inserting user - %s\n", resultErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("Failed to insert user"))
+		c.log(ctx).Error("Error inserting user", slog.Any("error", resultErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "Failed to insert user"))
 		return
 	}
 	userId, userIdErr := result.LastInsertId()
 	if userIdErr != nil {
-		c.logger.Printf("Error getting the last id - %s\n", userIdErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("Failed to insert user"))
+		c.log(ctx).Error("Error getting the last id", slog.Any("error", userIdErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "Failed to insert user"))
 		return
 	}
 	ctx.JSON(http.StatusOK, api.NewIdUserMessage(userId))
 }
 
 func (c *UsersController) GetUserAction(ctx *gin.Context) {
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.CanOnSomeUser(auth.PermissionUsersReadAny, auth.PermissionUsersReadOwn) {
+		forbidden(ctx)
+		return
+	}
 	var idUser models.IdUser
 	if err := ctx.ShouldBindJSON(&idUser); err != nil {
-		c.logger.Printf("Error binding user - %s\n", err.Error())
-		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(err.Error()))
+		c.log(ctx).Warn("Error binding user", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), err.Error()))
 		return
 	}
-	user, userErr := c.DBClient.GetUser(ctx, idUser.Id)
-	if userErr != nil {
-		c.logger.Printf("Error retriving user id %d - %s\n", idUser.Id, userErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("something went wrong"))
+	if !grants.CanOnUser(auth.PermissionUsersReadAny, auth.PermissionUsersReadOwn, idUser.Id) {
+		forbidden(ctx)
+		return
+	}
+	user, userErr := c.DBClient.GetUser(ctx.Request.Context(), idUser.Id)
+	if errors.Is(userErr, sql.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, api.NewErrorMessage(ctx.Request.Context(), "user not found"))
+		return
+	} else if userErr != nil {
+		c.log(ctx).Error("Error retrieving user", slog.Int64("user_id", idUser.Id), slog.Any("error", userErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{"user": user})
+	if !grants.CanViewPII(user.Id) {
+		user.Redact()
+	}
+	ctx.JSON(http.StatusOK, api.NewUserMessage(*user))
 }
 
 func (c *UsersController) UpdateUserAction(ctx *gin.Context) {
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.CanOnSomeUser(auth.PermissionUsersUpdateAny, auth.PermissionUsersUpdateOwn) {
+		forbidden(ctx)
+		return
+	}
 	var user models.User
 	if err := ctx.ShouldBindJSON(&user); err != nil {
-		c.logger.Printf("Error binding user - %s\n", err.Error())
-		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(err.Error()))
+		c.log(ctx).Warn("Error binding user", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), err.Error()))
+		return
+	}
+	if !grants.CanOnUser(auth.PermissionUsersUpdateAny, auth.PermissionUsersUpdateOwn, user.Id) {
+		forbidden(ctx)
 		return
 	}
-	_, resultErr := c.DBClient.UpdateUser(ctx, user.Id, user.FirstName, user.LastName, user.Email, user.Birthday.ToTime())
+	_, resultErr := c.DBClient.UpdateUser(ctx.Request.Context(), user.Id, user.FirstName, user.LastName, user.Email, user.Birthday.ToTime())
 	if resultErr != nil {
-		c.logger.Printf("Error inserting user - %s\n", resultErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("Failed to insert user"))
+		c.log(ctx).Error("Error updating user", slog.Int64("user_id", user.Id), slog.Any("error", resultErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "Failed to insert user"))
 		return
 	}
 	ctx.JSON(http.StatusOK, api.NewMessage("User updated successfully."))
 }
 
+func (c *UsersController) UpsertUserByEmailAction(ctx *gin.Context) {
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.Can(auth.PermissionUsersCreate) || !grants.Can(auth.PermissionUsersUpdateAny) {
+		forbidden(ctx)
+		return
+	}
+	email := strings.TrimSpace(ctx.Param("email"))
+	if email == "" {
+		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), "email is required"))
+		return
+	}
+	var user models.UpsertUser
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		c.log(ctx).Warn("Error binding user", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), err.Error()))
+		return
+	}
+	userId, created, upsertErr := c.DBClient.UpsertUserByEmail(ctx.Request.Context(), user.FirstName, user.LastName, email, user.Birthday.ToTime())
+	if upsertErr != nil {
+		c.log(ctx).Error("Error upserting user", slog.Any("error", upsertErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "Failed to upsert user"))
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	ctx.JSON(status, api.NewIdUserMessage(userId))
+}
+
 func (c *UsersController) DeleteUserAction(ctx *gin.Context) {
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.Can(auth.PermissionUsersDeleteAny) {
+		forbidden(ctx)
+		return
+	}
 	var user models.IdUser
 	if err := ctx.ShouldBindJSON(&user); err != nil {
-		c.logger.Printf("Error binding user - %s\n", err.Error())
-		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(err.Error()))
+		c.log(ctx).Warn("Error binding user", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), err.Error()))
 		return
 	}
-	_, deleteUserErr := c.DBClient.DeleteUser(ctx, user.Id)
+	_, deleteUserErr := c.DBClient.DeleteUser(ctx.Request.Context(), user.Id)
 	if deleteUserErr != nil {
-		c.logger.Printf("Error retrieving user id %d - %s\n", user.Id, deleteUserErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("something went wrong"))
+		c.log(ctx).Error("Error deleting user", slog.Int64("user_id", user.Id), slog.Any("error", deleteUserErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		return
 	}
 	ctx.JSON(http.StatusOK, api.NewMessage("User deleted successfully."))
 }
 
 func (c *UsersController) GetUsersAction(ctx *gin.Context) {
-	users, usersErr := c.DBClient.GetUsers(ctx)
-	if usersErr != nil {
-		c.logger.Printf("Error retrieving all users - %s\n", usersErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("something went wrong"))
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.Can(auth.PermissionUsersList) {
+		forbidden(ctx)
+		return
+	}
+	// Rows are streamed straight from the cursor so large tables are never held in memory.
+	// The query runs on the request context and stops as soon as the client goes away.
+	users := redactUsers(grants, c.DBClient.IterUsers(ctx.Request.Context()))
+	streamErr := streamJSONArray(ctx, "users", users)
+	if streamErr != nil {
+		c.log(ctx).Error("Error streaming all users", slog.Any("error", streamErr))
+		if !ctx.Writer.Written() {
+			ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		}
 	}
-	ctx.JSON(http.StatusOK, api.NewUsersMessage(users))
 }
 
 func (c *UsersController) GetUsersWithAgeAction(ctx *gin.Context) {
-	usersWithAge, usersWithAgeErr := c.DBClient.GetUsersWithAge(ctx)
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.Can(auth.PermissionUsersList) {
+		forbidden(ctx)
+		return
+	}
+	usersWithAge, usersWithAgeErr := c.DBClient.GetUsersWithAge(ctx.Request.Context())
 	if usersWithAgeErr != nil {
-		c.logger.Printf("Error retrieving users with age - %s\n", usersWithAgeErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("something went wrong"))
+		c.log(ctx).Error("Error retrieving users with age", slog.Any("error", usersWithAgeErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		return
+	}
+	for i := range usersWithAge {
+		if !grants.CanViewPII(usersWithAge[i].Id) {
+			usersWithAge[i].Redact()
+		}
 	}
 	ctx.JSON(http.StatusOK, api.NewUsersWithAgeMessage(usersWithAge))
 }
 
 func (c *UsersController) GetAgeStatsAction(ctx *gin.Context) {
-	ageStats, ageStatsErr := c.DBClient.GetAgeStats(ctx)
+	grants, ok := c.grants(ctx)
+	if !ok {
+		return
+	} else if !grants.Can(auth.PermissionStatsRead) {
+		forbidden(ctx)
+		return
+	}
+	ageStats, ageStatsErr := c.DBClient.GetAgeStats(ctx.Request.Context())
 	if ageStatsErr != nil {
-		c.logger.Printf("Error retrieving age stats - %s\n", ageStatsErr)
-		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage("something went wrong"))
+		c.log(ctx).Error("Error retrieving age stats", slog.Any("error", ageStatsErr))
+		ctx.JSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		return
 	}
 	ctx.JSON(http.StatusOK, api.NewAgeStatsMessage(*ageStats))
 }
+
+// redactUsers blanks the personal fields of every user grants may not see them for.
+func redactUsers(grants *auth.Grants, users iter.Seq2[models.User, error]) iter.Seq2[models.User, error] {
+	return func(yield func(models.User, error) bool) {
+		for user, err := range users {
+			if err == nil && !grants.CanViewPII(user.Id) {
+				user.Redact()
+			}
+			if !yield(user, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// apiKeyTouchInterval limits how often last_used_at is written for a busy key.
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is a stored API key. Only the hash of the key is kept. Times are unix seconds.
+type APIKey struct {
+	Id         int64  `db:"id" json:"id"`
+	Name       string `db:"name" json:"name"`
+	Prefix     string `db:"prefix" json:"prefix"`
+	KeyHash    string `db:"key_hash" json:"-"`
+	Scopes     string `db:"scopes" json:"scopes"`
+	CreatedAt  int64  `db:"created_at" json:"created_at"`
+	LastUsedAt *int64 `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  *int64 `db:"revoked_at" json:"revoked_at"`
+	Role       string `db:"role" json:"role"`
+	// UserId is the user the key acts as, for roles limited to their own record.
+	UserId *int64 `db:"user_id" json:"user_id"`
+	// TenantId is the organization the key is bound to, nil for platform keys that pick a
+	// tenant per request.
+	TenantId *int64 `db:"tenant_id" json:"tenant_id"`
+}
+
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// TouchDue reports whether last_used_at is older than apiKeyTouchInterval, so TouchAPIKey is
+// only called when it would write.
+func (k *APIKey) TouchDue(now time.Time) bool {
+	return k.LastUsedAt == nil || *k.LastUsedAt <= now.Add(-apiKeyTouchInterval).Unix()
+}
+
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+type apiKeyStmts struct {
+	createStmt      *preparedStmt
+	getByPrefixStmt *preparedStmt
+	listStmt        *preparedStmt
+	revokeStmt      *preparedStmt
+	rotateStmt      *preparedStmt
+	touchStmt       *preparedStmt
+}
+
+func prepareAPIKeyStmts(p *preparer) (*apiKeyStmts, error) {
+	createSql := "insert into api_keys(name, prefix, key_hash, scopes, created_at, role, user_id, tenant_id) values (?, ?, ?, ?, ?, ?, ?, ?)"
+	createStmt, createStmtErr := p.prepare("api_keys_create", createSql)
+	if createStmtErr != nil {
+		return nil, createStmtErr
+	}
+	listSql := "select id, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at, role, user_id, tenant_id from api_keys"
+	listStmt, listStmtErr := p.prepare("api_keys_list", listSql)
+	if listStmtErr != nil {
+		return nil, listStmtErr
+	}
+	getByPrefixStmt, getByPrefixStmtErr := p.prepare("api_keys_get_by_prefix", listSql+" where prefix = ?")
+	if getByPrefixStmtErr != nil {
+		return nil, getByPrefixStmtErr
+	}
+	revokeSql := "update api_keys set revoked_at = ? where id = ? and revoked_at is null"
+	revokeStmt, revokeStmtErr := p.prepare("api_keys_revoke", revokeSql)
+	if revokeStmtErr != nil {
+		return nil, revokeStmtErr
+	}
+	rotateSql := "update api_keys set prefix = ?, key_hash = ? where id = ? and revoked_at is null"
+	rotateStmt, rotateStmtErr := p.prepare("api_keys_rotate", rotateSql)
+	if rotateStmtErr != nil {
+		return nil, rotateStmtErr
+	}
+	touchSql := "update api_keys set last_used_at = ? where id = ? and (last_used_at is null or last_used_at <= ?)"
+	touchStmt, touchStmtErr := p.prepare("api_keys_touch", touchSql)
+	if touchStmtErr != nil {
+		return nil, touchStmtErr
+	}
+	return &apiKeyStmts{
+		createStmt:      createStmt,
+		getByPrefixStmt: getByPrefixStmt,
+		listStmt:        listStmt,
+		revokeStmt:      revokeStmt,
+		rotateStmt:      rotateStmt,
+		touchStmt:       touchStmt,
+	}, nil
+}
+
+func (s *apiKeyStmts) Close() error {
+	for _, stmt := range []*preparedStmt{s.createStmt, s.getByPrefixStmt, s.listStmt, s.revokeStmt, s.rotateStmt, s.touchStmt} {
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey stores a new key. scopes is stored space separated, userId and tenantId may be nil.
+func (db *Client) CreateAPIKey(ctx context.Context, name, prefix, keyHash string, scopes []string, role string, userId, tenantId *int64) (sql.Result, error) {
+	return db.apiKeys.createStmt.ExecContext(ctx, name, prefix, keyHash, strings.Join(scopes, " "), time.Now().Unix(), role, userId, tenantId)
+}
+
+func (db *Client) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	var apiKey APIKey
+	err := db.apiKeys.getByPrefixStmt.GetContext(ctx, &apiKey, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (db *Client) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var apiKeys []APIKey
+	err := db.apiKeys.listStmt.SelectContext(ctx, &apiKeys)
+	if err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (db *Client) RevokeAPIKey(ctx context.Context, id int64) (sql.Result, error) {
+	return db.apiKeys.revokeStmt.ExecContext(ctx, time.Now().Unix(), id)
+}
+
+// RotateAPIKey replaces the secret of a live key, keeping its id, name and scopes. The old
+// secret stops working immediately.
+func (db *Client) RotateAPIKey(ctx context.Context, id int64, prefix, keyHash string) (sql.Result, error) {
+	return db.apiKeys.rotateStmt.ExecContext(ctx, prefix, keyHash, id)
+}
+
+// TouchAPIKey records that the key was just used, at most once per apiKeyTouchInterval.
+func (db *Client) TouchAPIKey(ctx context.Context, id int64) (sql.Result, error) {
+	now := time.Now()
+	return db.apiKeys.touchStmt.ExecContext(ctx, now.Unix(), id, now.Add(-apiKeyTouchInterval).Unix())
+}
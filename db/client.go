@@ -3,7 +3,9 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
 	"time"
 
 	"github.com/brandonrachal/gin-and-tonic/models"
@@ -14,64 +16,108 @@ import (
 
 type Client struct {
 	DbConn              *sqlx.DB
-	createUserStmt      *sqlx.Stmt
-	getUserStmt         *sqlx.Stmt
-	getFirstUserStmt    *sqlx.Stmt
-	getUsersStmt        *sqlx.Stmt
-	getUsersWithAgeStmt *sqlx.Stmt
-	getAgeStatsStmt     *sqlx.Stmt
-	updateUserStmt      *sqlx.Stmt
-	deleteUserStmt      *sqlx.Stmt
-	deleteAllUsersStmt  *sqlx.Stmt
+	createUserStmt      *preparedStmt
+	getUserStmt         *preparedStmt
+	getFirstUserStmt    *preparedStmt
+	getUsersStmt        *preparedStmt
+	getUsersWithAgeStmt *preparedStmt
+	getAgeStatsStmt     *preparedStmt
+	countAllUsersStmt   *preparedStmt
+	updateUserStmt      *preparedStmt
+	deleteUserStmt      *preparedStmt
+	deleteAllUsersStmt  *preparedStmt
+	getUserByEmailStmt  *preparedStmt
+	upsertUserStmt      *preparedStmt
+	insertUserSkipStmt  *preparedStmt
+	idempotency         *idempotencyStmts
+	apiKeys             *apiKeyStmts
+	roles               *roleStmts
+	organizations       *organizationStmts
+	rateLimits          *rateLimitStmts
+	clientCertificates  *clientCertificateStmts
+	observers           *queryObservers
 }
 
+// NewClient opens the database and prepares every statement, which fails when the schema is
+// missing migrations.
 func NewClient(dataSourceName string) (*Client, error) {
+	return newClient(dataSourceName, false)
+}
+
+// NewLazyClient prepares each statement on its first use instead, so a client on a schema
+// missing migrations opens and only the statements that need them fail.
+func NewLazyClient(dataSourceName string) (*Client, error) {
+	return newClient(dataSourceName, true)
+}
+
+func newClient(dataSourceName string, lazy bool) (*Client, error) {
 	dbConn, dbConnErr := dbutils.NewSQLiteDBConn(dataSourceName)
 	if dbConnErr != nil {
 		return nil, dbConnErr
 	}
-	createUserSql := "insert into users(first_name, last_name, email, birthday) values (?, ?, ?, ?)"
-	createUserStmt, createUserStmtErr := dbConn.Preparex(createUserSql)
+	observers := &queryObservers{}
+	p := &preparer{dbConn: dbConn, observers: observers, lazy: lazy}
+	createUserSql := "insert into users(tenant_id, first_name, last_name, email, birthday) values (?, ?, ?, ?, ?)"
+	createUserStmt, createUserStmtErr := p.prepare("create_user", createUserSql)
 	if createUserStmtErr != nil {
 		return nil, createUserStmtErr
 	}
 
-	getUsersSql := `select id, first_name, last_name, email, birthday from users`
-	getUsersStmt, getUsersStmtErr := dbConn.Preparex(getUsersSql)
+	getUsersSql := `select id, first_name, last_name, email, birthday from users where tenant_id = ?`
+	getUsersStmt, getUsersStmtErr := p.prepare("get_users", getUsersSql)
 	if getUsersStmtErr != nil {
 		return nil, getUsersStmtErr
 	}
 
-	getUserSql := fmt.Sprintf("%s where id = ?", getUsersSql)
-	getUserStmt, getUserStmtErr := dbConn.Preparex(getUserSql)
+	getUserSql := fmt.Sprintf("%s and id = ?", getUsersSql)
+	getUserStmt, getUserStmtErr := p.prepare("get_user", getUserSql)
 	if getUserStmtErr != nil {
 		return nil, getUserStmtErr
 	}
 
+	getUserByEmailSql := fmt.Sprintf("%s and email = ?", getUsersSql)
+	getUserByEmailStmt, getUserByEmailStmtErr := p.prepare("get_user_by_email", getUserByEmailSql)
+	if getUserByEmailStmtErr != nil {
+		return nil, getUserByEmailStmtErr
+	}
+
 	getFirstUserSql := fmt.Sprintf("%s limit 1", getUsersSql)
-	getFirstUserStmt, getFirstUserStmtErr := dbConn.Preparex(getFirstUserSql)
+	getFirstUserStmt, getFirstUserStmtErr := p.prepare("get_first_user", getFirstUserSql)
 	if getFirstUserStmtErr != nil {
 		return nil, getFirstUserStmtErr
 	}
 
-	deleteUserSql := "delete from users where id = ?"
-	deleteUserStmt, deleteUserStmtErr := dbConn.Preparex(deleteUserSql)
+	deleteUserSql := "delete from users where tenant_id = ? and id = ?"
+	deleteUserStmt, deleteUserStmtErr := p.prepare("delete_user", deleteUserSql)
 	if deleteUserStmtErr != nil {
 		return nil, deleteUserStmtErr
 	}
-	deleteAllUsersSql := "delete from users"
-	deleteAllUsersStmt, deleteAllUsersStmtErr := dbConn.Preparex(deleteAllUsersSql)
+	deleteAllUsersSql := "delete from users where tenant_id = ?"
+	deleteAllUsersStmt, deleteAllUsersStmtErr := p.prepare("delete_all_users", deleteAllUsersSql)
 	if deleteAllUsersStmtErr != nil {
 		return nil, deleteAllUsersStmtErr
 	}
-	updateUserSql := "update users set first_name = ?, last_name = ?, email = ?, birthday = ? where id = ?"
-	updateUserStmt, updateUserStmtErr := dbConn.Preparex(updateUserSql)
+	updateUserSql := "update users set first_name = ?, last_name = ?, email = ?, birthday = ? where tenant_id = ? and id = ?"
+	updateUserStmt, updateUserStmtErr := p.prepare("update_user", updateUserSql)
 	if updateUserStmtErr != nil {
 		return nil, updateUserStmtErr
 	}
 
-	getUsersWithAgeSql := `select id, first_name, last_name, email, birthday, ROUND((JULIANDAY('now') - JULIANDAY(birthday)) / 365.25) as age_in_years from users;`
-	getUsersWithAgeStmt, getUsersWithAgeStmtErr := dbConn.Preparex(getUsersWithAgeSql)
+	upsertUserSql := `insert into users(tenant_id, first_name, last_name, email, birthday) values (?, ?, ?, ?, ?)
+		on conflict(tenant_id, email) do update set first_name = excluded.first_name, last_name = excluded.last_name, birthday = excluded.birthday
+		returning id`
+	upsertUserStmt, upsertUserStmtErr := p.prepare("upsert_user", upsertUserSql)
+	if upsertUserStmtErr != nil {
+		return nil, upsertUserStmtErr
+	}
+	insertUserSkipSql := fmt.Sprintf("%s on conflict(tenant_id, email) do nothing", createUserSql)
+	insertUserSkipStmt, insertUserSkipStmtErr := p.prepare("insert_user_skip", insertUserSkipSql)
+	if insertUserSkipStmtErr != nil {
+		return nil, insertUserSkipStmtErr
+	}
+
+	getUsersWithAgeSql := `select id, first_name, last_name, email, birthday, ROUND((JULIANDAY('now') - JULIANDAY(birthday)) / 365.25) as age_in_years from users where tenant_id = ?;`
+	getUsersWithAgeStmt, getUsersWithAgeStmtErr := p.prepare("get_users_with_age", getUsersWithAgeSql)
 	if getUsersWithAgeStmtErr != nil {
 		return nil, getUsersWithAgeStmtErr
 	}
@@ -89,60 +135,125 @@ func NewClient(dataSourceName string) (*Client, error) {
 		count(case when ROUND((JULIANDAY('now') - JULIANDAY(birthday)) / 365.25) > 89 and ROUND((JULIANDAY('now') - JULIANDAY(birthday)) / 365.25) < 100 then 1 end) as nineties,
 		count(case when ROUND((JULIANDAY('now') - JULIANDAY(birthday)) / 365.25) > 99 then 1 end) as centurion
 	from
-		users;`
-	getAgeStatsStmt, getAgeStatsStmtErr := dbConn.Preparex(getAgeStatsSql)
+		users
+	where
+		tenant_id = ?;`
+	getAgeStatsStmt, getAgeStatsStmtErr := p.prepare("get_age_stats", getAgeStatsSql)
 	if getAgeStatsStmtErr != nil {
 		return nil, getAgeStatsStmtErr
 	}
 
+	countAllUsersSql := "select count(*) from users"
+	countAllUsersStmt, countAllUsersStmtErr := p.prepare("count_all_users", countAllUsersSql)
+	if countAllUsersStmtErr != nil {
+		return nil, countAllUsersStmtErr
+	}
+
+	idempotency, idempotencyErr := prepareIdempotencyStmts(p)
+	if idempotencyErr != nil {
+		return nil, idempotencyErr
+	}
+
+	apiKeys, apiKeysErr := prepareAPIKeyStmts(p)
+	if apiKeysErr != nil {
+		return nil, apiKeysErr
+	}
+
+	roles, rolesErr := prepareRoleStmts(p)
+	if rolesErr != nil {
+		return nil, rolesErr
+	}
+
+	organizations, organizationsErr := prepareOrganizationStmts(p)
+	if organizationsErr != nil {
+		return nil, organizationsErr
+	}
+
+	rateLimits, rateLimitsErr := prepareRateLimitStmts(p)
+	if rateLimitsErr != nil {
+		return nil, rateLimitsErr
+	}
+
+	clientCertificates, clientCertificatesErr := prepareClientCertificateStmts(p)
+	if clientCertificatesErr != nil {
+		return nil, clientCertificatesErr
+	}
+
 	return &Client{
 		DbConn:              dbConn,
+		observers:           observers,
 		createUserStmt:      createUserStmt,
 		getUserStmt:         getUserStmt,
 		getFirstUserStmt:    getFirstUserStmt,
 		getUsersStmt:        getUsersStmt,
 		getUsersWithAgeStmt: getUsersWithAgeStmt,
 		getAgeStatsStmt:     getAgeStatsStmt,
+		countAllUsersStmt:   countAllUsersStmt,
 		updateUserStmt:      updateUserStmt,
 		deleteUserStmt:      deleteUserStmt,
 		deleteAllUsersStmt:  deleteAllUsersStmt,
+		getUserByEmailStmt:  getUserByEmailStmt,
+		upsertUserStmt:      upsertUserStmt,
+		insertUserSkipStmt:  insertUserSkipStmt,
+		idempotency:         idempotency,
+		apiKeys:             apiKeys,
+		roles:               roles,
+		organizations:       organizations,
+		rateLimits:          rateLimits,
+		clientCertificates:  clientCertificates,
 	}, nil
 }
 
 func (db *Client) CreateUser(ctx context.Context, firstName, lastName, email string, birthday time.Time) (sql.Result, error) {
-	return db.createUserStmt.ExecContext(ctx, firstName, lastName, email, birthday)
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
+	return db.createUserStmt.ExecContext(ctx, tenantId, firstName, lastName, email, birthday)
 }
 
 func (db *Client) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
 	var user models.User
-	err := db.getUserStmt.GetContext(ctx, &user, id)
+	err := db.getUserStmt.GetContext(ctx, &user, tenantId, id)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (db *Client) GetFirstUser(ctx context.Context) (*models.User, error) {
+func (db *Client) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
 	var user models.User
-	err := db.getFirstUserStmt.GetContext(ctx, &user)
+	err := db.getUserByEmailStmt.GetContext(ctx, &user, tenantId, email)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (db *Client) GetUsers(ctx context.Context) ([]models.User, error) {
-	var users []models.User
-	rows, err := db.getUsersStmt.QueryContext(ctx)
+func (db *Client) GetFirstUser(ctx context.Context) (*models.User, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
+	var user models.User
+	err := db.getFirstUserStmt.GetContext(ctx, &user, tenantId)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		_ = rows.Close()
-	}()
-	for rows.Next() {
-		var user models.User
-		err = rows.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Birthday)
+	return &user, nil
+}
+
+func (db *Client) GetUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for user, err := range db.IterUsers(ctx) {
 		if err != nil {
 			return nil, err
 		}
@@ -151,21 +262,114 @@ func (db *Client) GetUsers(ctx context.Context) ([]models.User, error) {
 	return users, nil
 }
 
+// IterUsers yields users one row at a time as they are scanned, so callers can stream the
+// whole table without holding it in memory. Iteration stops at the first error, which is
+// yielded with a zero user. Cancelling ctx aborts the underlying query.
+func (db *Client) IterUsers(ctx context.Context) iter.Seq2[models.User, error] {
+	return func(yield func(models.User, error) bool) {
+		tenantId, tenantErr := requireTenant(ctx)
+		if tenantErr != nil {
+			yield(models.User{}, tenantErr)
+			return
+		}
+		rows, err := db.getUsersStmt.QueryContext(ctx, tenantId)
+		if err != nil {
+			yield(models.User{}, err)
+			return
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		for rows.Next() {
+			var user models.User
+			err = rows.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Birthday)
+			if err != nil {
+				yield(models.User{}, err)
+				return
+			}
+			if !yield(user, nil) {
+				return
+			}
+		}
+		if err = rows.Err(); err != nil {
+			yield(models.User{}, err)
+		}
+	}
+}
+
 func (db *Client) UpdateUser(ctx context.Context, id int64, firstName, lastName, email string, birthday time.Time) (sql.Result, error) {
-	return db.updateUserStmt.ExecContext(ctx, firstName, lastName, email, birthday, id)
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
+	return db.updateUserStmt.ExecContext(ctx, firstName, lastName, email, birthday, tenantId, id)
+}
+
+// UpsertUserByEmail creates the user, or updates the names and birthday of the user that
+// already has the email. It returns the user's id and whether a new row was created.
+func (db *Client) UpsertUserByEmail(ctx context.Context, firstName, lastName, email string, birthday time.Time) (int64, bool, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return 0, false, tenantErr
+	}
+	tx, txErr := db.DbConn.BeginTxx(ctx, nil)
+	if txErr != nil {
+		return 0, false, txErr
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	getUserByEmailStmt := db.getUserByEmailStmt.inTx(ctx, tx)
+	upsertUserStmt := db.upsertUserStmt.inTx(ctx, tx)
+	id, created, upsertErr := upsertUser(ctx, getUserByEmailStmt, upsertUserStmt, tenantId, firstName, lastName, email, birthday)
+	if upsertErr != nil {
+		return 0, false, upsertErr
+	}
+	if commitErr := tx.Commit(); commitErr != nil {
+		return 0, false, commitErr
+	}
+	return id, created, nil
+}
+
+// upsertUser runs the upsert with transaction bound statements. The existence check and the
+// upsert have to share a transaction for created to be accurate.
+func upsertUser(ctx context.Context, getUserByEmailStmt, upsertUserStmt *preparedStmt, tenantId int64, firstName, lastName, email string, birthday time.Time) (int64, bool, error) {
+	var existing models.User
+	existingErr := getUserByEmailStmt.GetContext(ctx, &existing, tenantId, email)
+	if existingErr != nil && !errors.Is(existingErr, sql.ErrNoRows) {
+		return 0, false, existingErr
+	}
+	var id int64
+	upsertErr := upsertUserStmt.GetContext(ctx, &id, tenantId, firstName, lastName, email, birthday)
+	if upsertErr != nil {
+		return 0, false, upsertErr
+	}
+	return id, existingErr != nil, nil
 }
 
 func (db *Client) DeleteUser(ctx context.Context, id int64) (sql.Result, error) {
-	return db.deleteUserStmt.ExecContext(ctx, id)
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
+	return db.deleteUserStmt.ExecContext(ctx, tenantId, id)
 }
 
 func (db *Client) DeleteAllUsers(ctx context.Context) (sql.Result, error) {
-	return db.deleteAllUsersStmt.ExecContext(ctx)
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
+	return db.deleteAllUsersStmt.ExecContext(ctx, tenantId)
 }
 
 func (db *Client) GetUsersWithAge(ctx context.Context) ([]models.UserWithAge, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
 	var users []models.UserWithAge
-	rows, err := db.getUsersWithAgeStmt.QueryContext(ctx)
+	rows, err := db.getUsersWithAgeStmt.QueryContext(ctx, tenantId)
 	if err != nil {
 		return nil, err
 	}
@@ -184,14 +388,30 @@ func (db *Client) GetUsersWithAge(ctx context.Context) ([]models.UserWithAge, er
 }
 
 func (db *Client) GetAgeStats(ctx context.Context) (*models.AgeStats, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
 	var ageStats models.AgeStats
-	err := db.getAgeStatsStmt.GetContext(ctx, &ageStats)
+	err := db.getAgeStatsStmt.GetContext(ctx, &ageStats, tenantId)
 	if err != nil {
 		return nil, err
 	}
 	return &ageStats, nil
 }
 
+// CountAllUsers counts the users of every tenant, for operational metrics.
+func (db *Client) CountAllUsers(ctx context.Context) (int64, error) {
+	var count int64
+	err := db.countAllUsersStmt.GetContext(ctx, &count)
+	return count, err
+}
+
+// Ping checks the database connection is usable.
+func (db *Client) Ping(ctx context.Context) error {
+	return db.DbConn.PingContext(ctx)
+}
+
 func (db *Client) Close() error {
 	var err error
 	err = db.createUserStmt.Close()
@@ -214,6 +434,46 @@ func (db *Client) Close() error {
 	if err != nil {
 		return err
 	}
+	err = db.getUserByEmailStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = db.upsertUserStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = db.insertUserSkipStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = db.countAllUsersStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = db.idempotency.Close()
+	if err != nil {
+		return err
+	}
+	err = db.apiKeys.Close()
+	if err != nil {
+		return err
+	}
+	err = db.roles.Close()
+	if err != nil {
+		return err
+	}
+	err = db.organizations.Close()
+	if err != nil {
+		return err
+	}
+	err = db.rateLimits.Close()
+	if err != nil {
+		return err
+	}
+	err = db.clientCertificates.Close()
+	if err != nil {
+		return err
+	}
 	err = db.DbConn.Close()
 	if err != nil {
 		return err
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ClientCertificate maps the subject of a client certificate, as written by
+// pkix.Name.String() (e.g. "CN=billing,O=Acme"), to the identity its holder gets over mutual
+// TLS. Times are unix seconds.
+type ClientCertificate struct {
+	Id        int64  `db:"id" json:"id"`
+	Subject   string `db:"subject" json:"subject"`
+	Scopes    string `db:"scopes" json:"scopes"`
+	Role      string `db:"role" json:"role"`
+	UserId    *int64 `db:"user_id" json:"user_id"`
+	TenantId  *int64 `db:"tenant_id" json:"tenant_id"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
+	RevokedAt *int64 `db:"revoked_at" json:"revoked_at"`
+}
+
+func (c *ClientCertificate) Revoked() bool {
+	return c.RevokedAt != nil
+}
+
+func (c *ClientCertificate) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+type clientCertificateStmts struct {
+	createStmt       *preparedStmt
+	getBySubjectStmt *preparedStmt
+	listStmt         *preparedStmt
+	revokeStmt       *preparedStmt
+}
+
+func prepareClientCertificateStmts(p *preparer) (*clientCertificateStmts, error) {
+	createSql := "insert into client_certificates(subject, scopes, role, user_id, tenant_id, created_at) values (?, ?, ?, ?, ?, ?)"
+	createStmt, createStmtErr := p.prepare("client_certificates_create", createSql)
+	if createStmtErr != nil {
+		return nil, createStmtErr
+	}
+	listSql := "select id, subject, scopes, role, user_id, tenant_id, created_at, revoked_at from client_certificates"
+	listStmt, listStmtErr := p.prepare("client_certificates_list", listSql)
+	if listStmtErr != nil {
+		return nil, listStmtErr
+	}
+	getBySubjectStmt, getBySubjectStmtErr := p.prepare("client_certificates_get_by_subject", listSql+" where subject = ?")
+	if getBySubjectStmtErr != nil {
+		return nil, getBySubjectStmtErr
+	}
+	revokeSql := "update client_certificates set revoked_at = ? where id = ? and revoked_at is null"
+	revokeStmt, revokeStmtErr := p.prepare("client_certificates_revoke", revokeSql)
+	if revokeStmtErr != nil {
+		return nil, revokeStmtErr
+	}
+	return &clientCertificateStmts{
+		createStmt:       createStmt,
+		getBySubjectStmt: getBySubjectStmt,
+		listStmt:         listStmt,
+		revokeStmt:       revokeStmt,
+	}, nil
+}
+
+func (s *clientCertificateStmts) Close() error {
+	for _, stmt := range []*preparedStmt{s.createStmt, s.getBySubjectStmt, s.listStmt, s.revokeStmt} {
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateClientCertificate maps subject to an identity. scopes is stored space separated,
+// userId and tenantId may be nil.
+func (db *Client) CreateClientCertificate(ctx context.Context, subject string, scopes []string, role string, userId, tenantId *int64) (sql.Result, error) {
+	return db.clientCertificates.createStmt.ExecContext(ctx, subject, strings.Join(scopes, " "), role, userId, tenantId, time.Now().Unix())
+}
+
+func (db *Client) GetClientCertificateBySubject(ctx context.Context, subject string) (*ClientCertificate, error) {
+	var clientCertificate ClientCertificate
+	err := db.clientCertificates.getBySubjectStmt.GetContext(ctx, &clientCertificate, subject)
+	if err != nil {
+		return nil, err
+	}
+	return &clientCertificate, nil
+}
+
+func (db *Client) GetClientCertificates(ctx context.Context) ([]ClientCertificate, error) {
+	var clientCertificates []ClientCertificate
+	err := db.clientCertificates.listStmt.SelectContext(ctx, &clientCertificates)
+	if err != nil {
+		return nil, err
+	}
+	return clientCertificates, nil
+}
+
+func (db *Client) RevokeClientCertificate(ctx context.Context, id int64) (sql.Result, error) {
+	return db.clientCertificates.revokeStmt.ExecContext(ctx, time.Now().Unix(), id)
+}
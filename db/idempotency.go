@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+)
+
+// IdempotencyRecord is a stored Idempotency-Key. StatusCode is nil while the first request
+// using the key is still in flight. RequestId is the X-Request-ID of that request.
+type IdempotencyRecord struct {
+	Key          string  `db:"idempotency_key"`
+	Fingerprint  string  `db:"fingerprint"`
+	RequestId    *string `db:"request_id"`
+	StatusCode   *int    `db:"status_code"`
+	ContentType  *string `db:"content_type"`
+	ResponseBody []byte  `db:"response_body"`
+	CreatedAt    int64   `db:"created_at"`
+	ExpiresAt    int64   `db:"expires_at"`
+}
+
+func (r *IdempotencyRecord) InFlight() bool {
+	return r.StatusCode == nil
+}
+
+type idempotencyStmts struct {
+	deleteExpiredStmt *preparedStmt
+	reserveStmt       *preparedStmt
+	getStmt           *preparedStmt
+	completeStmt      *preparedStmt
+	releaseStmt       *preparedStmt
+}
+
+func prepareIdempotencyStmts(p *preparer) (*idempotencyStmts, error) {
+	deleteExpiredSql := "delete from idempotency_keys where expires_at <= ?"
+	deleteExpiredStmt, deleteExpiredStmtErr := p.prepare("idempotency_delete_expired", deleteExpiredSql)
+	if deleteExpiredStmtErr != nil {
+		return nil, deleteExpiredStmtErr
+	}
+	reserveSql := `insert into idempotency_keys(idempotency_key, fingerprint, request_id, created_at, expires_at) values (?, ?, ?, ?, ?)
+		on conflict(idempotency_key) do nothing`
+	reserveStmt, reserveStmtErr := p.prepare("idempotency_reserve", reserveSql)
+	if reserveStmtErr != nil {
+		return nil, reserveStmtErr
+	}
+	getSql := `select idempotency_key, fingerprint, request_id, status_code, content_type, response_body, created_at, expires_at
+		from idempotency_keys where idempotency_key = ?`
+	getStmt, getStmtErr := p.prepare("idempotency_get", getSql)
+	if getStmtErr != nil {
+		return nil, getStmtErr
+	}
+	completeSql := "update idempotency_keys set status_code = ?, content_type = ?, response_body = ? where idempotency_key = ?"
+	completeStmt, completeStmtErr := p.prepare("idempotency_complete", completeSql)
+	if completeStmtErr != nil {
+		return nil, completeStmtErr
+	}
+	releaseSql := "delete from idempotency_keys where idempotency_key = ?"
+	releaseStmt, releaseStmtErr := p.prepare("idempotency_release", releaseSql)
+	if releaseStmtErr != nil {
+		return nil, releaseStmtErr
+	}
+	return &idempotencyStmts{
+		deleteExpiredStmt: deleteExpiredStmt,
+		reserveStmt:       reserveStmt,
+		getStmt:           getStmt,
+		completeStmt:      completeStmt,
+		releaseStmt:       releaseStmt,
+	}, nil
+}
+
+func (s *idempotencyStmts) Close() error {
+	for _, stmt := range []*preparedStmt{s.deleteExpiredStmt, s.reserveStmt, s.getStmt, s.completeStmt, s.releaseStmt} {
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey claims key for a new request. When the key is free it is stored as in
+// flight and reserved is true. Otherwise the existing record is returned so the caller can
+// replay it or reject the request. Expired keys are purged first. The request id on ctx, if
+// any, is stored with the key.
+func (db *Client) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+	if _, deleteErr := db.idempotency.deleteExpiredStmt.ExecContext(ctx, now.Unix()); deleteErr != nil {
+		return nil, false, deleteErr
+	}
+	var requestId *string
+	if id, ok := requestid.FromContext(ctx); ok {
+		requestId = &id
+	}
+	result, reserveErr := db.idempotency.reserveStmt.ExecContext(ctx, key, fingerprint, requestId, now.Unix(), now.Add(ttl).Unix())
+	if reserveErr != nil {
+		return nil, false, reserveErr
+	}
+	rowsAffected, rowsAffectedErr := result.RowsAffected()
+	if rowsAffectedErr != nil {
+		return nil, false, rowsAffectedErr
+	}
+	var record IdempotencyRecord
+	if getErr := db.idempotency.getStmt.GetContext(ctx, &record, key); getErr != nil {
+		return nil, false, getErr
+	}
+	return &record, rowsAffected == 1, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request holding key so retries can replay it.
+func (db *Client) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, contentType string, body []byte) (sql.Result, error) {
+	return db.idempotency.completeStmt.ExecContext(ctx, statusCode, contentType, body, key)
+}
+
+// ReleaseIdempotencyKey forgets key, letting the next request with it run from scratch.
+func (db *Client) ReleaseIdempotencyKey(ctx context.Context, key string) (sql.Result, error) {
+	return db.idempotency.releaseStmt.ExecContext(ctx, key)
+}
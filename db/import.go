@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/brandonrachal/gin-and-tonic/models"
+)
+
+// ImportMode decides what ImportUsers does with a row whose email already exists.
+type ImportMode int
+
+const (
+	// ImportInsert fails rows whose email already exists.
+	ImportInsert ImportMode = iota
+	// ImportUpsert overwrites the existing user with the imported row.
+	ImportUpsert
+	// ImportSkipDuplicates leaves the existing user alone and skips the row.
+	ImportSkipDuplicates
+)
+
+type ImportOutcome int
+
+const (
+	ImportCreated ImportOutcome = iota
+	ImportUpdated
+	ImportSkipped
+	ImportFailed
+	// ImportRolledBack is a row that would have been created or updated if another row of its
+	// batch hadn't failed.
+	ImportRolledBack
+)
+
+func (o ImportOutcome) String() string {
+	switch o {
+	case ImportCreated:
+		return "created"
+	case ImportUpdated:
+		return "updated"
+	case ImportSkipped:
+		return "skipped"
+	case ImportFailed:
+		return "failed"
+	case ImportRolledBack:
+		return "rolled back"
+	}
+	return "unknown"
+}
+
+// ImportResult is the outcome of one row passed to ImportUsers. Id is zero for skipped, failed
+// and rolled back rows.
+type ImportResult struct {
+	Id      int64
+	Outcome ImportOutcome
+	Err     error
+}
+
+// ImportUsers writes users into the context's tenant in a single transaction and returns one
+// result per user, in order. A row level failure such as a duplicate email in ImportInsert mode
+// is reported in the results and rolls the whole batch back, turning the rows that went in into
+// ImportRolledBack. With dryRun the transaction is always rolled back, so the results describe
+// what would have happened without changing anything.
+func (db *Client) ImportUsers(ctx context.Context, users []models.CreateUser, mode ImportMode, dryRun bool) ([]ImportResult, error) {
+	tenantId, tenantErr := requireTenant(ctx)
+	if tenantErr != nil {
+		return nil, tenantErr
+	}
+	tx, txErr := db.DbConn.BeginTxx(ctx, nil)
+	if txErr != nil {
+		return nil, txErr
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	createUserStmt := db.createUserStmt.inTx(ctx, tx)
+	getUserByEmailStmt := db.getUserByEmailStmt.inTx(ctx, tx)
+	upsertUserStmt := db.upsertUserStmt.inTx(ctx, tx)
+	insertUserSkipStmt := db.insertUserSkipStmt.inTx(ctx, tx)
+
+	results := make([]ImportResult, len(users))
+	for i, user := range users {
+		birthday := user.Birthday.ToTime()
+		switch mode {
+		case ImportUpsert:
+			id, created, upsertErr := upsertUser(ctx, getUserByEmailStmt, upsertUserStmt, tenantId, user.FirstName, user.LastName, user.Email, birthday)
+			if upsertErr != nil {
+				results[i] = ImportResult{Outcome: ImportFailed, Err: upsertErr}
+			} else if created {
+				results[i] = ImportResult{Id: id, Outcome: ImportCreated}
+			} else {
+				results[i] = ImportResult{Id: id, Outcome: ImportUpdated}
+			}
+		case ImportSkipDuplicates:
+			results[i] = insertResult(insertUserSkipStmt.ExecContext(ctx, tenantId, user.FirstName, user.LastName, user.Email, birthday))
+		default:
+			results[i] = insertResult(createUserStmt.ExecContext(ctx, tenantId, user.FirstName, user.LastName, user.Email, birthday))
+		}
+	}
+	if slices.ContainsFunc(results, func(result ImportResult) bool { return result.Outcome == ImportFailed }) {
+		for i, result := range results {
+			if result.Outcome == ImportCreated || result.Outcome == ImportUpdated {
+				results[i] = ImportResult{Outcome: ImportRolledBack}
+			}
+		}
+		return results, nil
+	}
+	if dryRun {
+		return results, nil
+	}
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+	return results, nil
+}
+
+func insertResult(result sql.Result, err error) ImportResult {
+	if err != nil {
+		return ImportResult{Outcome: ImportFailed, Err: err}
+	}
+	rowsAffected, rowsAffectedErr := result.RowsAffected()
+	if rowsAffectedErr != nil {
+		return ImportResult{Outcome: ImportFailed, Err: rowsAffectedErr}
+	} else if rowsAffected == 0 {
+		return ImportResult{Outcome: ImportSkipped}
+	}
+	id, idErr := result.LastInsertId()
+	if idErr != nil {
+		return ImportResult{Outcome: ImportFailed, Err: idErr}
+	}
+	return ImportResult{Id: id, Outcome: ImportCreated}
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// GetMigrationVersion returns the version of the latest migration goose has applied, reading
+// its log in table the way goose does, or 0 when none is applied.
+func (db *Client) GetMigrationVersion(ctx context.Context, table string) (int64, error) {
+	query := fmt.Sprintf("select version_id, is_applied from %s order by id desc", table)
+	rows, err := db.DbConn.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	// Rolling a migration back logs a row that isn't applied, which hides the older rows of
+	// that version.
+	var rolledBack []int64
+	for rows.Next() {
+		var version int64
+		var applied bool
+		if err = rows.Scan(&version, &applied); err != nil {
+			return 0, err
+		}
+		if slices.Contains(rolledBack, version) {
+			continue
+		}
+		if applied {
+			return version, nil
+		}
+		rolledBack = append(rolledBack, version)
+	}
+	return 0, rows.Err()
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// QueryObserver is told about every prepared statement Client runs, e.g. to record metrics
+// or tracing spans.
+type QueryObserver interface {
+	// ObserveQuery is called before the statement called name runs query. The statement runs
+	// with the returned context and done is called with its error once it has.
+	ObserveQuery(ctx context.Context, name, query string) (observeCtx context.Context, done func(error))
+}
+
+// AddQueryObserver makes observer see every statement the client runs from now on.
+func (db *Client) AddQueryObserver(observer QueryObserver) {
+	db.observers.mu.Lock()
+	defer db.observers.mu.Unlock()
+	db.observers.list = append(db.observers.list, observer)
+}
+
+type queryObservers struct {
+	mu   sync.RWMutex
+	list []QueryObserver
+}
+
+// observe runs the observers in the order they were added and returns a func that ends them
+// in reverse order.
+func (o *queryObservers) observe(ctx context.Context, name, query string) (context.Context, func(error)) {
+	o.mu.RLock()
+	observers := o.list
+	o.mu.RUnlock()
+	if len(observers) == 0 {
+		return ctx, func(error) {}
+	}
+	dones := make([]func(error), len(observers))
+	for i, observer := range observers {
+		ctx, dones[i] = observer.ObserveQuery(ctx, name, query)
+	}
+	return ctx, func(err error) {
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i](err)
+		}
+	}
+}
+
+type preparer struct {
+	dbConn    *sqlx.DB
+	observers *queryObservers
+	// lazy leaves preparing each statement to its first use.
+	lazy bool
+}
+
+// prepare prepares query as the statement called name, which is what observers see.
+func (p *preparer) prepare(name, query string) (*preparedStmt, error) {
+	s := &preparedStmt{dbConn: p.dbConn, name: name, query: query, observers: p.observers}
+	if p.lazy {
+		return s, nil
+	}
+	if _, err := s.get(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// preparedStmt is a *sqlx.Stmt that reports each run to the client's QueryObservers.
+type preparedStmt struct {
+	dbConn    *sqlx.DB
+	name      string
+	query     string
+	observers *queryObservers
+	stmt      atomic.Pointer[sqlx.Stmt]
+	prepareMu sync.Mutex
+	// err is why a statement bound to a transaction couldn't be prepared.
+	err error
+}
+
+// get returns the statement, preparing it first when that hasn't succeeded yet. Failures
+// aren't kept, so a lazy statement works once the migrations it needs are applied.
+func (s *preparedStmt) get() (*sqlx.Stmt, error) {
+	if stmt := s.stmt.Load(); stmt != nil {
+		return stmt, nil
+	} else if s.err != nil {
+		return nil, s.err
+	}
+	s.prepareMu.Lock()
+	defer s.prepareMu.Unlock()
+	if stmt := s.stmt.Load(); stmt != nil {
+		return stmt, nil
+	}
+	stmt, stmtErr := s.dbConn.Preparex(s.query)
+	if stmtErr != nil {
+		return nil, fmt.Errorf("preparing %s - %w", s.name, stmtErr)
+	}
+	s.stmt.Store(stmt)
+	return stmt, nil
+}
+
+// inTx returns the statement bound to tx.
+func (s *preparedStmt) inTx(ctx context.Context, tx *sqlx.Tx) *preparedStmt {
+	inTx := &preparedStmt{name: s.name, query: s.query, observers: s.observers}
+	stmt, stmtErr := s.get()
+	if stmtErr != nil {
+		inTx.err = stmtErr
+		return inTx
+	}
+	inTx.stmt.Store(tx.StmtxContext(ctx, stmt))
+	return inTx
+}
+
+// Close closes the statement if it was prepared.
+func (s *preparedStmt) Close() error {
+	if stmt := s.stmt.Load(); stmt != nil {
+		return stmt.Close()
+	}
+	return nil
+}
+
+func (s *preparedStmt) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
+	stmt, stmtErr := s.get()
+	if stmtErr != nil {
+		return nil, stmtErr
+	}
+	ctx, done := s.observers.observe(ctx, s.name, s.query)
+	result, err := stmt.ExecContext(ctx, args...)
+	done(err)
+	return result, err
+}
+
+func (s *preparedStmt) GetContext(ctx context.Context, dest any, args ...any) error {
+	stmt, stmtErr := s.get()
+	if stmtErr != nil {
+		return stmtErr
+	}
+	ctx, done := s.observers.observe(ctx, s.name, s.query)
+	err := stmt.GetContext(ctx, dest, args...)
+	done(err)
+	return err
+}
+
+func (s *preparedStmt) SelectContext(ctx context.Context, dest any, args ...any) error {
+	stmt, stmtErr := s.get()
+	if stmtErr != nil {
+		return stmtErr
+	}
+	ctx, done := s.observers.observe(ctx, s.name, s.query)
+	err := stmt.SelectContext(ctx, dest, args...)
+	done(err)
+	return err
+}
+
+// QueryContext only observes running the query, not reading the rows.
+func (s *preparedStmt) QueryContext(ctx context.Context, args ...any) (*sql.Rows, error) {
+	stmt, stmtErr := s.get()
+	if stmtErr != nil {
+		return nil, stmtErr
+	}
+	ctx, done := s.observers.observe(ctx, s.name, s.query)
+	rows, err := stmt.QueryContext(ctx, args...)
+	done(err)
+	return rows, err
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+type rateLimitStmts struct {
+	takeTokenStmt         *preparedStmt
+	getBucketStmt         *preparedStmt
+	incrementQuotaStmt    *preparedStmt
+	getQuotaStmt          *preparedStmt
+	deleteOldQuotasStmt   *preparedStmt
+	deleteIdleBucketsStmt *preparedStmt
+	// purgedDay is the day this process last purged the rows of earlier days.
+	purgedDay   string
+	purgedDayMu sync.Mutex
+}
+
+func prepareRateLimitStmts(p *preparer) (*rateLimitStmts, error) {
+	// Refilling and taking a token happen in one statement, so concurrent processes sharing the
+	// database file can't both take the last token. No row comes back when the bucket is empty.
+	takeTokenSql := `insert into rate_limit_buckets(bucket_key, tokens, updated_at) values (?1, ?2 - 1, ?4)
+		on conflict(bucket_key) do update set
+			tokens = min(?2, tokens + max(0, excluded.updated_at - updated_at) * ?3) - 1,
+			updated_at = max(updated_at, excluded.updated_at)
+		where min(?2, tokens + max(0, excluded.updated_at - updated_at) * ?3) >= 1
+		returning tokens`
+	takeTokenStmt, takeTokenStmtErr := p.prepare("take_token", takeTokenSql)
+	if takeTokenStmtErr != nil {
+		return nil, takeTokenStmtErr
+	}
+	getBucketSql := "select tokens, updated_at from rate_limit_buckets where bucket_key = ?"
+	getBucketStmt, getBucketStmtErr := p.prepare("get_bucket", getBucketSql)
+	if getBucketStmtErr != nil {
+		return nil, getBucketStmtErr
+	}
+	incrementQuotaSql := `insert into rate_limit_quotas(quota_key, day, used) values (?1, ?2, 1)
+		on conflict(quota_key, day) do update set used = used + 1 where used < ?3
+		returning used`
+	incrementQuotaStmt, incrementQuotaStmtErr := p.prepare("increment_quota", incrementQuotaSql)
+	if incrementQuotaStmtErr != nil {
+		return nil, incrementQuotaStmtErr
+	}
+	getQuotaSql := "select used from rate_limit_quotas where quota_key = ? and day = ?"
+	getQuotaStmt, getQuotaStmtErr := p.prepare("get_quota", getQuotaSql)
+	if getQuotaStmtErr != nil {
+		return nil, getQuotaStmtErr
+	}
+	deleteOldQuotasSql := "delete from rate_limit_quotas where day < ?"
+	deleteOldQuotasStmt, deleteOldQuotasStmtErr := p.prepare("delete_old_quotas", deleteOldQuotasSql)
+	if deleteOldQuotasStmtErr != nil {
+		return nil, deleteOldQuotasStmtErr
+	}
+	deleteIdleBucketsSql := "delete from rate_limit_buckets where updated_at < ?"
+	deleteIdleBucketsStmt, deleteIdleBucketsStmtErr := p.prepare("delete_idle_buckets", deleteIdleBucketsSql)
+	if deleteIdleBucketsStmtErr != nil {
+		return nil, deleteIdleBucketsStmtErr
+	}
+	return &rateLimitStmts{
+		takeTokenStmt:         takeTokenStmt,
+		getBucketStmt:         getBucketStmt,
+		incrementQuotaStmt:    incrementQuotaStmt,
+		getQuotaStmt:          getQuotaStmt,
+		deleteOldQuotasStmt:   deleteOldQuotasStmt,
+		deleteIdleBucketsStmt: deleteIdleBucketsStmt,
+	}, nil
+}
+
+func (s *rateLimitStmts) Close() error {
+	for _, stmt := range []*preparedStmt{s.takeTokenStmt, s.getBucketStmt, s.incrementQuotaStmt, s.getQuotaStmt, s.deleteOldQuotasStmt, s.deleteIdleBucketsStmt} {
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TakeToken takes one token from the token bucket key, which refills at rate tokens a second
+// up to burst. It returns the tokens left and whether one could be taken. The first call of a
+// new UTC day purges the rows of earlier days.
+func (db *Client) TakeToken(ctx context.Context, key string, rate float64, burst int, now time.Time) (float64, bool, error) {
+	if purgeErr := db.purgeRateLimits(ctx, now); purgeErr != nil {
+		return 0, false, purgeErr
+	}
+	nowSeconds := float64(now.UnixNano()) / float64(time.Second)
+	var tokens float64
+	takeErr := db.rateLimits.takeTokenStmt.GetContext(ctx, &tokens, key, float64(burst), rate, nowSeconds)
+	if takeErr == nil {
+		return tokens, true, nil
+	} else if !errors.Is(takeErr, sql.ErrNoRows) {
+		return 0, false, takeErr
+	}
+	var bucket struct {
+		Tokens    float64 `db:"tokens"`
+		UpdatedAt float64 `db:"updated_at"`
+	}
+	if getErr := db.rateLimits.getBucketStmt.GetContext(ctx, &bucket, key); getErr != nil {
+		return 0, false, getErr
+	}
+	refilled := bucket.Tokens + math.Max(0, nowSeconds-bucket.UpdatedAt)*rate
+	return math.Min(float64(burst), refilled), false, nil
+}
+
+// IncrementQuota counts one use of quota key on day unless quota uses were already counted.
+// It returns the uses so far and whether this one was counted.
+func (db *Client) IncrementQuota(ctx context.Context, key, day string, quota int) (int, bool, error) {
+	var used int
+	incrementErr := db.rateLimits.incrementQuotaStmt.GetContext(ctx, &used, key, day, quota)
+	if incrementErr == nil {
+		return used, true, nil
+	} else if !errors.Is(incrementErr, sql.ErrNoRows) {
+		return 0, false, incrementErr
+	}
+	if getErr := db.rateLimits.getQuotaStmt.GetContext(ctx, &used, key, day); getErr != nil {
+		return 0, false, getErr
+	}
+	return used, false, nil
+}
+
+// purgeRateLimits deletes the quota counters of the days before now, and the buckets nobody
+// took a token from for a day, which have refilled for any practical limit and start full
+// again when recreated. It runs once a day per process.
+func (db *Client) purgeRateLimits(ctx context.Context, now time.Time) error {
+	day := now.UTC().Format(time.DateOnly)
+	s := db.rateLimits
+	s.purgedDayMu.Lock()
+	defer s.purgedDayMu.Unlock()
+	if s.purgedDay == day {
+		return nil
+	}
+	if _, err := s.deleteOldQuotasStmt.ExecContext(ctx, day); err != nil {
+		return err
+	}
+	idleSince := float64(now.Add(-24*time.Hour).UnixNano()) / float64(time.Second)
+	if _, err := s.deleteIdleBucketsStmt.ExecContext(ctx, idleSince); err != nil {
+		return err
+	}
+	s.purgedDay = day
+	return nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import "context"
+
+type Role struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+type roleStmts struct {
+	getRoleStmt            *preparedStmt
+	getRolePermissionsStmt *preparedStmt
+}
+
+func prepareRoleStmts(p *preparer) (*roleStmts, error) {
+	getRoleSql := "select name, description from roles where name = ?"
+	getRoleStmt, getRoleStmtErr := p.prepare("get_role", getRoleSql)
+	if getRoleStmtErr != nil {
+		return nil, getRoleStmtErr
+	}
+	getRolePermissionsSql := "select permission from role_permissions where role = ? order by permission"
+	getRolePermissionsStmt, getRolePermissionsStmtErr := p.prepare("get_role_permissions", getRolePermissionsSql)
+	if getRolePermissionsStmtErr != nil {
+		return nil, getRolePermissionsStmtErr
+	}
+	return &roleStmts{
+		getRoleStmt:            getRoleStmt,
+		getRolePermissionsStmt: getRolePermissionsStmt,
+	}, nil
+}
+
+func (s *roleStmts) Close() error {
+	for _, stmt := range []*preparedStmt{s.getRoleStmt, s.getRolePermissionsStmt} {
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Client) GetRole(ctx context.Context, name string) (*Role, error) {
+	var role Role
+	err := db.roles.getRoleStmt.GetContext(ctx, &role, name)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetRolePermissions returns the permissions granted to role, empty for unknown roles.
+func (db *Client) GetRolePermissions(ctx context.Context, role string) ([]string, error) {
+	var permissions []string
+	err := db.roles.getRolePermissionsStmt.SelectContext(ctx, &permissions, role)
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DefaultTenantId is the organization seeded by the organizations migration. Every user that
+// existed before tenants were introduced belongs to it.
+const DefaultTenantId int64 = 1
+
+// ErrNoTenant is returned by every user query run without a tenant in its context.
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+// WithTenant scopes every user query run with the returned context to tenantId.
+func WithTenant(ctx context.Context, tenantId int64) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+func TenantFromContext(ctx context.Context) (int64, bool) {
+	tenantId, ok := ctx.Value(tenantKey{}).(int64)
+	return tenantId, ok && tenantId != 0
+}
+
+func requireTenant(ctx context.Context) (int64, error) {
+	tenantId, ok := TenantFromContext(ctx)
+	if !ok {
+		return 0, ErrNoTenant
+	}
+	return tenantId, nil
+}
+
+type Organization struct {
+	Id        int64  `db:"id" json:"id"`
+	Slug      string `db:"slug" json:"slug"`
+	Name      string `db:"name" json:"name"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
+}
+
+type organizationStmts struct {
+	createStmt    *preparedStmt
+	getBySlugStmt *preparedStmt
+}
+
+func prepareOrganizationStmts(p *preparer) (*organizationStmts, error) {
+	createSql := "insert into organizations(slug, name, created_at) values (?, ?, ?)"
+	createStmt, createStmtErr := p.prepare("organizations_create", createSql)
+	if createStmtErr != nil {
+		return nil, createStmtErr
+	}
+	getSql := "select id, slug, name, created_at from organizations"
+	getBySlugStmt, getBySlugStmtErr := p.prepare("organizations_get_by_slug", getSql+" where slug = ?")
+	if getBySlugStmtErr != nil {
+		return nil, getBySlugStmtErr
+	}
+	return &organizationStmts{
+		createStmt:    createStmt,
+		getBySlugStmt: getBySlugStmt,
+	}, nil
+}
+
+func (s *organizationStmts) Close() error {
+	for _, stmt := range []*preparedStmt{s.createStmt, s.getBySlugStmt} {
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Client) CreateOrganization(ctx context.Context, slug, name string) (sql.Result, error) {
+	return db.organizations.createStmt.ExecContext(ctx, slug, name, time.Now().Unix())
+}
+
+func (db *Client) GetOrganizationBySlug(ctx context.Context, slug string) (*Organization, error) {
+	var organization Organization
+	err := db.organizations.getBySlugStmt.GetContext(ctx, &organization, slug)
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
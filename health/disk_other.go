@@ -0,0 +1,7 @@
+//go:build !unix
+
+package health
+
+func diskSpace(string) (free, total uint64, err error) {
+	return 0, 0, errDiskSpaceUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package health
+
+import "syscall"
+
+func diskSpace(path string) (free, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	blockSize := uint64(stat.Bsize)
+	return stat.Bavail * blockSize, stat.Blocks * blockSize, nil
+}
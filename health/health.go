@@ -0,0 +1,189 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/migrations"
+)
+
+const (
+	StatusOk   = "ok"
+	StatusFail = "fail"
+	// StatusUnknown is reported by checks that can't run on this platform. It doesn't fail.
+	StatusUnknown = "unknown"
+
+	DefaultTimeout      = 2 * time.Second
+	DefaultMinFreeBytes = 100 << 20
+)
+
+// Store is the part of db.Client the checks use.
+type Store interface {
+	Ping(ctx context.Context) error
+	GetMigrationVersion(ctx context.Context, table string) (int64, error)
+}
+
+// Config configures a Checker.
+type Config struct {
+	// MigrationTable is goose's table, see internal.MigrationTable.
+	MigrationTable string
+	// Migrations holds the goose migration files.
+	Migrations fs.FS
+	// DataDir is the directory holding the database files.
+	DataDir string
+	// MinFreeBytes fails the disk check when the data directory has less space left.
+	MinFreeBytes uint64
+	// Timeout bounds each check.
+	Timeout time.Duration
+}
+
+// Check is the outcome of one check. Reports are served without credentials, so Error is a
+// fixed message and the underlying error is only kept in Err, for logs.
+type Check struct {
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+	Err      error   `json:"-"`
+	// Migrations
+	CurrentVersion *int64 `json:"current_version,omitempty"`
+	LatestVersion  *int64 `json:"latest_version,omitempty"`
+	// Disk
+	FreeBytes  *uint64 `json:"free_bytes,omitempty"`
+	TotalBytes *uint64 `json:"total_bytes,omitempty"`
+}
+
+// Report is the JSON body of /healthz and /readyz.
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+func (r *Report) Ok() bool {
+	return r.Status == StatusOk
+}
+
+// Checker runs the liveness and readiness checks.
+type Checker struct {
+	store        Store
+	config       Config
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(store Store, config Config) *Checker {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Checker{
+		store:  store,
+		config: config,
+	}
+}
+
+// ShutDown makes readiness fail so load balancers stop sending requests before the server
+// stops accepting them.
+func (c *Checker) ShutDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Live checks the database connection. Failing means the process can't serve anything, so it
+// leaves out what a restart wouldn't fix, like a full disk.
+func (c *Checker) Live(ctx context.Context) *Report {
+	return c.report(ctx, map[string]func(context.Context) Check{
+		"database": c.checkDatabase,
+	})
+}
+
+// Ready runs the liveness checks, checks disk space and that the schema is up to date, and fails
+// during shutdown.
+func (c *Checker) Ready(ctx context.Context) *Report {
+	report := c.report(ctx, map[string]func(context.Context) Check{
+		"database":   c.checkDatabase,
+		"disk":       c.checkDisk,
+		"migrations": c.checkMigrations,
+	})
+	shutdown := Check{Status: StatusOk}
+	if c.shuttingDown.Load() {
+		shutdown = Check{Status: StatusFail, Error: "shutting down"}
+		report.Status = StatusFail
+	}
+	report.Checks["shutdown"] = shutdown
+	return report
+}
+
+// report runs checks concurrently, each with the configured timeout.
+func (c *Checker) report(ctx context.Context, checks map[string]func(context.Context) Check) *Report {
+	type result struct {
+		name  string
+		check Check
+	}
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func() {
+			checkCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+			start := time.Now()
+			outcome := check(checkCtx)
+			// Done with ctx before the result can let report return
+			cancel()
+			outcome.Duration = float64(time.Since(start).Microseconds()) / 1000
+			results <- result{name: name, check: outcome}
+		}()
+	}
+	report := &Report{Status: StatusOk, Checks: make(map[string]Check, len(checks))}
+	for range checks {
+		r := <-results
+		report.Checks[r.name] = r.check
+		if r.check.Status == StatusFail {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (c *Checker) checkDatabase(ctx context.Context) Check {
+	if err := c.store.Ping(ctx); err != nil {
+		return failed("the database doesn't answer", err)
+	}
+	return Check{Status: StatusOk}
+}
+
+func (c *Checker) checkMigrations(ctx context.Context) Check {
+	latest, latestErr := migrations.LatestVersion(c.config.Migrations)
+	if latestErr != nil {
+		return failed("couldn't read the migrations", latestErr)
+	}
+	current, currentErr := c.store.GetMigrationVersion(ctx, c.config.MigrationTable)
+	if currentErr != nil {
+		return failed("couldn't read the migration version", currentErr)
+	}
+	check := Check{Status: StatusOk, CurrentVersion: &current, LatestVersion: &latest}
+	if current != latest {
+		check.Status = StatusFail
+		check.Error = fmt.Sprintf("database is at migration %d but the latest is %d", current, latest)
+	}
+	return check
+}
+
+func (c *Checker) checkDisk(context.Context) Check {
+	free, total, diskErr := diskSpace(c.config.DataDir)
+	if errors.Is(diskErr, errDiskSpaceUnsupported) {
+		return Check{Status: StatusUnknown}
+	} else if diskErr != nil {
+		return failed("couldn't read the free disk space", diskErr)
+	}
+	check := Check{Status: StatusOk, FreeBytes: &free, TotalBytes: &total}
+	if free < c.config.MinFreeBytes {
+		check.Status = StatusFail
+		check.Error = fmt.Sprintf("only %d bytes free, want at least %d", free, c.config.MinFreeBytes)
+	}
+	return check
+}
+
+func failed(message string, err error) Check {
+	return Check{Status: StatusFail, Error: message, Err: err}
+}
+
+var errDiskSpaceUnsupported = errors.New("disk space isn't supported on this platform")
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	pingErr error
+	version int64
+}
+
+func (s *fakeStore) Ping(context.Context) error {
+	return s.pingErr
+}
+
+func (s *fakeStore) GetMigrationVersion(context.Context, string) (int64, error) {
+	return s.version, nil
+}
+
+var testMigrations = fstest.MapFS{
+	"20251012000000_create_users_table.sql":  {},
+	"20261019140000_add_request_id.sql":      {},
+	"20261019130000_create_rate_limits.sql":  {},
+	"README.md":                              {},
+	"notes_20991231000000.sql":               {},
+	"backup/20991231000000_backup_table.sql": {},
+}
+
+func TestReady(t *testing.T) {
+	r := require.New(t)
+	store := &fakeStore{version: 20261019140000}
+	checker := NewChecker(store, Config{Migrations: testMigrations, DataDir: t.TempDir()})
+
+	report := checker.Ready(context.Background())
+	r.True(report.Ok(), "%+v", report)
+	r.Equal(StatusOk, report.Checks["migrations"].Status)
+
+	// A pending migration fails readiness but not liveness
+	store.version = 20261019130000
+	report = checker.Ready(context.Background())
+	r.False(report.Ok())
+	r.Equal(StatusFail, report.Checks["migrations"].Status)
+	r.Contains(report.Checks["migrations"].Error, "20261019130000")
+	r.True(checker.Live(context.Background()).Ok())
+
+	store.pingErr = errors.New("database is locked")
+	report = checker.Live(context.Background())
+	r.False(report.Ok())
+	r.Equal("the database doesn't answer", report.Checks["database"].Error)
+	r.EqualError(report.Checks["database"].Err, "database is locked")
+	r.NotContains(report.Checks, "disk")
+}
+
+func TestDiskSpace(t *testing.T) {
+	r := require.New(t)
+	checker := NewChecker(&fakeStore{}, Config{DataDir: t.TempDir(), MinFreeBytes: 1 << 62})
+	check := checker.checkDisk(context.Background())
+	if check.Status == StatusUnknown {
+		t.Skip("disk space isn't supported on this platform")
+	}
+	r.Equal(StatusFail, check.Status)
+	r.NotNil(check.FreeBytes)
+	r.NotZero(*check.TotalBytes)
+
+	checker = NewChecker(&fakeStore{}, Config{DataDir: "/no/such/dir"})
+	check = checker.checkDisk(context.Background())
+	r.Equal(StatusFail, check.Status)
+	r.NotContains(check.Error, "/no/such/dir")
+}
@@ -0,0 +1,61 @@
+package https
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP3Server serves a handler over HTTP/3 on a UDP socket.
+type HTTP3Server struct {
+	server *http3.Server
+	conn   net.PacketConn
+}
+
+// ListenHTTP3 binds the UDP address addr, e.g. ":443", to serve handler with tlsConfig once
+// Serve is called.
+func ListenHTTP3(addr string, handler http.Handler, tlsConfig *tls.Config) (*HTTP3Server, error) {
+	conn, connErr := net.ListenPacket("udp", addr)
+	if connErr != nil {
+		return nil, connErr
+	}
+	return &HTTP3Server{
+		server: &http3.Server{
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		},
+		conn: conn,
+	}, nil
+}
+
+// Addr returns the UDP address the server listens on.
+func (s *HTTP3Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Serve blocks until the server is shut down, when it returns http.ErrServerClosed.
+func (s *HTTP3Server) Serve() error {
+	return s.server.Serve(s.conn)
+}
+
+// Shutdown asks clients to go away, waits for their requests to finish until ctx is done, and
+// closes the socket.
+func (s *HTTP3Server) Shutdown(ctx context.Context) error {
+	return errors.Join(s.server.Shutdown(ctx), s.conn.Close())
+}
+
+// Advertise adds an Alt-Svc header to the responses of next telling clients they can switch to
+// HTTP/3.
+func (s *HTTP3Server) Advertise(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor < 3 {
+			// Fails only until Serve has started, when there is nothing to advertise yet.
+			_ = s.server.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,248 @@
+package https
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert returns a certificate for commonName signed by parent, or self-signed CA when
+// parent is nil.
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	r := require.New(t)
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(keyErr)
+	serial, serialErr := rand.Int(rand.Reader, big.NewInt(1<<62))
+	r.NoError(serialErr)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, derErr := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	r.NoError(derErr)
+	cert, certErr := x509.ParseCertificate(der)
+	r.NoError(certErr)
+	keyDER, keyDERErr := x509.MarshalECPrivateKey(key)
+	r.NoError(keyDERErr)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	require.NoError(t, os.WriteFile(certFile, c.certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM, 0o600))
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, certErr := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	require.NoError(t, certErr)
+	return cert
+}
+
+func TestParseClientAuth(t *testing.T) {
+	r := require.New(t)
+	clientAuth, err := ParseClientAuth("Require")
+	r.NoError(err)
+	r.Equal(tls.RequireAndVerifyClientCert, clientAuth)
+	_, err = ParseClientAuth("always")
+	r.Error(err)
+}
+
+func TestReloader(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+	ca := newTestCert(t, "ca", nil)
+	r.NoError(os.WriteFile(caFile, ca.certPEM, 0o600))
+	first := newTestCert(t, "first", ca)
+	first.write(t, certFile, keyFile)
+
+	reloader, reloaderErr := NewReloader(certFile, keyFile, caFile)
+	r.NoError(reloaderErr)
+	r.False(reloader.Changed())
+	r.Equal(first.cert.Raw, reloader.Certificate().Certificate[0])
+
+	// A broken pair keeps the old certificate
+	second := newTestCert(t, "second", ca)
+	r.NoError(os.WriteFile(certFile, second.certPEM, 0o600))
+	r.True(reloader.Changed())
+	r.Error(reloader.Reload())
+	r.Equal(first.cert.Raw, reloader.Certificate().Certificate[0])
+
+	// Watch picks up the completed pair
+	r.NoError(os.WriteFile(keyFile, second.keyPEM, 0o600))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 1)
+	go reloader.Watch(ctx, 10*time.Millisecond, func(err error) {
+		reloaded <- err
+	})
+	select {
+	case err := <-reloaded:
+		r.NoError(err)
+	case <-time.After(5 * time.Second):
+		r.Fail("the certificate wasn't reloaded")
+	}
+	r.Equal(second.cert.Raw, reloader.Certificate().Certificate[0])
+}
+
+func TestMutualTLS(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+	ca := newTestCert(t, "ca", nil)
+	r.NoError(os.WriteFile(caFile, ca.certPEM, 0o600))
+	newTestCert(t, "server", ca).write(t, certFile, keyFile)
+	reloader, reloaderErr := NewReloader(certFile, keyFile, caFile)
+	r.NoError(reloaderErr)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, req.TLS.VerifiedChains[0][0].Subject.String())
+	}))
+	server.TLS = reloader.TLSConfig(tls.RequireAndVerifyClientCert)
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCerts ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: clientCerts}}}
+		resp, respErr := client.Get(server.URL)
+		if respErr != nil {
+			return "", respErr
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, bodyErr := io.ReadAll(resp.Body)
+		return string(body), bodyErr
+	}
+
+	subject, err := get(newTestCert(t, "billing", ca).tlsCertificate(t))
+	r.NoError(err)
+	r.Equal("CN=billing,O=Acme", subject)
+
+	_, err = get()
+	r.Error(err)
+	_, err = get(newTestCert(t, "intruder", newTestCert(t, "other ca", nil)).tlsCertificate(t))
+	r.Error(err)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	r := require.New(t)
+	redirect := func(httpsAddr, target string) string {
+		w := httptest.NewRecorder()
+		RedirectHandler(httpsAddr).ServeHTTP(w, httptest.NewRequest("POST", target, nil))
+		r.Equal(http.StatusPermanentRedirect, w.Code)
+		return w.Header().Get("Location")
+	}
+	r.Equal("https://api.example.com/v1.0/users?page=2", redirect(":443", "http://api.example.com/v1.0/users?page=2"))
+	r.Equal("https://api.example.com:8443/v1.0/user", redirect(":8443", "http://api.example.com:8080/v1.0/user"))
+	r.Equal("https://[::1]:8443/", redirect(":8443", "http://[::1]:8080/"))
+	r.Equal("https://[::1]/", redirect(":443", "http://[::1]/"))
+}
+
+func TestHTTP3(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	ca := newTestCert(t, "ca", nil)
+	newTestCert(t, "server", ca).write(t, certFile, keyFile)
+	reloader, reloaderErr := NewReloader(certFile, keyFile, "")
+	r.NoError(reloaderErr)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, req.Proto)
+	})
+
+	h3, h3Err := ListenHTTP3("127.0.0.1:0", handler, reloader.TLSConfig(tls.NoClientCert))
+	r.NoError(h3Err)
+	served := make(chan error, 1)
+	go func() {
+		served <- h3.Serve()
+	}()
+	// httptest.Server would add its own certificate
+	ln, lnErr := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(lnErr)
+	tcpServer := &http.Server{Handler: h3.Advertise(handler), TLSConfig: reloader.TLSConfig(tls.NoClientCert)}
+	go func() {
+		_ = tcpServer.ServeTLS(ln, "", "")
+	}()
+	defer func() {
+		_ = tcpServer.Close()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tlsClientConfig := &tls.Config{RootCAs: roots}
+	get := func(client *http.Client, url string) *http.Response {
+		resp, respErr := client.Get(url)
+		r.NoError(respErr)
+		return resp
+	}
+
+	// HTTP/1.1 and HTTP/2 responses advertise the QUIC port
+	_, port, _ := net.SplitHostPort(h3.Addr().String())
+	r.Eventually(func() bool {
+		resp := get(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsClientConfig}}, "https://"+ln.Addr().String())
+		_ = resp.Body.Close()
+		return resp.Header.Get("Alt-Svc") == `h3=":`+port+`"; ma=2592000`
+	}, 5*time.Second, 10*time.Millisecond)
+
+	transport := &http3.Transport{TLSClientConfig: tlsClientConfig}
+	defer func() {
+		_ = transport.Close()
+	}()
+	resp := get(&http.Client{Transport: transport}, "https://"+h3.Addr().String()+"/")
+	body, bodyErr := io.ReadAll(resp.Body)
+	r.NoError(bodyErr)
+	r.NoError(resp.Body.Close())
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal("HTTP/3.0", string(body))
+	r.Empty(resp.Header.Get("Alt-Svc"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.NoError(h3.Shutdown(ctx))
+	r.ErrorIs(<-served, http.ErrServerClosed)
+}
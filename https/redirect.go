@@ -0,0 +1,35 @@
+package https
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectHandler permanently redirects every request to the same URL over HTTPS, on the port
+// of httpsAddr, e.g. ":8443".
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     req.URL.Path,
+			RawPath:  req.URL.RawPath,
+			RawQuery: req.URL.RawQuery,
+		}
+		// 308 keeps the method and body, unlike 301.
+		http.Redirect(w, req, target.String(), http.StatusPermanentRedirect)
+	})
+}
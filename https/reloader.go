@@ -0,0 +1,183 @@
+package https
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies client certificates when clients send one.
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire rejects connections without a valid client certificate.
+	ClientAuthRequire = "require"
+)
+
+// ParseClientAuth parses "none", "optional" or "require".
+func ParseClientAuth(value string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth %q", value)
+	}
+}
+
+// fileStamp tells whether a file changed since it was last read.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader serves a certificate, and optionally the CAs client certificates must chain to,
+// from files it reads again when asked to or when they change. New connections use the new
+// certificates while established ones carry on.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+
+	// mu serializes reloads and guards stamps.
+	mu     sync.Mutex
+	stamps map[string]fileStamp
+}
+
+// NewReloader reads the certificate and key, and the client CAs when clientCAFile isn't empty.
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// Reload reads the files again. When that fails the previous certificates stay in use.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Stat first so a file replaced while it's read is read again on the next check.
+	stamps, stampsErr := r.stat()
+	if stampsErr != nil {
+		return stampsErr
+	}
+	cert, certErr := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if certErr != nil {
+		return certErr
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, pemErr := os.ReadFile(r.clientCAFile)
+		if pemErr != nil {
+			return pemErr
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.clientCAFile)
+		}
+	}
+	r.cert.Store(&cert)
+	if clientCAs != nil {
+		r.clientCAs.Store(clientCAs)
+	}
+	r.stamps = stamps
+	return nil
+}
+
+func (r *Reloader) stat() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, file := range r.files() {
+		info, infoErr := os.Stat(file)
+		if infoErr != nil {
+			return nil, infoErr
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// Changed reports whether a file changed since the last successful reload.
+func (r *Reloader) Changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stamps, stampsErr := r.stat()
+	if stampsErr != nil {
+		// A missing file is likely being replaced. Reload once it's back.
+		return false
+	}
+	for file, stamp := range stamps {
+		if r.stamps[file] != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch checks the files every interval until ctx is done and reloads them when they change.
+// onReload is called with the outcome of each reload.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.Changed() {
+				onReload(r.Reload())
+			}
+		}
+	}
+}
+
+// Certificate returns the certificate currently served.
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// TLSConfig returns a server config that always uses the latest certificates. Client
+// certificates are asked for as clientAuth says.
+func (r *Reloader) TLSConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		ClientAuth: clientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+	}
+	if r.clientCAFile != "" {
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig := config.Clone()
+			clientConfig.GetConfigForClient = nil
+			clientConfig.ClientCAs = r.clientCAs.Load()
+			return clientConfig, nil
+		}
+	}
+	return config
+}
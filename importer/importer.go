@@ -0,0 +1,242 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"path/filepath"
+	"strings"
+
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/brandonrachal/go-toolbox/jsonutils"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type Format string
+
+const (
+	CSV    Format = "csv"
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+)
+
+// Fields are the user fields read from every source, keyed by their json names.
+var Fields = []string{"first_name", "last_name", "email", "birthday"}
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(strings.ToLower(format)) {
+	case CSV:
+		return CSV, nil
+	case JSON:
+		return JSON, nil
+	case NDJSON, "jsonl":
+		return NDJSON, nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+// FormatFromPath guesses the format from a file extension.
+func FormatFromPath(path string) (Format, error) {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "" {
+		return "", fmt.Errorf("can't guess the format of %q", path)
+	}
+	return ParseFormat(ext)
+}
+
+// ColumnMapping maps a user field (e.g. "first_name") to the CSV header holding it.
+type ColumnMapping map[string]string
+
+// ParseColumnMapping parses "field=Header,field=Header". Fields that aren't mentioned are
+// read from a header with the field's own name.
+func ParseColumnMapping(mapping string) (ColumnMapping, error) {
+	columns := make(ColumnMapping, len(Fields))
+	for _, field := range Fields {
+		columns[field] = field
+	}
+	if strings.TrimSpace(mapping) == "" {
+		return columns, nil
+	}
+	for _, pair := range strings.Split(mapping, ",") {
+		field, header, found := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		if !found {
+			return nil, fmt.Errorf("column mapping %q should look like field=Header", pair)
+		} else if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("column mapping has unknown field %q", field)
+		}
+		columns[field] = strings.TrimSpace(header)
+	}
+	return columns, nil
+}
+
+// Row is a single record read from an import source. Line is the line number for CSV and
+// NDJSON and the 1-based element index for JSON arrays. Err is set when the record itself
+// couldn't be read, in which case Fields may be incomplete.
+type Row struct {
+	Line   int
+	Fields map[string]string
+	Err    error
+}
+
+// CreateUser converts the row into a user and validates it with the same binding rules the
+// API applies to models.CreateUser.
+func (r Row) CreateUser() (*models.CreateUser, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	user := models.CreateUser{
+		FirstName: strings.TrimSpace(r.Fields["first_name"]),
+		LastName:  strings.TrimSpace(r.Fields["last_name"]),
+		Email:     strings.TrimSpace(r.Fields["email"]),
+	}
+	if birthday := strings.TrimSpace(r.Fields["birthday"]); birthday != "" {
+		if birthdayErr := user.Birthday.UnmarshalJSON([]byte(birthday)); birthdayErr != nil {
+			return nil, fmt.Errorf("birthday %q is not a %s date", birthday, jsonutils.SimpleDateFormat)
+		}
+	}
+	if validateErr := binding.Validator.ValidateStruct(&user); validateErr != nil {
+		return nil, validateErr
+	}
+	return &user, nil
+}
+
+// Read returns the rows of r in the given format. A yielded error is fatal and ends the
+// sequence; problems limited to one record are reported through Row.Err instead.
+func Read(r io.Reader, format Format, columns ColumnMapping) iter.Seq2[Row, error] {
+	switch format {
+	case CSV:
+		return readCSV(r, columns)
+	case JSON:
+		return readJSON(r)
+	case NDJSON:
+		return readNDJSON(r)
+	}
+	return func(yield func(Row, error) bool) {
+		yield(Row{}, fmt.Errorf("unknown format %q", format))
+	}
+}
+
+func readCSV(r io.Reader, columns ColumnMapping) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		header, headerErr := reader.Read()
+		if headerErr != nil {
+			yield(Row{}, fmt.Errorf("reading csv header - %w", headerErr))
+			return
+		}
+		indexes := make(map[string]int, len(Fields))
+		for _, field := range Fields {
+			column := columns[field]
+			index := -1
+			for i, name := range header {
+				if strings.EqualFold(strings.TrimSpace(name), column) {
+					index = i
+					break
+				}
+			}
+			if index == -1 {
+				yield(Row{}, fmt.Errorf("csv header has no %q column for %s", column, field))
+				return
+			}
+			indexes[field] = index
+		}
+		for {
+			record, recordErr := reader.Read()
+			if errors.Is(recordErr, io.EOF) {
+				return
+			}
+			var parseErr *csv.ParseError
+			if recordErr != nil && !errors.As(recordErr, &parseErr) {
+				yield(Row{}, recordErr)
+				return
+			}
+			var line int
+			if parseErr != nil {
+				line = parseErr.Line
+			} else {
+				line, _ = reader.FieldPos(0)
+			}
+			row := Row{Line: line, Fields: make(map[string]string, len(Fields)), Err: recordErr}
+			for field, index := range indexes {
+				if index < len(record) {
+					row.Fields[field] = record[index]
+				} else if row.Err == nil {
+					row.Err = fmt.Errorf("line has %d columns, %s is in column %d", len(record), field, index+1)
+				}
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+func readJSON(r io.Reader) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		decoder := json.NewDecoder(r)
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			yield(Row{}, fmt.Errorf("reading json array - %w", tokenErr))
+			return
+		} else if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			yield(Row{}, errors.New("json input must be an array of users"))
+			return
+		}
+		for index := 1; decoder.More(); index++ {
+			var raw json.RawMessage
+			if decodeErr := decoder.Decode(&raw); decodeErr != nil {
+				yield(Row{}, fmt.Errorf("reading json element %d - %w", index, decodeErr))
+				return
+			}
+			if !yield(jsonRow(index, raw), nil) {
+				return
+			}
+		}
+	}
+}
+
+func readNDJSON(r io.Reader) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			if !yield(jsonRow(line, json.RawMessage(text)), nil) {
+				return
+			}
+		}
+		if scanErr := scanner.Err(); scanErr != nil {
+			yield(Row{}, scanErr)
+		}
+	}
+}
+
+func jsonRow(line int, raw json.RawMessage) Row {
+	row := Row{Line: line, Fields: make(map[string]string, len(Fields))}
+	var object map[string]any
+	if unmarshalErr := json.Unmarshal(raw, &object); unmarshalErr != nil {
+		row.Err = fmt.Errorf("not a json object - %w", unmarshalErr)
+		return row
+	}
+	for _, field := range Fields {
+		switch value := object[field].(type) {
+		case nil:
+		case string:
+			row.Fields[field] = value
+		default:
+			if row.Err == nil {
+				row.Err = fmt.Errorf("%s must be a string", field)
+			}
+		}
+	}
+	return row
+}
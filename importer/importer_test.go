@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mappedCSV = `Given Name,Surname,E-mail,DOB
+Testy,McTesterson,testy.mctesterson@gmail.com,1996-06-06
+,Doe,john.doe@gmail.com,2000-12-16
+Jane,Doe,jane.doe@gmail.com,16/03/2003
+`
+	usersJSON = `[
+	{"first_name": "Testy", "last_name": "McTesterson", "email": "testy.mctesterson@gmail.com", "birthday": "1996-06-06"},
+	{"first_name": "John", "last_name": "Doe", "email": 7, "birthday": "2000-12-16"},
+	"Jane"
+]`
+	usersNDJSON = `{"first_name": "Testy", "last_name": "McTesterson", "email": "testy.mctesterson@gmail.com", "birthday": "1996-06-06"}
+
+{"first_name": "John", "last_name": "Doe", "email": "john.doe@gmail.com"}
+`
+)
+
+func TestParseColumnMapping(t *testing.T) {
+	r := require.New(t)
+	columns, columnsErr := ParseColumnMapping("first_name=Given Name, birthday = DOB")
+	r.NoError(columnsErr)
+	r.Equal(ColumnMapping{"first_name": "Given Name", "last_name": "last_name", "email": "email", "birthday": "DOB"}, columns)
+	_, columnsErr = ParseColumnMapping("nickname=Nick")
+	r.Error(columnsErr)
+	_, columnsErr = ParseColumnMapping("first_name")
+	r.Error(columnsErr)
+}
+
+func TestFormatFromPath(t *testing.T) {
+	r := require.New(t)
+	format, formatErr := FormatFromPath("partners/users.JSONL")
+	r.NoError(formatErr)
+	r.Equal(NDJSON, format)
+	_, formatErr = FormatFromPath("users")
+	r.Error(formatErr)
+}
+
+func TestReadCSV(t *testing.T) {
+	r := require.New(t)
+	columns, columnsErr := ParseColumnMapping("first_name=Given Name,last_name=Surname,email=E-mail,birthday=DOB")
+	r.NoError(columnsErr)
+	rows := collect(r, Read(strings.NewReader(mappedCSV), CSV, columns))
+	r.Len(rows, 3)
+	r.Equal(2, rows[0].Line)
+	user, userErr := rows[0].CreateUser()
+	r.NoError(userErr)
+	r.Equal("testy.mctesterson@gmail.com", user.Email)
+	r.Equal("1996-06-06", user.Birthday.String())
+	// Missing first name
+	_, userErr = rows[1].CreateUser()
+	r.ErrorContains(userErr, "FirstName")
+	// Wrong date format
+	r.Equal(4, rows[2].Line)
+	_, userErr = rows[2].CreateUser()
+	r.ErrorContains(userErr, "birthday")
+}
+
+func TestReadCSVMissingColumn(t *testing.T) {
+	r := require.New(t)
+	columns, columnsErr := ParseColumnMapping("")
+	r.NoError(columnsErr)
+	for _, rowErr := range Read(strings.NewReader(mappedCSV), CSV, columns) {
+		r.ErrorContains(rowErr, "first_name")
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	r := require.New(t)
+	rows := collect(r, Read(strings.NewReader(usersJSON), JSON, nil))
+	r.Len(rows, 3)
+	_, userErr := rows[0].CreateUser()
+	r.NoError(userErr)
+	_, userErr = rows[1].CreateUser()
+	r.ErrorContains(userErr, "email must be a string")
+	r.Equal(3, rows[2].Line)
+	r.Error(rows[2].Err)
+}
+
+func TestReadNDJSON(t *testing.T) {
+	r := require.New(t)
+	rows := collect(r, Read(strings.NewReader(usersNDJSON), NDJSON, nil))
+	r.Len(rows, 2)
+	_, userErr := rows[0].CreateUser()
+	r.NoError(userErr)
+	r.Equal(3, rows[1].Line)
+	_, userErr = rows[1].CreateUser()
+	r.ErrorContains(userErr, "Birthday")
+}
+
+func collect(r *require.Assertions, rows func(func(Row, error) bool)) []Row {
+	var collected []Row
+	for row, rowErr := range rows {
+		r.NoError(rowErr)
+		collected = append(collected, row)
+	}
+	return collected
+}
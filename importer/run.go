@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/models"
+)
+
+const DefaultBatchSize = 500
+
+func ParseMode(mode string) (db.ImportMode, error) {
+	switch mode {
+	case "insert":
+		return db.ImportInsert, nil
+	case "upsert":
+		return db.ImportUpsert, nil
+	case "skip":
+		return db.ImportSkipDuplicates, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q, expected insert, upsert or skip", mode)
+}
+
+type Options struct {
+	Mode      db.ImportMode
+	DryRun    bool
+	BatchSize int
+}
+
+// Report is the machine readable result of an import.
+type Report struct {
+	DryRun   bool        `json:"dry_run"`
+	Total    int         `json:"total"`
+	Created  int         `json:"created"`
+	Updated  int         `json:"updated"`
+	Skipped  int         `json:"skipped"`
+	Rejected []Rejection `json:"rejected"`
+}
+
+type Rejection struct {
+	Line   int               `json:"line"`
+	Reason string            `json:"reason"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func (r *Report) reject(row Row, err error) {
+	r.Rejected = append(r.Rejected, Rejection{Line: row.Line, Reason: err.Error(), Fields: row.Fields})
+}
+
+// Run validates every row and imports the valid ones in transactions of opts.BatchSize
+// users. Invalid rows and rows the database refuses end up in Report.Rejected, and a refused
+// row rejects the rest of its batch with it. A dry run rolls every batch back, so duplicates
+// spanning two batches aren't detected.
+func Run(ctx context.Context, dbClient *db.Client, rows iter.Seq2[Row, error], opts Options) (*Report, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	report := &Report{DryRun: opts.DryRun, Rejected: []Rejection{}}
+	batchRows := make([]Row, 0, batchSize)
+	batchUsers := make([]models.CreateUser, 0, batchSize)
+	flush := func() error {
+		if len(batchUsers) == 0 {
+			return nil
+		}
+		results, importErr := dbClient.ImportUsers(ctx, batchUsers, opts.Mode, opts.DryRun)
+		if importErr != nil {
+			return importErr
+		}
+		failedLine := 0
+		for i, result := range results {
+			if result.Outcome == db.ImportFailed {
+				failedLine = batchRows[i].Line
+				break
+			}
+		}
+		for i, result := range results {
+			switch result.Outcome {
+			case db.ImportCreated:
+				report.Created++
+			case db.ImportUpdated:
+				report.Updated++
+			case db.ImportSkipped:
+				report.Skipped++
+			case db.ImportRolledBack:
+				report.reject(batchRows[i], fmt.Errorf("rolled back with line %d", failedLine))
+			default:
+				report.reject(batchRows[i], result.Err)
+			}
+		}
+		batchRows = batchRows[:0]
+		batchUsers = batchUsers[:0]
+		return nil
+	}
+	for row, rowErr := range rows {
+		if rowErr != nil {
+			return report, rowErr
+		}
+		report.Total++
+		user, userErr := row.CreateUser()
+		if userErr != nil {
+			report.reject(row, userErr)
+			continue
+		}
+		batchRows = append(batchRows, row)
+		batchUsers = append(batchUsers, *user)
+		if len(batchUsers) >= batchSize {
+			if flushErr := flush(); flushErr != nil {
+				return report, flushErr
+			}
+		}
+	}
+	if flushErr := flush(); flushErr != nil {
+		return report, flushErr
+	}
+	slices.SortStableFunc(report.Rejected, func(a, b Rejection) int {
+		return a.Line - b.Line
+	})
+	return report, nil
+}
@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/internal/testenv"
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDryRun(t *testing.T) {
+	r := require.New(t)
+	ctx, dbClient := newTestDB(t)
+	report := run(r, ctx, dbClient, Options{Mode: db.ImportInsert, DryRun: true}, "a@example.com", "b@example.com")
+	r.True(report.DryRun)
+	r.Equal(2, report.Created)
+	r.Empty(report.Rejected)
+	r.Zero(countUsers(r, ctx, dbClient))
+}
+
+func TestRunUpsert(t *testing.T) {
+	r := require.New(t)
+	ctx, dbClient := newTestDB(t)
+	run(r, ctx, dbClient, Options{Mode: db.ImportInsert}, "a@example.com")
+	rows := usersNDJSONOf("a@example.com", "b@example.com")
+	rows = strings.Replace(rows, "McTesterson", "Upserted", 1)
+	report, reportErr := Run(ctx, dbClient, Read(strings.NewReader(rows), NDJSON, nil), Options{Mode: db.ImportUpsert})
+	r.NoError(reportErr)
+	r.Equal(1, report.Created)
+	r.Equal(1, report.Updated)
+	r.Empty(report.Rejected)
+	user, userErr := dbClient.GetUserByEmail(ctx, "a@example.com")
+	r.NoError(userErr)
+	r.Equal("Upserted", user.LastName)
+	r.Equal(int64(2), countUsers(r, ctx, dbClient))
+}
+
+func TestRunSkipDuplicates(t *testing.T) {
+	r := require.New(t)
+	ctx, dbClient := newTestDB(t)
+	run(r, ctx, dbClient, Options{Mode: db.ImportInsert}, "a@example.com")
+	report := run(r, ctx, dbClient, Options{Mode: db.ImportSkipDuplicates}, "a@example.com", "b@example.com")
+	r.Equal(1, report.Created)
+	r.Equal(1, report.Skipped)
+	r.Empty(report.Rejected)
+	r.Equal(int64(2), countUsers(r, ctx, dbClient))
+}
+
+func TestRunBatches(t *testing.T) {
+	r := require.New(t)
+	ctx, dbClient := newTestDB(t)
+	// The second batch holds c and the duplicate of a, so it goes back as a whole while the first
+	// and the last are committed.
+	report := run(r, ctx, dbClient, Options{Mode: db.ImportInsert, BatchSize: 2},
+		"a@example.com", "b@example.com", "c@example.com", "a@example.com", "e@example.com")
+	r.Equal(5, report.Total)
+	r.Equal(3, report.Created)
+	r.Len(report.Rejected, 2)
+	r.Equal(3, report.Rejected[0].Line)
+	r.Equal("rolled back with line 4", report.Rejected[0].Reason)
+	r.Equal(4, report.Rejected[1].Line)
+	r.Equal(int64(3), countUsers(r, ctx, dbClient))
+	_, userErr := dbClient.GetUserByEmail(ctx, "c@example.com")
+	r.Error(userErr)
+	_, userErr = dbClient.GetUserByEmail(ctx, "e@example.com")
+	r.NoError(userErr)
+}
+
+func TestImportUsersRollsBackBatch(t *testing.T) {
+	r := require.New(t)
+	ctx, dbClient := newTestDB(t)
+	users := make([]models.CreateUser, 0, 3)
+	for _, email := range []string{"a@example.com", "b@example.com", "a@example.com"} {
+		row := collect(r, Read(strings.NewReader(usersNDJSONOf(email)), NDJSON, nil))[0]
+		user, userErr := row.CreateUser()
+		r.NoError(userErr)
+		users = append(users, *user)
+	}
+	results, importErr := dbClient.ImportUsers(ctx, users, db.ImportInsert, false)
+	r.NoError(importErr)
+	r.Len(results, 3)
+	r.Equal(db.ImportRolledBack, results[0].Outcome)
+	r.Equal(db.ImportRolledBack, results[1].Outcome)
+	r.Equal(db.ImportFailed, results[2].Outcome)
+	r.Error(results[2].Err)
+	r.Zero(countUsers(r, ctx, dbClient))
+}
+
+// newTestDB returns a migrated database of the test's own and a context of the default tenant.
+func newTestDB(t *testing.T) (context.Context, *db.Client) {
+	r := require.New(t)
+	ctx := db.WithTenant(context.Background(), db.DefaultTenantId)
+	dbClient, dbClientErr := testenv.Migrate(ctx, filepath.Join(t.TempDir(), "importer_test.db"))
+	r.NoError(dbClientErr)
+	t.Cleanup(func() {
+		_ = dbClient.Close()
+	})
+	return ctx, dbClient
+}
+
+// run imports a user per email and fails the test if Run does.
+func run(r *require.Assertions, ctx context.Context, dbClient *db.Client, opts Options, emails ...string) *Report {
+	report, reportErr := Run(ctx, dbClient, Read(strings.NewReader(usersNDJSONOf(emails...)), NDJSON, nil), opts)
+	r.NoError(reportErr)
+	return report
+}
+
+// usersNDJSONOf returns an NDJSON line per email, so the user of emails[i] is on line i+1.
+func usersNDJSONOf(emails ...string) string {
+	var lines strings.Builder
+	for _, email := range emails {
+		fmt.Fprintf(&lines, `{"first_name": "Testy", "last_name": "McTesterson", "email": %q, "birthday": "1996-06-06"}`+"\n", email)
+	}
+	return lines.String()
+}
+
+func countUsers(r *require.Assertions, ctx context.Context, dbClient *db.Client) int64 {
+	count, countErr := dbClient.CountAllUsers(ctx)
+	r.NoError(countErr)
+	return count
+}
@@ -1,65 +1,113 @@
 package internal
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/brandonrachal/gin-and-tonic/db"
-	"github.com/brandonrachal/go-toolbox/dbutils"
-	"github.com/brandonrachal/go-toolbox/envutils"
-	"github.com/brandonrachal/go-toolbox/migrations"
+	"github.com/brandonrachal/gin-and-tonic/migrations"
 )
 
 const (
-	migrationTable = "goose_migrations"
-	prodEnv        = "prod"
-	devEnv         = "dev"
-	testEnv        = "test"
+	// MigrationTable is where goose records the applied migrations.
+	MigrationTable = "goose_migrations"
+	// DataDirVar names the directory of the database files.
+	DataDirVar = "DATA_DIR"
+	modulePath = "github.com/brandonrachal/gin-and-tonic"
+	prodEnv    = "prod"
+	devEnv     = "dev"
+	testEnv    = "test"
 )
 
-var cachedGoEnv *envutils.GoEnv
-
-func init() {
-	var goEnvErr error
-	cachedGoEnv, goEnvErr = envutils.NewGoEnv()
-	if goEnvErr != nil {
-		panic(fmt.Sprintf("Couldn't get the go env - %s\n", goEnvErr))
+// IsEnv reports whether env is one of prod, dev or test.
+func IsEnv(env string) bool {
+	switch env {
+	case prodEnv, devEnv, testEnv:
+		return true
 	}
+	return false
 }
 
-func dbPath(env string) string {
-	fileName := fmt.Sprintf("sqlite_%s_database.db", env)
-	return filepath.Join(cachedGoEnv.ModuleRootPath(), "data", fileName)
-}
-
-func migrationsPath() string {
-	return filepath.Join(cachedGoEnv.ModuleRootPath(), "migrations")
+// DBPath returns the database file of env in DataDir.
+func DBPath(env string) string {
+	return DBFile(DataDir(), env)
 }
 
-func dBMigrationClient(env string) (*migrations.Client, error) {
-	return migrations.NewClient(dbutils.SQLite, dbPath(env), migrationTable, migrationsPath())
+// DBFile returns the database file of env in dataDir.
+func DBFile(dataDir, env string) string {
+	return filepath.Join(dataDir, fmt.Sprintf("sqlite_%s_database.db", env))
 }
 
-func ProdDBMigrationClient() (*migrations.Client, error) {
-	return dBMigrationClient(prodEnv)
+// DataDir returns the directory of the database files. It is $DATA_DIR when set, else the data
+// directory of the source tree the working directory is in, else data in the working
+// directory, so the programs run the same from a checkout and anywhere else.
+func DataDir() string {
+	if dir := os.Getenv(DataDirVar); dir != "" {
+		return dir
+	}
+	if root, ok := moduleRoot(); ok {
+		return filepath.Join(root, "data")
+	}
+	return "data"
 }
 
-func ProdDBClient() (*db.Client, error) {
-	return db.NewClient(dbPath(prodEnv))
+// MigrationsDir returns the migrations directory of the source tree the working directory is
+// in, where new migrations are written.
+func MigrationsDir() (string, error) {
+	root, ok := moduleRoot()
+	if !ok {
+		return "", fmt.Errorf("not in a %s source tree", modulePath)
+	}
+	return filepath.Join(root, "migrations"), nil
 }
 
-func DevDBMigrationClient() (*migrations.Client, error) {
-	return dBMigrationClient(devEnv)
+// moduleRoot walks up from the working directory to the go.mod of this module.
+func moduleRoot() (string, bool) {
+	dir, dirErr := os.Getwd()
+	if dirErr != nil {
+		return "", false
+	}
+	for {
+		if isModuleRoot(dir) {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
 }
 
-func DevDBClient() (*db.Client, error) {
-	return db.NewClient(dbPath(devEnv))
+func isModuleRoot(dir string) bool {
+	file, fileErr := os.Open(filepath.Join(dir, "go.mod"))
+	if fileErr != nil {
+		return false
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if module, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); found {
+			return strings.Trim(strings.TrimSpace(module), `"`) == modulePath
+		}
+	}
+	return false
 }
 
-func TestDBMigrationClient() (*migrations.Client, error) {
-	return dBMigrationClient(testEnv)
+// MigrationClient returns the migration client of the database file at path.
+func MigrationClient(path string) (*migrations.Client, error) {
+	return migrations.NewClient(path, MigrationTable)
 }
 
-func TestDBClient() (*db.Client, error) {
-	return db.NewClient(dbPath(testEnv))
+// DBClient returns the db client for env, one of prod, dev or test.
+func DBClient(env string) (*db.Client, error) {
+	if IsEnv(env) {
+		return db.NewClient(DBPath(env))
+	}
+	return nil, fmt.Errorf("unknown env %q", env)
 }
@@ -0,0 +1,92 @@
+// Package testenv serves the real API from a migrated database of its own, for the tests of the
+// packages that talk to it.
+package testenv
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/controllers"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/gin-gonic/gin"
+)
+
+// Env is a migrated database, an admin key of the default tenant and a router serving both.
+type Env struct {
+	// Path is the database file, for tests opening it directly.
+	Path   string
+	DB     *db.Client
+	APIKey string
+	Router *gin.Engine
+	dir    string
+}
+
+// New migrates a database in a temp dir named after name, issues it an admin key with every scope
+// and builds the router with options. Callers must Close the Env.
+func New(ctx context.Context, name string, logger *slog.Logger, options controllers.RouterOptions) (*Env, error) {
+	dir, dirErr := os.MkdirTemp("", name)
+	if dirErr != nil {
+		return nil, fmt.Errorf("couldn't create a temp dir - %w", dirErr)
+	}
+	env := &Env{Path: filepath.Join(dir, name+".db"), dir: dir}
+	if setupErr := env.setup(ctx, name, logger, options); setupErr != nil {
+		_ = env.Close()
+		return nil, setupErr
+	}
+	return env, nil
+}
+
+func (e *Env) setup(ctx context.Context, name string, logger *slog.Logger, options controllers.RouterOptions) error {
+	dbClient, dbClientErr := Migrate(ctx, e.Path)
+	if dbClientErr != nil {
+		return dbClientErr
+	}
+	e.DB = dbClient
+	key, prefix, hash, keyErr := auth.GenerateAPIKey()
+	if keyErr != nil {
+		return fmt.Errorf("couldn't generate an api key - %w", keyErr)
+	}
+	tenantId := db.DefaultTenantId
+	if _, createErr := dbClient.CreateAPIKey(ctx, name, prefix, hash, auth.AllScopes, auth.RoleAdmin, nil, &tenantId); createErr != nil {
+		return fmt.Errorf("couldn't issue an api key - %w", createErr)
+	}
+	e.APIKey = key
+	e.Router = controllers.GetRouter(logger, dbClient, options)
+	return nil
+}
+
+// Migrate ups every migration of the database file at path and returns its db client, for tests
+// that only need the database.
+func Migrate(ctx context.Context, path string) (*db.Client, error) {
+	migrationClient, migrationClientErr := internal.MigrationClient(path)
+	if migrationClientErr != nil {
+		return nil, fmt.Errorf("couldn't get the migration client - %w", migrationClientErr)
+	}
+	upAllErr := migrationClient.UpAll(ctx)
+	_ = migrationClient.Close()
+	if upAllErr != nil {
+		return nil, fmt.Errorf("couldn't up all migrations - %w", upAllErr)
+	}
+	dbClient, dbClientErr := db.NewClient(path)
+	if dbClientErr != nil {
+		return nil, fmt.Errorf("couldn't retrieve the db client - %w", dbClientErr)
+	}
+	return dbClient, nil
+}
+
+// Close closes the db client and removes the database.
+func (e *Env) Close() error {
+	var closeErr error
+	if e.DB != nil {
+		closeErr = e.DB.Close()
+	}
+	if removeErr := os.RemoveAll(e.dir); removeErr != nil && closeErr == nil {
+		closeErr = removeErr
+	}
+	return closeErr
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedaction(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	logger := New(&buf, FormatText, slog.LevelInfo)
+	logger.Info("Updated jane@example.com",
+		slog.String("email", "not even an address"),
+		slog.String("note", "sent to john.doe+test@mail.example.org"),
+		slog.Any("error", errors.New(`duplicate email "jane@example.com"`)),
+		slog.Group("user", slog.String("birthday", "1990-01-01"), slog.Int64("id", 7)),
+	)
+	line := buf.String()
+	r.NotContains(line, "example.com")
+	r.NotContains(line, "example.org")
+	r.NotContains(line, "not even an address")
+	r.NotContains(line, "1990-01-01")
+	r.Contains(line, "user.id=7")
+	r.Contains(line, `msg="Updated [redacted]"`)
+}
+
+func TestFromContext(t *testing.T) {
+	r := require.New(t)
+	fallback := New(&bytes.Buffer{}, FormatJSON, slog.LevelInfo)
+	r.Same(fallback, FromContext(context.Background(), fallback))
+	requestLogger := fallback.With(slog.String("request_id", "1"))
+	r.Same(requestLogger, FromContext(WithLogger(context.Background(), requestLogger), fallback))
+}
+
+func TestParse(t *testing.T) {
+	r := require.New(t)
+	format, formatErr := ParseFormat("TEXT")
+	r.NoError(formatErr)
+	r.Equal(FormatText, format)
+	_, formatErr = ParseFormat("xml")
+	r.Error(formatErr)
+	level, levelErr := ParseLevel("warn")
+	r.NoError(levelErr)
+	r.Equal(slog.LevelWarn, level)
+	_, levelErr = ParseLevel("loud")
+	r.Error(levelErr)
+}
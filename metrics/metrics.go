@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// UnmatchedRoute labels requests that matched no route, so scanners can't blow up the number of
+// series.
+const UnmatchedRoute = "unmatched"
+
+// businessQueryTimeout bounds the queries run while being scraped.
+const businessQueryTimeout = 5 * time.Second
+
+// Metrics holds the service's Prometheus metrics and the registry they are served from.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+}
+
+// New registers HTTP, database, business and Go runtime metrics, and starts observing every
+// statement dbClient runs.
+func New(dbClient *db.Client) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by route template and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Time taken to run prepared statements, by statement.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"statement"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Prepared statements that failed, by statement. Finding no rows isn't a failure.",
+		}, []string{"statement"}),
+	}
+	m.registry.MustRegister(
+		m.requestDuration,
+		m.queryDuration,
+		m.queryErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(dbClient.DbConn.DB, "sqlite"),
+		&usersCollector{dbClient: dbClient, desc: prometheus.NewDesc("users", "Users across all tenants.", nil, nil)},
+	)
+	dbClient.AddQueryObserver(m)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a served request. route is the route template, e.g. "/v1.0/users/by_email/:email",
+// or UnmatchedRoute.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	m.requestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// ObserveQuery implements db.QueryObserver.
+func (m *Metrics) ObserveQuery(ctx context.Context, name, _ string) (context.Context, func(error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		m.queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			m.queryErrors.WithLabelValues(name).Inc()
+		}
+	}
+}
+
+// usersCollector counts users when scraped.
+type usersCollector struct {
+	dbClient *db.Client
+	desc     *prometheus.Desc
+}
+
+func (c *usersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *usersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), businessQueryTimeout)
+	defer cancel()
+	count, countErr := c.dbClient.CountAllUsers(ctx)
+	if countErr != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, countErr)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
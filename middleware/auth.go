@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/gin-gonic/gin"
+)
+
+// Authenticate requires an "Authorization: Bearer <token>" header that one of the
+// authenticators accepts, and stores the resulting auth.Principal on the context. Requests
+// ClientCertificate already authenticated pass through.
+func Authenticate(logger *slog.Logger, authenticators ...auth.Authenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := auth.GetPrincipal(ctx); ok {
+			ctx.Next()
+			return
+		}
+		scheme, token, found := strings.Cut(ctx.GetHeader("Authorization"), " ")
+		token = strings.TrimSpace(token)
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			unauthorized(ctx, "missing bearer token")
+			return
+		}
+		for _, authenticator := range authenticators {
+			principal, authErr := authenticator.Authenticate(ctx.Request.Context(), token)
+			if errors.Is(authErr, auth.ErrUnrecognized) {
+				continue
+			} else if errors.Is(authErr, auth.ErrInvalidCredential) {
+				unauthorized(ctx, "invalid bearer token")
+				return
+			} else if authErr != nil {
+				logging.FromContext(ctx.Request.Context(), logger).Error("Error authenticating request", slog.Any("error", authErr))
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+				return
+			}
+			auth.SetPrincipal(ctx, principal)
+			ctx.Next()
+			return
+		}
+		unauthorized(ctx, "invalid bearer token")
+	}
+}
+
+// ClientCertificate authenticates requests over mutual TLS that have no Authorization header by
+// their verified client certificate. Unknown or revoked certificates are rejected.
+func ClientCertificate(logger *slog.Logger, authenticator *auth.ClientCertAuthenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		connState := ctx.Request.TLS
+		if ctx.GetHeader("Authorization") != "" || connState == nil || len(connState.VerifiedChains) == 0 {
+			ctx.Next()
+			return
+		}
+		principal, authErr := authenticator.AuthenticateCertificate(ctx.Request.Context(), connState.VerifiedChains[0][0])
+		if errors.Is(authErr, auth.ErrInvalidCredential) {
+			unauthorized(ctx, "unknown client certificate")
+			return
+		} else if authErr != nil {
+			logging.FromContext(ctx.Request.Context(), logger).Error("Error authenticating client certificate", slog.Any("error", authErr))
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+			return
+		}
+		auth.SetPrincipal(ctx, principal)
+		ctx.Next()
+	}
+}
+
+// RequireScopes rejects requests whose principal lacks any of scopes. It must run after
+// Authenticate.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := auth.GetPrincipal(ctx)
+		if !ok {
+			unauthorized(ctx, "missing bearer token")
+			return
+		}
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, api.NewErrorMessage(ctx.Request.Context(), "missing scope "+scope))
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func unauthorized(ctx *gin.Context, message string) {
+	ctx.Header("WWW-Authenticate", `Bearer realm="gin-and-tonic"`)
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, api.NewErrorMessage(ctx.Request.Context(), message))
+}
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/brandonrachal/gin-and-tonic/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// ContractMode is what Contract does with requests or responses that don't match the OpenAPI
+// document.
+type ContractMode string
+
+const (
+	ContractOff ContractMode = "off"
+	// ContractLog logs a warning and carries on.
+	ContractLog ContractMode = "log"
+	// ContractEnforce rejects requests with 400 and replaces responses with 500. Responses are
+	// then buffered, so streamed ones arrive in one piece.
+	ContractEnforce ContractMode = "enforce"
+)
+
+func ParseContractMode(value string) (ContractMode, error) {
+	switch mode := ContractMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case ContractOff, ContractLog, ContractEnforce:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown contract mode %q", value)
+	}
+}
+
+// ContractMaxBodyBytes bounds the request bodies Contract reads and the response bodies it keeps
+// in ContractLog mode. Contract runs before authentication, so anyone can send it a body.
+const ContractMaxBodyBytes = 1 << 20
+
+type ContractConfig struct {
+	Requests  ContractMode
+	Responses ContractMode
+}
+
+// Enabled reports whether anything is checked.
+func (c ContractConfig) Enabled() bool {
+	return (c.Requests != "" && c.Requests != ContractOff) || (c.Responses != "" && c.Responses != ContractOff)
+}
+
+// Contract checks requests and responses of documented routes against document. Invalid
+// requests get a 400 problem listing every error. It must run before any middleware that can
+// respond, so their responses are checked too.
+func Contract(logger *slog.Logger, document *openapi.Document, config ContractConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		operation := document.Operation(ctx.Request.Method, ctx.FullPath())
+		if operation == nil {
+			ctx.Next()
+			return
+		}
+		log := logging.FromContext(ctx.Request.Context(), logger).With(slog.String("operation", operation.OperationId))
+		if config.Requests == ContractLog || config.Requests == ContractEnforce {
+			var body []byte
+			if ctx.Request.Body != nil {
+				var bodyErr error
+				if body, bodyErr = io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, ContractMaxBodyBytes)); bodyErr != nil {
+					var maxBytesErr *http.MaxBytesError
+					if errors.As(bodyErr, &maxBytesErr) {
+						ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, api.NewErrorMessage(ctx.Request.Context(), fmt.Sprintf("the request body is larger than %d bytes", maxBytesErr.Limit)))
+						return
+					}
+					log.Warn("Error reading the request body", slog.Any("error", bodyErr))
+					ctx.AbortWithStatusJSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), "couldn't read the request body"))
+					return
+				}
+				ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			pathParams := make(map[string]string, len(ctx.Params))
+			for _, param := range ctx.Params {
+				pathParams[param.Key] = param.Value
+			}
+			if errs := document.ValidateRequest(operation, ctx.Request, pathParams, body); len(errs) > 0 {
+				log.Warn("Request doesn't match the API document", slog.Any("errors", errs))
+				if config.Requests == ContractEnforce {
+					problem := api.NewProblem(ctx.Request.Context(), http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "the request doesn't match the API document")
+					problem.Errors = problemErrors(errs)
+					ctx.Header("Content-Type", ProblemContentType)
+					ctx.AbortWithStatusJSON(http.StatusBadRequest, problem)
+					return
+				}
+			}
+		}
+
+		switch config.Responses {
+		case ContractLog:
+			// Responses are only copied up to the limit, so large and streamed ones go unchecked
+			recorder := &cappedRecorder{ResponseWriter: ctx.Writer, limit: ContractMaxBodyBytes}
+			ctx.Writer = recorder
+			ctx.Next()
+			ctx.Writer = recorder.ResponseWriter
+			if recorder.truncated {
+				log.Debug("Response too large to check against the API document", slog.Int("size", recorder.Size()))
+			} else if errs := document.ValidateResponse(operation, recorder.Status(), recorder.Header(), recorder.body.Bytes()); len(errs) > 0 {
+				log.Warn("Response doesn't match the API document", slog.Int("status", recorder.Status()), slog.Any("errors", errs))
+			}
+		case ContractEnforce:
+			buffer := &bufferedWriter{ResponseWriter: ctx.Writer, status: http.StatusOK}
+			ctx.Writer = buffer
+			// A panic leaves the response to Recovery
+			defer func() {
+				ctx.Writer = buffer.ResponseWriter
+			}()
+			ctx.Next()
+			ctx.Writer = buffer.ResponseWriter
+			if errs := document.ValidateResponse(operation, buffer.status, buffer.Header(), buffer.body.Bytes()); len(errs) > 0 {
+				log.Error("Response doesn't match the API document", slog.Int("status", buffer.status), slog.Any("errors", errs))
+				problem := api.NewProblem(ctx.Request.Context(), http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "the response doesn't match the API document")
+				problem.Errors = problemErrors(errs)
+				ctx.Writer.Header().Del("Content-Length")
+				ctx.Header("Content-Type", ProblemContentType)
+				ctx.JSON(http.StatusInternalServerError, problem)
+				return
+			}
+			ctx.Writer.WriteHeader(buffer.status)
+			if buffer.body.Len() > 0 {
+				_, _ = ctx.Writer.Write(buffer.body.Bytes())
+			}
+		default:
+			ctx.Next()
+		}
+	}
+}
+
+func problemErrors(errs []openapi.ValidationError) []api.ProblemError {
+	problemErrs := make([]api.ProblemError, len(errs))
+	for i, err := range errs {
+		problemErrs[i] = api.ProblemError{Location: err.Location, Message: err.Message}
+	}
+	return problemErrs
+}
+
+// cappedRecorder keeps a copy of the response while it's at most limit bytes long.
+type cappedRecorder struct {
+	gin.ResponseWriter
+	limit     int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *cappedRecorder) record(size int) bool {
+	if !w.truncated && w.body.Len()+size > w.limit {
+		w.truncated = true
+		w.body = bytes.Buffer{}
+	}
+	return !w.truncated
+}
+
+func (w *cappedRecorder) Write(data []byte) (int, error) {
+	if w.record(len(data)) {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cappedRecorder) WriteString(s string) (int, error) {
+	if w.record(len(s)) {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// bufferedWriter holds the response back until the handlers are done.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *bufferedWriter) Flush() {}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+	maxIdempotencyKeyLength  = 255
+	idempotencySettleTimeout = 5 * time.Second
+)
+
+// IdempotencyStore persists Idempotency-Key reservations and responses. db.Client implements it.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl time.Duration) (*db.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, contentType string, body []byte) (sql.Result, error)
+	ReleaseIdempotencyKey(ctx context.Context, key string) (sql.Result, error)
+}
+
+// Idempotency makes POST requests carrying an Idempotency-Key header safe to retry. The first
+// request with a key runs normally and its response is stored for ttl. Retries with the same
+// method, path and body get the stored response back, retries with a different payload are
+// rejected with 422 and any request arriving while the first one is still running gets 409.
+// Server errors aren't stored, so the client can retry those for real.
+func Idempotency(logger *slog.Logger, store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+		if ctx.Request.Method != http.MethodPost || key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), "Idempotency-Key is too long"))
+			return
+		}
+		body, bodyErr := io.ReadAll(ctx.Request.Body)
+		if bodyErr != nil {
+			logging.FromContext(ctx.Request.Context(), logger).Warn("Error reading the request body", slog.Any("error", bodyErr))
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), "couldn't read the request body"))
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are only unique per caller and tenant, so two clients picking the same key don't
+		// collide and a platform key can't replay another tenant's response.
+		var subject string
+		if principal, ok := auth.GetPrincipal(ctx); ok {
+			subject = principal.Subject
+		}
+		var tenantId *int64
+		if id, ok := db.TenantFromContext(ctx.Request.Context()); ok {
+			tenantId = &id
+		}
+		key = ScopedIdempotencyKey(subject, tenantId, key)
+		fingerprint := requestFingerprint(ctx.Request, body)
+		record, reserved, reserveErr := store.ReserveIdempotencyKey(ctx.Request.Context(), key, fingerprint, ttl)
+		if reserveErr != nil {
+			logging.FromContext(ctx.Request.Context(), logger).Error("Error reserving idempotency key", slog.String("idempotency_key", key), slog.Any("error", reserveErr))
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+			return
+		}
+		if !reserved {
+			switch {
+			case record.InFlight():
+				ctx.AbortWithStatusJSON(http.StatusConflict, api.NewErrorMessage(ctx.Request.Context(), "a request with this Idempotency-Key is still in progress"))
+			case record.Fingerprint != fingerprint:
+				ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, api.NewErrorMessage(ctx.Request.Context(), "Idempotency-Key was already used with a different request"))
+			default:
+				contentType := "application/json; charset=utf-8"
+				if record.ContentType != nil {
+					contentType = *record.ContentType
+				}
+				ctx.Header(IdempotentReplayedHeader, "true")
+				ctx.Data(*record.StatusCode, contentType, record.ResponseBody)
+				ctx.Abort()
+			}
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+		defer func() {
+			// The request context may already be cancelled, but the key still has to be settled.
+			settleCtx, cancel := context.WithTimeout(context.Background(), idempotencySettleTimeout)
+			defer cancel()
+			if recovered := recover(); recovered != nil || recorder.Status() >= http.StatusInternalServerError {
+				if _, releaseErr := store.ReleaseIdempotencyKey(settleCtx, key); releaseErr != nil {
+					logging.FromContext(ctx.Request.Context(), logger).Error("Error releasing idempotency key", slog.String("idempotency_key", key), slog.Any("error", releaseErr))
+				}
+				if recovered != nil {
+					panic(recovered)
+				}
+				return
+			}
+			_, completeErr := store.CompleteIdempotencyKey(settleCtx, key, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+			if completeErr != nil {
+				logging.FromContext(ctx.Request.Context(), logger).Error("Error completing idempotency key", slog.String("idempotency_key", key), slog.Any("error", completeErr))
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// ScopedIdempotencyKey is the stored form of key sent by subject in the tenant, nil when the
+// request has none. Each part is prefixed with its length, so no other subject, tenant and key
+// add up to the same string.
+func ScopedIdempotencyKey(subject string, tenantId *int64, key string) string {
+	var tenant string
+	if tenantId != nil {
+		tenant = strconv.FormatInt(*tenantId, 10)
+	}
+	return fmt.Sprintf("%d:%s%d:%s%d:%s", len(subject), subject, len(tenant), tenant, len(key), key)
+}
+
+// requestFingerprint identifies the payload a key was first used with.
+func requestFingerprint(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.URL.Path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// bodyRecorder keeps a copy of everything written to the response.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
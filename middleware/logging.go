@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestLogger gives each request a logger carrying its request id, method and route, which
+// handlers get with logging.FromContext, and logs one line per request once it's served. It must
+// run after RequestId, and after Tracing for the lines to carry the trace id.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		requestId, _ := requestid.FromContext(ctx.Request.Context())
+		requestLogger := logger.With(
+			slog.String("request_id", requestId),
+			slog.String("method", ctx.Request.Method),
+			slog.String("route", route),
+		)
+		if spanContext := trace.SpanContextFromContext(ctx.Request.Context()); spanContext.IsValid() {
+			requestLogger = requestLogger.With(slog.String("trace_id", spanContext.TraceID().String()))
+		}
+		ctx.Request = ctx.Request.WithContext(logging.WithLogger(ctx.Request.Context(), requestLogger))
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		attrs := []slog.Attr{
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", max(0, ctx.Writer.Size())),
+			slog.String("client_ip", ctx.ClientIP()),
+		}
+		if principal, ok := auth.GetPrincipal(ctx); ok {
+			attrs = append(attrs, slog.String("subject", principal.Subject))
+		}
+		if len(ctx.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", ctx.Errors.String()))
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		requestLogger.LogAttrs(ctx.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns panics into 500 responses and logs them with the request's logger.
+func Recovery(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(ctx *gin.Context, recovered any) {
+		logging.FromContext(ctx.Request.Context(), logger).Error("Recovered from panic", slog.Any("panic", recovered), slog.String("stack", string(debug.Stack())))
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+	})
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records the duration and status of each request, labelled by route template.
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		route := ctx.FullPath()
+		if route == "" {
+			route = metrics.UnmatchedRoute
+		}
+		m.ObserveRequest(ctx.Request.Method, route, ctx.Writer.Status(), time.Since(start))
+	}
+}
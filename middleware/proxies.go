@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseTrustedProxies parses comma separated IPs and CIDRs, e.g. "10.0.0.0/8,192.0.2.1", for
+// gin's SetTrustedProxies. Only requests from these addresses may set the client IP through
+// X-Forwarded-For or X-Real-IP. Empty trusts no proxy.
+func ParseTrustedProxies(value string) ([]string, error) {
+	var proxies []string
+	for _, entry := range strings.Split(value, ",") {
+		proxy := strings.TrimSpace(entry)
+		if proxy == "" {
+			continue
+		}
+		if _, _, cidrErr := net.ParseCIDR(proxy); cidrErr != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP or CIDR", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/brandonrachal/gin-and-tonic/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	ProblemContentType       = "application/problem+json"
+)
+
+// IPRateLimit rejects requests over limiter's per IP limit with 429 Too Many Requests, before
+// anything else is done for them. It runs ahead of ClientCertificate and Authenticate so
+// requests with bad or no credentials are limited too.
+func IPRateLimit(logger *slog.Logger, limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		client := "ip:" + ctx.ClientIP()
+		decision, allowErr := limiter.AllowIP(ctx.Request.Context(), client)
+		applyDecision(ctx, logger, client, decision, allowErr)
+	}
+}
+
+// RateLimit rejects requests over limiter's limits with 429 Too Many Requests, and reports the
+// client's remaining allowance in RateLimit-* headers. With ratelimit.KeyByCredential it must
+// run after Authenticate, behind an IPRateLimit.
+func RateLimit(logger *slog.Logger, limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		client := "ip:" + ctx.ClientIP()
+		if principal, ok := auth.GetPrincipal(ctx); ok && limiter.KeyBy() == ratelimit.KeyByCredential {
+			client = principal.Subject
+		}
+		decision, allowErr := limiter.Allow(ctx.Request.Context(), client, ratelimit.RouteKey(ctx.Request.Method, ctx.FullPath()))
+		applyDecision(ctx, logger, client, decision, allowErr)
+	}
+}
+
+// applyDecision sets the RateLimit-* headers and continues the chain, or aborts it with 429.
+func applyDecision(ctx *gin.Context, logger *slog.Logger, client string, decision *ratelimit.Decision, allowErr error) {
+	if allowErr != nil {
+		logging.FromContext(ctx.Request.Context(), logger).Error("Error checking the rate limit", slog.String("client", client), slog.Any("error", allowErr))
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+		return
+	}
+	ctx.Header(RateLimitLimitHeader, strconv.Itoa(decision.Limit))
+	ctx.Header(RateLimitRemainingHeader, strconv.Itoa(decision.Remaining))
+	ctx.Header(RateLimitResetHeader, wholeSeconds(decision.Reset))
+	if decision.Allowed {
+		ctx.Next()
+		return
+	}
+	detail := "rate limit exceeded, retry in " + wholeSeconds(decision.RetryAfter) + " seconds"
+	if decision.QuotaExceeded {
+		detail = "daily quota of " + strconv.Itoa(decision.Limit) + " requests exceeded"
+	}
+	ctx.Header("Retry-After", wholeSeconds(decision.RetryAfter))
+	ctx.Header("Content-Type", ProblemContentType)
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, api.NewProblem(ctx.Request.Context(), http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), detail))
+}
+
+// wholeSeconds rounds up so clients never retry too early.
+func wholeSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
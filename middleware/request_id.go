@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIdKey is the gin context key holding the request id.
+const RequestIdKey = "request_id"
+
+// RequestId keeps the caller's X-Request-ID, or generates one, so a request can be found in our
+// logs from what the caller saw. The id is echoed in the response header and is on the request
+// context for logging, error bodies, db records and outbound calls, see package requestid.
+func RequestId() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		ctx.Set(RequestIdKey, id)
+		ctx.Request = ctx.Request.WithContext(requestid.WithId(ctx.Request.Context(), id))
+		ctx.Header(requestid.Header, id)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models/api"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantHeader selects an organization by slug.
+const TenantHeader = "X-Tenant"
+
+// TenantStore looks up organizations. db.Client implements it.
+type TenantStore interface {
+	GetOrganizationBySlug(ctx context.Context, slug string) (*db.Organization, error)
+}
+
+// Tenant scopes the request's database queries to one organization. The organization comes
+// from the X-Tenant header, else from the subdomain of baseDomain when that is set, else from
+// the credential. A credential bound to an organization can't select another one, and
+// platform credentials must select one. It must run after Authenticate.
+func Tenant(logger *slog.Logger, store TenantStore, baseDomain string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := auth.GetPrincipal(ctx)
+		if !ok {
+			unauthorized(ctx, "missing bearer token")
+			return
+		}
+		tenantId := principal.TenantId
+		if slug := requestedTenant(ctx.Request, baseDomain); slug != "" {
+			organization, organizationErr := store.GetOrganizationBySlug(ctx.Request.Context(), slug)
+			if errors.Is(organizationErr, sql.ErrNoRows) {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), "unknown tenant "+slug))
+				return
+			} else if organizationErr != nil {
+				logging.FromContext(ctx.Request.Context(), logger).Error("Error looking up tenant", slog.String("tenant", slug), slog.Any("error", organizationErr))
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, api.NewErrorMessage(ctx.Request.Context(), "something went wrong"))
+				return
+			}
+			if tenantId != 0 && tenantId != organization.Id {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, api.NewErrorMessage(ctx.Request.Context(), "credential is not valid for tenant "+slug))
+				return
+			}
+			tenantId = organization.Id
+		}
+		if tenantId == 0 {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, api.NewErrorMessage(ctx.Request.Context(), "a tenant is required, set the "+TenantHeader+" header"))
+			return
+		}
+		// Only the request context was handed to the database, so replacing the request is safe
+		ctx.Request = ctx.Request.WithContext(db.WithTenant(ctx.Request.Context(), tenantId))
+		ctx.Next()
+	}
+}
+
+func requestedTenant(req *http.Request, baseDomain string) string {
+	if slug := strings.TrimSpace(req.Header.Get(TenantHeader)); slug != "" {
+		return slug
+	}
+	if baseDomain == "" {
+		return ""
+	}
+	host := req.Host
+	if hostname, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = hostname
+	}
+	subdomain, found := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(baseDomain))
+	if !found || subdomain == "" || strings.Contains(subdomain, ".") {
+		return ""
+	}
+	return subdomain
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/brandonrachal/gin-and-tonic/auth"
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+	"github.com/brandonrachal/gin-and-tonic/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for each request, continuing the caller's trace when it sent a
+// traceparent header. The span is on the request context, so db spans become its children.
+// It must run after RequestId.
+func Tracing(provider trace.TracerProvider) gin.HandlerFunc {
+	tracer := tracing.Tracer(provider)
+	return func(ctx *gin.Context) {
+		parentCtx := tracing.Propagator.Extract(ctx.Request.Context(), propagation.HeaderCarrier(ctx.Request.Header))
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attributes := []attribute.KeyValue{
+			attribute.String("http.request.method", ctx.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("client.address", ctx.ClientIP()),
+			attribute.String("user_agent.original", ctx.Request.UserAgent()),
+		}
+		if requestId, ok := requestid.FromContext(ctx.Request.Context()); ok {
+			attributes = append(attributes, attribute.String("request.id", requestId))
+		}
+		spanCtx, span := tracer.Start(parentCtx, ctx.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attributes...),
+		)
+		defer span.End()
+		ctx.Request = ctx.Request.WithContext(spanCtx)
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if principal, ok := auth.GetPrincipal(ctx); ok {
+			span.SetAttributes(attribute.String("enduser.id", principal.Subject))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
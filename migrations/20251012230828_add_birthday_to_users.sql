@@ -1,4 +1,5 @@
 -- +goose Up
+-- lint:ignore not-null-without-default shipped before the linter, a fix has to be a new migration
 -- +goose StatementBegin
 alter table users add column birthday date not null;
 -- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists idempotency_keys (
+    idempotency_key varchar(255) primary key,
+    fingerprint char(64) not null,
+    status_code integer,
+    content_type varchar(255),
+    response_body blob,
+    created_at integer not null,
+    expires_at integer not null
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+create index if not exists idempotency_keys_expires_at on idempotency_keys(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table idempotency_keys;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists api_keys (
+    id integer primary key autoincrement,
+    name varchar(100) not null,
+    prefix varchar(16) unique not null,
+    key_hash char(64) not null,
+    scopes varchar(255) not null,
+    created_at integer not null,
+    last_used_at integer,
+    revoked_at integer
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table api_keys;
+-- +goose StatementEnd
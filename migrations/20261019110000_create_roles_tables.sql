@@ -0,0 +1,55 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists roles (
+    name varchar(50) primary key,
+    description varchar(255) not null
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+create table if not exists role_permissions (
+    role varchar(50) not null references roles(name) on delete cascade,
+    permission varchar(50) not null,
+    primary key (role, permission)
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into roles(name, description) values
+    ('admin', 'Full access to every user'),
+    ('support', 'Reads any user without personal data, can''t change or delete users'),
+    ('user', 'Reads and updates only their own user');
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into role_permissions(role, permission) values
+    ('admin', 'users:create'),
+    ('admin', 'users:read:any'),
+    ('admin', 'users:update:any'),
+    ('admin', 'users:delete:any'),
+    ('admin', 'users:list'),
+    ('admin', 'users:pii'),
+    ('admin', 'stats:read'),
+    ('support', 'users:read:any'),
+    ('support', 'users:list'),
+    ('support', 'stats:read'),
+    ('user', 'users:read:own'),
+    ('user', 'users:update:own');
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table api_keys add column role varchar(50) not null default 'admin';
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table api_keys add column user_id integer references users(id) on delete cascade;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table api_keys drop column user_id;
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table api_keys drop column role;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table role_permissions;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table roles;
+-- +goose StatementEnd
@@ -0,0 +1,115 @@
+-- +goose NO TRANSACTION
+-- Rebuilding users follows https://www.sqlite.org/lang_altertable.html#otheralter: with foreign
+-- keys on, dropping it would cascade to the api_keys bound to a user. The pragma only takes
+-- effect outside a transaction, hence NO TRANSACTION and the explicit one.
+
+-- +goose Up
+-- +goose StatementBegin
+pragma foreign_keys = off;
+-- +goose StatementEnd
+-- +goose StatementBegin
+begin;
+-- +goose StatementEnd
+-- +goose StatementBegin
+create table if not exists organizations (
+    id integer primary key autoincrement,
+    slug varchar(63) unique not null,
+    name varchar(100) not null,
+    created_at integer not null
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into organizations(id, slug, name, created_at) values (1, 'default', 'Default', strftime('%s', 'now'));
+-- +goose StatementEnd
+-- +goose StatementBegin
+create table users_by_tenant (
+    id integer primary key autoincrement,
+    tenant_id integer not null default 1 references organizations(id),
+    first_name varchar(100) not null,
+    last_name varchar(100) not null,
+    email varchar(100) not null,
+    birthday date not null,
+    unique (tenant_id, email)
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into users_by_tenant(id, tenant_id, first_name, last_name, email, birthday)
+select id, 1, first_name, last_name, email, birthday from users;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table users;
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table users_by_tenant rename to users;
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table api_keys add column tenant_id integer references organizations(id);
+-- +goose StatementEnd
+-- +goose StatementBegin
+update api_keys set tenant_id = 1;
+-- +goose StatementEnd
+-- foreign_key_check only lists violations, and this column only takes zero of them
+-- +goose StatementBegin
+create temp table foreign_key_violations (violations integer check (violations = 0));
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into foreign_key_violations select count(*) from pragma_foreign_key_check;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table foreign_key_violations;
+-- +goose StatementEnd
+-- +goose StatementBegin
+commit;
+-- +goose StatementEnd
+-- +goose StatementBegin
+pragma foreign_keys = on;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+pragma foreign_keys = off;
+-- +goose StatementEnd
+-- +goose StatementBegin
+begin;
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table api_keys drop column tenant_id;
+-- +goose StatementEnd
+-- +goose StatementBegin
+create table users_global (
+    id integer primary key autoincrement,
+    first_name varchar(100) not null,
+    last_name varchar(100) not null,
+    email varchar(100) unique not null,
+    birthday date not null
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into users_global(id, first_name, last_name, email, birthday)
+select id, first_name, last_name, email, birthday from users;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table users;
+-- +goose StatementEnd
+-- +goose StatementBegin
+alter table users_global rename to users;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table organizations;
+-- +goose StatementEnd
+-- foreign_key_check only lists violations, and this column only takes zero of them
+-- +goose StatementBegin
+create temp table foreign_key_violations (violations integer check (violations = 0));
+-- +goose StatementEnd
+-- +goose StatementBegin
+insert into foreign_key_violations select count(*) from pragma_foreign_key_check;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table foreign_key_violations;
+-- +goose StatementEnd
+-- +goose StatementBegin
+commit;
+-- +goose StatementEnd
+-- +goose StatementBegin
+pragma foreign_keys = on;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists rate_limit_buckets (
+    bucket_key varchar(255) primary key,
+    tokens real not null,
+    updated_at real not null
+);
+-- +goose StatementEnd
+-- +goose StatementBegin
+create table if not exists rate_limit_quotas (
+    quota_key varchar(255) not null,
+    day char(10) not null,
+    used integer not null,
+    primary key (quota_key, day)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table rate_limit_quotas;
+-- +goose StatementEnd
+-- +goose StatementBegin
+drop table rate_limit_buckets;
+-- +goose StatementEnd
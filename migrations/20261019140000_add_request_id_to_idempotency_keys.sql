@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table idempotency_keys add column request_id varchar(128);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table idempotency_keys drop column request_id;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists client_certificates (
+    id integer primary key autoincrement,
+    subject varchar(255) unique not null,
+    scopes varchar(255) not null,
+    role varchar(50) not null references roles(name),
+    user_id integer references users(id) on delete cascade,
+    tenant_id integer references organizations(id),
+    created_at integer not null,
+    revoked_at integer
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table client_certificates;
+-- +goose StatementEnd
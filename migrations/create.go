@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// versionLayout is the UTC timestamp the migration versions are written as.
+const versionLayout = "20060102150405"
+
+const template = `-- +goose Up
+-- +goose StatementBegin
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- +goose StatementEnd
+`
+
+// Create writes an empty migration called name to dir and returns its path. Its version is
+// the time now, or just after the newest migration in dir when that one is ahead of the clock,
+// so the new migration always runs last.
+func Create(dir, name string, now time.Time) (string, error) {
+	slug := snakeCase(name)
+	if slug == "" {
+		return "", errors.New("the migration name needs a letter or a digit")
+	}
+	version, versionErr := strconv.ParseInt(now.UTC().Format(versionLayout), 10, 64)
+	if versionErr != nil {
+		return "", versionErr
+	}
+	files, filesErr := parseNames(os.DirFS(dir))
+	if filesErr != nil {
+		return "", filesErr
+	}
+	for _, file := range files {
+		version = max(version, file.Version+1)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d_%s.sql", version, slug))
+	file, fileErr := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if fileErr != nil {
+		return "", fileErr
+	}
+	if _, err := file.WriteString(template); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// snakeCase turns "Add email to users" into add_email_to_users.
+func snakeCase(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "_")
+}
+
+// parseNames lists the migration files at the root of fsys, oldest first. Files with a .sql
+// extension must be named <version>_<description>.sql.
+func parseNames(fsys fs.FS) ([]Migration, error) {
+	names, namesErr := fs.Glob(fsys, "*.sql")
+	if namesErr != nil {
+		return nil, namesErr
+	}
+	var files []Migration
+	var errs []error
+	for _, name := range names {
+		prefix, _, found := strings.Cut(name, "_")
+		version, versionErr := strconv.ParseInt(prefix, 10, 64)
+		if !found || versionErr != nil || version < 1 {
+			errs = append(errs, fmt.Errorf("%s: the name must be <version>_<description>.sql", name))
+			continue
+		}
+		files = append(files, Migration{Version: version, Name: name})
+	}
+	slices.SortFunc(files, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return files, errors.Join(errs...)
+}
@@ -0,0 +1,143 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mode is what Guard does when migrations are pending.
+type Mode string
+
+const (
+	// Refuse fails, so the server doesn't start against an old schema.
+	Refuse Mode = "refuse"
+	// Auto applies the pending migrations, one process at a time.
+	Auto Mode = "auto"
+	// Warn logs the pending migrations and starts on the old schema. The server then needs
+	// db.NewLazyClient, so only the requests using what the migrations add fail.
+	Warn Mode = "warn"
+)
+
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(mode))) {
+	case Refuse:
+		return Refuse, nil
+	case Auto:
+		return Auto, nil
+	case Warn:
+		return Warn, nil
+	}
+	return "", fmt.Errorf("unknown migration mode %q", mode)
+}
+
+// lockRetryInterval is how often Guard tries to take the lock another process holds.
+const lockRetryInterval = 100 * time.Millisecond
+
+// PendingError is the schema of a database missing migrations.
+type PendingError struct {
+	Version int64
+	Pending []Migration
+}
+
+func (e *PendingError) Error() string {
+	names := make([]string, len(e.Pending))
+	for i, migration := range e.Pending {
+		names[i] = migration.Name
+	}
+	return fmt.Sprintf("the database is at version %d and %d migration(s) are pending: %s", e.Version, len(e.Pending), strings.Join(names, ", "))
+}
+
+// Guard checks the database file at path has every migration applied before anything
+// prepares statements against it, and handles pending ones according to mode. With Auto, the
+// processes starting at the same time take turns through a lock file next to the database, so
+// only the first one migrates.
+func Guard(ctx context.Context, logger *slog.Logger, path, table string, mode Mode) error {
+	if mode == Auto {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		lockPath := path + ".lock"
+		unlock, lockErr := lockFile(ctx, logger, lockPath)
+		if lockErr != nil {
+			return fmt.Errorf("locking %s - %w", lockPath, lockErr)
+		}
+		defer unlock()
+	} else if _, statErr := os.Stat(path); statErr != nil {
+		// Opening it would create an empty database
+		return fmt.Errorf("checking the database file - %w", statErr)
+	}
+	client, clientErr := NewClient(path, table)
+	if clientErr != nil {
+		return clientErr
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	version, pending, statusErr := status(ctx, client)
+	if statusErr != nil {
+		return statusErr
+	}
+	latest, latestErr := LatestVersion(FS)
+	if latestErr != nil {
+		return latestErr
+	}
+	if version > latest {
+		logger.Warn("The database has migrations this build doesn't know about", slog.Int64("version", version), slog.Int64("latest_version", latest))
+	}
+	if len(pending) == 0 {
+		logger.Info("The database schema is up to date", slog.Int64("version", version))
+		return nil
+	}
+	pendingErr := &PendingError{Version: version, Pending: pending}
+	for _, migration := range pending {
+		if migration.Version < version {
+			logger.Warn("A migration older than the database version is pending and has to be applied out of order", slog.String("migration", migration.Name), slog.Int64("version", version))
+		}
+	}
+	switch mode {
+	case Auto:
+		return migrate(ctx, logger, client)
+	case Warn:
+		for _, migration := range pending {
+			logger.Warn("Starting with a migration pending, requests needing it will fail", slog.String("migration", migration.Name), slog.Int64("version", version))
+		}
+		return nil
+	}
+	return pendingErr
+}
+
+func migrate(ctx context.Context, logger *slog.Logger, client *Client) error {
+	applied, upErr := client.Up(ctx)
+	for _, migration := range applied {
+		logger.Info("Applied a migration", slog.String("migration", migration.Name))
+	}
+	if upErr != nil {
+		return fmt.Errorf("applying the migrations - %w", upErr)
+	}
+	version, versionErr := client.Version(ctx)
+	if versionErr != nil {
+		return versionErr
+	}
+	logger.Info("The database schema is up to date", slog.Int64("version", version), slog.Int("applied", len(applied)))
+	return nil
+}
+
+func status(ctx context.Context, client *Client) (int64, []Migration, error) {
+	version, versionErr := client.Version(ctx)
+	if versionErr != nil {
+		return 0, nil, fmt.Errorf("reading the schema version - %w", versionErr)
+	}
+	pending, pendingErr := client.Pending(ctx)
+	if pendingErr != nil {
+		return 0, nil, fmt.Errorf("listing the pending migrations - %w", pendingErr)
+	}
+	return version, pending, nil
+}
+
+var errLockUnsupported = errors.New("file locks aren't supported on this platform, run migration_client up-all instead")
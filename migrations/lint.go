@@ -0,0 +1,506 @@
+package migrations
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Rule is a kind of unsafe change the linter finds.
+type Rule string
+
+const (
+	// NotNullWithoutDefault is a not null column added without a default, which fails on a
+	// table with rows.
+	NotNullWithoutDefault Rule = "not-null-without-default"
+	// DropColumn loses the data of the column and breaks the servers still reading it.
+	DropColumn Rule = "drop-column"
+	// DropTable loses the data of the table. Rebuilding a table, dropping it and renaming a copy
+	// to its name, isn't reported.
+	DropTable Rule = "drop-table"
+	// MissingDown is a migration without a Down section, or with an empty one.
+	MissingDown Rule = "missing-down"
+	// IrreversibleDown is a Down section that doesn't undo what the Up section creates or drops.
+	IrreversibleDown Rule = "irreversible-down"
+	// UnknownRule is a suppression comment naming a rule that doesn't exist.
+	UnknownRule Rule = "unknown-rule"
+)
+
+// Rules lists the rules a suppression comment can name.
+var Rules = []Rule{NotNullWithoutDefault, DropColumn, DropTable, MissingDown, IrreversibleDown}
+
+// Finding is an unsafe change in a migration.
+type Finding struct {
+	File string
+	Line int
+	Rule Rule
+	// Message explains the problem.
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.File, f.Line, f.Message, f.Rule)
+}
+
+// Lint checks every migration file at the root of fsys for unsafe changes. The error is for
+// files that can't be read or parsed.
+func Lint(fsys fs.FS) ([]Finding, error) {
+	files, namesErr := parseNames(fsys)
+	errs := []error{namesErr}
+	var findings []Finding
+	for _, file := range files {
+		data, dataErr := fs.ReadFile(fsys, file.Name)
+		if dataErr != nil {
+			errs = append(errs, dataErr)
+			continue
+		}
+		fileFindings, lintErr := LintFile(file.Name, data)
+		if lintErr != nil {
+			errs = append(errs, lintErr)
+		}
+		findings = append(findings, fileFindings...)
+	}
+	return findings, errors.Join(errs...)
+}
+
+// suppressionPattern matches "-- lint:ignore <rule>[,<rule>...] <reason>".
+var suppressionPattern = regexp.MustCompile(`--\s*lint:ignore\s+([\w,-]+)`)
+
+// LintFile checks the migration called name. A finding is suppressed by a
+// "-- lint:ignore <rule>[,<rule>...] <reason>" comment at the end of the line it is reported
+// on, or in the comment lines just above it.
+func LintFile(name string, data []byte) ([]Finding, error) {
+	file, hasDown, parseErr := parse(data)
+	if parseErr != nil {
+		return nil, fmt.Errorf("%s: %w", name, parseErr)
+	}
+	l := &linter{name: name}
+	up, down := changesOf(file.Up), changesOf(file.Down)
+	l.checkUp(up)
+	switch upLine := firstLine(file.Up); {
+	case !hasDown:
+		l.report(upLine, MissingDown, "there is no Down section, so the migration can't be rolled back")
+	case len(file.Down) == 0 && len(file.Up) > 0:
+		l.report(upLine, MissingDown, "the Down section is empty, so rolling back leaves the Up changes in place")
+	default:
+		l.checkDown(up, down)
+	}
+	return l.filter(data), nil
+}
+
+type linter struct {
+	name     string
+	findings []Finding
+}
+
+func (l *linter) report(line int, rule Rule, format string, args ...any) {
+	l.findings = append(l.findings, Finding{File: l.name, Line: line, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkUp reports the destructive changes.
+func (l *linter) checkUp(up []change) {
+	for i, c := range up {
+		switch c.kind {
+		case addColumn:
+			if c.notNull && !c.hasDefault {
+				l.report(c.line, NotNullWithoutDefault, "adding not null column %s without a default fails on a table with rows", c.object.name)
+			}
+		case dropColumn:
+			l.report(c.line, DropColumn, "dropping column %s loses its data and breaks the servers still reading it", c.object.name)
+		case dropTable:
+			// Tables created earlier in the section are scratch tables, and tables created later
+			// replace the dropped one
+			created := func(other change) bool {
+				return (other.kind == createTable || other.kind == renameTable) && other.object == c.object
+			}
+			if !slices.ContainsFunc(up[:i], created) && !slices.ContainsFunc(up[i+1:], created) {
+				l.report(c.line, DropTable, "dropping table %s loses its data", c.object.name)
+			}
+		}
+	}
+}
+
+// checkDown reports what the Down section leaves of the Up changes.
+func (l *linter) checkDown(up, down []change) {
+	upEffect, downEffect := effectOf(up), effectOf(down)
+	for _, o := range sortedObjects(upEffect.created) {
+		if !downEffect.removes(o, upEffect.tableOf(o)) {
+			l.report(upEffect.created[o], IrreversibleDown, "the Down section doesn't drop %s %s", o.kind, o.name)
+		}
+	}
+	for _, o := range sortedObjects(upEffect.dropped) {
+		if !downEffect.restores(o, upEffect.tableOf(o)) {
+			l.report(upEffect.dropped[o], IrreversibleDown, "the Down section doesn't recreate %s %s", o.kind, o.name)
+		}
+	}
+}
+
+// filter drops the suppressed findings and reports suppressions of unknown rules.
+func (l *linter) filter(data []byte) []Finding {
+	var (
+		lines      []string
+		directives = map[int][]Rule{}
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+		match := suppressionPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		for _, rule := range strings.Split(match[1], ",") {
+			if rule := Rule(rule); slices.Contains(Rules, rule) {
+				directives[len(lines)] = append(directives[len(lines)], rule)
+			} else if rule != "" {
+				l.report(len(lines), UnknownRule, "lint:ignore names unknown rule %q", rule)
+			}
+		}
+	}
+	suppressed := func(finding Finding) bool {
+		if slices.Contains(directives[finding.Line], finding.Rule) {
+			return true
+		}
+		for line := finding.Line - 1; line >= 1 && strings.HasPrefix(lines[line-1], "--"); line-- {
+			if slices.Contains(directives[line], finding.Rule) {
+				return true
+			}
+		}
+		return false
+	}
+	findings := slices.DeleteFunc(l.findings, suppressed)
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+	return findings
+}
+
+func firstLine(statements []Statement) int {
+	if len(statements) == 0 {
+		return 1
+	}
+	return statements[0].Line
+}
+
+type changeKind int
+
+const (
+	createTable changeKind = iota + 1
+	dropTable
+	addColumn
+	dropColumn
+	createIndex
+	dropIndex
+	renameTable
+	renameColumn
+)
+
+// object is a table, a column, named <table>.<column>, or an index.
+type object struct {
+	kind string
+	name string
+}
+
+// change is a schema change made by a statement.
+type change struct {
+	kind   changeKind
+	object object
+	// from is the old name of a renamed object.
+	from object
+	// table is the table of a column or an index.
+	table      string
+	line       int
+	notNull    bool
+	hasDefault bool
+}
+
+// changesOf lists the schema changes of the statements, ignoring the statements that don't
+// change the schema.
+func changesOf(statements []Statement) []change {
+	var changes []change
+	for _, statement := range statements {
+		for _, clause := range splitClauses(tokenize(statement.SQL, statement.Line)) {
+			if c, ok := clauseChange(clause); ok {
+				changes = append(changes, c)
+			}
+		}
+	}
+	return changes
+}
+
+func clauseChange(clause []token) (change, bool) {
+	c := &cursor{tokens: clause}
+	line := clause[0].line
+	table := func(name string) object { return object{kind: "table", name: name} }
+	column := func(table, name string) object { return object{kind: "column", name: table + "." + name} }
+	index := func(name string) object { return object{kind: "index", name: name} }
+	switch {
+	case c.accept("create"):
+		if !c.accept("temp") {
+			c.accept("temporary")
+		}
+		if c.accept("table") {
+			c.accept("if", "not", "exists")
+			return change{kind: createTable, object: table(c.name()), line: line}, true
+		}
+		c.accept("unique")
+		if c.accept("index") {
+			c.accept("if", "not", "exists")
+			name := index(c.name())
+			c.accept("on")
+			return change{kind: createIndex, object: name, table: c.name(), line: line}, true
+		}
+	case c.accept("drop"):
+		if c.accept("table") {
+			c.accept("if", "exists")
+			return change{kind: dropTable, object: table(c.name()), line: line}, true
+		} else if c.accept("index") {
+			c.accept("if", "exists")
+			return change{kind: dropIndex, object: index(c.name()), line: line}, true
+		}
+	case c.accept("alter", "table"):
+		name := c.name()
+		switch {
+		case c.accept("rename", "to"):
+			return change{kind: renameTable, from: table(name), object: table(c.name()), line: line}, true
+		case c.accept("rename"):
+			c.accept("column")
+			from := c.name()
+			c.accept("to")
+			return change{kind: renameColumn, from: column(name, from), object: column(name, c.name()), table: name, line: line}, true
+		case c.accept("add"):
+			c.accept("column")
+			added := change{kind: addColumn, object: column(name, c.name()), table: name, line: line}
+			added.notNull = c.contains("not", "null")
+			added.hasDefault = c.contains("default")
+			return added, true
+		case c.accept("drop"):
+			c.accept("column")
+			return change{kind: dropColumn, object: column(name, c.name()), table: name, line: line}, true
+		}
+	}
+	return change{}, false
+}
+
+// effect is the net change of a section: what exists after it that didn't before, and the
+// other way around, with the lines responsible. An object both dropped and created was
+// replaced.
+type effect struct {
+	created map[object]int
+	dropped map[object]int
+	// tables maps the columns and indexes to their table.
+	tables map[object]string
+}
+
+func effectOf(changes []change) *effect {
+	e := &effect{created: map[object]int{}, dropped: map[object]int{}, tables: map[object]string{}}
+	for _, c := range changes {
+		if c.table != "" {
+			e.tables[c.object] = c.table
+		}
+		switch c.kind {
+		case createTable, addColumn, createIndex:
+			e.create(c.object, c.line)
+		case dropTable, dropColumn, dropIndex:
+			e.drop(c.object, c.line)
+		case renameTable:
+			e.drop(c.from, c.line)
+			e.create(c.object, c.line)
+			// The indexes move with the table
+			for o, table := range e.tables {
+				if table == c.from.name {
+					e.tables[o] = c.object.name
+				}
+			}
+		case renameColumn:
+			e.drop(c.from, c.line)
+			e.create(c.object, c.line)
+		}
+	}
+	return e
+}
+
+func (e *effect) create(o object, line int) {
+	e.created[o] = line
+}
+
+func (e *effect) drop(o object, line int) {
+	if _, found := e.created[o]; found {
+		delete(e.created, o)
+	} else {
+		e.dropped[o] = line
+	}
+}
+
+// removes reports whether the section drops o, or table, the table of o when it is a column
+// or an index.
+func (e *effect) removes(o object, table string) bool {
+	_, found := e.dropped[o]
+	_, tableFound := e.dropped[object{kind: "table", name: table}]
+	return found || tableFound
+}
+
+// restores reports whether the section creates o, or rebuilds table, the table of o when it
+// is a column or an index.
+func (e *effect) restores(o object, table string) bool {
+	_, found := e.created[o]
+	_, tableFound := e.created[object{kind: "table", name: table}]
+	return found || tableFound
+}
+
+// tableOf returns the table of a column or an index o, "" for a table.
+func (e *effect) tableOf(o object) string {
+	switch o.kind {
+	case "column":
+		table, _, _ := strings.Cut(o.name, ".")
+		return table
+	case "index":
+		return e.tables[o]
+	}
+	return ""
+}
+
+func sortedObjects(objects map[object]int) []object {
+	sorted := make([]object, 0, len(objects))
+	for o := range objects {
+		sorted = append(sorted, o)
+	}
+	slices.SortFunc(sorted, func(a, b object) int {
+		return cmp.Or(cmp.Compare(objects[a], objects[b]), cmp.Compare(a.kind, b.kind), cmp.Compare(a.name, b.name))
+	})
+	return sorted
+}
+
+// token is a word, a quoted identifier, a string literal, written ', or a punctuation
+// character of a statement, lower cased. Comments and white space aren't tokens.
+type token struct {
+	text string
+	line int
+}
+
+func tokenize(sql string, line int) []token {
+	var tokens []token
+	runes := []rune(sql)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line++
+		case unicode.IsSpace(r):
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			for i += 2; i < len(runes) && !(runes[i-1] == '*' && runes[i] == '/'); i++ {
+				if runes[i] == '\n' {
+					line++
+				}
+			}
+		case r == '\'' || r == '"' || r == '`' || r == '[':
+			closing, start := r, line
+			if r == '[' {
+				closing = ']'
+			}
+			var text strings.Builder
+			for i++; i < len(runes); i++ {
+				if runes[i] == closing {
+					// A doubled quote is an escaped one
+					if i+1 < len(runes) && runes[i+1] == closing && closing != ']' {
+						i++
+					} else {
+						break
+					}
+				} else if runes[i] == '\n' {
+					line++
+				}
+				text.WriteRune(runes[i])
+			}
+			if r == '\'' {
+				tokens = append(tokens, token{text: "'", line: start})
+			} else {
+				tokens = append(tokens, token{text: strings.ToLower(text.String()), line: start})
+			}
+		case isWordRune(r):
+			start := i
+			for i+1 < len(runes) && isWordRune(runes[i+1]) {
+				i++
+			}
+			tokens = append(tokens, token{text: strings.ToLower(string(runes[start : i+1])), line: line})
+		default:
+			tokens = append(tokens, token{text: string(r), line: line})
+		}
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '$' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// splitClauses splits the tokens of a statement at its semicolons, as a StatementBegin block
+// can hold several.
+func splitClauses(tokens []token) [][]token {
+	var clauses [][]token
+	for len(tokens) > 0 {
+		end := slices.IndexFunc(tokens, func(t token) bool { return t.text == ";" })
+		if end < 0 {
+			end = len(tokens)
+		}
+		if end > 0 {
+			clauses = append(clauses, tokens[:end])
+		}
+		tokens = tokens[min(end+1, len(tokens)):]
+	}
+	return clauses
+}
+
+// cursor reads the tokens of a clause.
+type cursor struct {
+	tokens []token
+	pos    int
+}
+
+// accept moves past words when the next tokens are them.
+func (c *cursor) accept(words ...string) bool {
+	if c.pos+len(words) > len(c.tokens) {
+		return false
+	}
+	for i, word := range words {
+		if c.tokens[c.pos+i].text != word {
+			return false
+		}
+	}
+	c.pos += len(words)
+	return true
+}
+
+// name returns the next token, a name, without a main. or temp. schema.
+func (c *cursor) name() string {
+	if c.pos >= len(c.tokens) {
+		return ""
+	}
+	c.pos++
+	name := c.tokens[c.pos-1].text
+	for _, schema := range []string{"main.", "temp."} {
+		name = strings.TrimPrefix(name, schema)
+	}
+	return name
+}
+
+// contains reports whether words follow each other in the rest of the clause.
+func (c *cursor) contains(words ...string) bool {
+	for start := c.pos; start+len(words) <= len(c.tokens); start++ {
+		if (&cursor{tokens: c.tokens, pos: start}).accept(words...) {
+			return true
+		}
+	}
+	return false
+}
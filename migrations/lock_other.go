@@ -0,0 +1,12 @@
+//go:build !unix
+
+package migrations
+
+import (
+	"context"
+	"log/slog"
+)
+
+func lockFile(context.Context, *slog.Logger, string) (func(), error) {
+	return nil, errLockUnsupported
+}
@@ -0,0 +1,43 @@
+//go:build unix
+
+package migrations
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockFile takes an exclusive lock on the file at path, creating it, and waits for the process
+// holding it until ctx is done. The lock goes away with the process if unlock is never called.
+func lockFile(ctx context.Context, logger *slog.Logger, path string) (func(), error) {
+	file, fileErr := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if fileErr != nil {
+		return nil, fileErr
+	}
+	for waited := false; ; waited = true {
+		lockErr := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if lockErr == nil {
+			break
+		} else if !errors.Is(lockErr, syscall.EWOULDBLOCK) {
+			_ = file.Close()
+			return nil, lockErr
+		}
+		if !waited {
+			logger.Info("Waiting for another process to finish migrating", slog.String("lock", path))
+		}
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
@@ -0,0 +1,146 @@
+// Package migrations holds the goose migration files, embedded so the programs don't need the
+// source tree, and runs them against a database file.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"io/fs"
+	"path"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+)
+
+// FS holds the migration files at its root, named <version>_<description>.sql.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// Client applies the migrations of FS to one database.
+type Client struct {
+	provider *goose.Provider
+}
+
+// NewClient opens the sqlite database file at path, creating it when it doesn't exist, with
+// goose recording the applied migrations in table.
+func NewClient(path, table string) (*Client, error) {
+	dbConn, dbConnErr := sql.Open("sqlite3", path)
+	if dbConnErr != nil {
+		return nil, dbConnErr
+	}
+	provider, providerErr := goose.NewProvider(goose.DialectSQLite3, dbConn, FS, goose.WithTableName(table))
+	if providerErr != nil {
+		_ = dbConn.Close()
+		return nil, providerErr
+	}
+	return &Client{provider: provider}, nil
+}
+
+// Migration is one of the migration files.
+type Migration struct {
+	Version int64
+	// Name is the file name, e.g. 20251012230828_add_birthday_to_users.sql.
+	Name string
+}
+
+func (m Migration) String() string {
+	return m.Name
+}
+
+// UpAll applies every pending migration.
+func (c *Client) UpAll(ctx context.Context) error {
+	_, err := c.Up(ctx)
+	return err
+}
+
+// Up applies every pending migration and returns those that were, also when one of them
+// failed.
+func (c *Client) Up(ctx context.Context) ([]Migration, error) {
+	results, err := c.provider.Up(ctx)
+	var partialErr *goose.PartialError
+	if errors.As(err, &partialErr) {
+		results = partialErr.Applied
+	}
+	applied := make([]Migration, len(results))
+	for i, result := range results {
+		applied[i] = newMigration(result.Source)
+	}
+	return applied, err
+}
+
+// Pending returns the migrations that haven't been applied, oldest first.
+func (c *Client) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, statusesErr := c.provider.Status(ctx)
+	if statusesErr != nil {
+		return nil, statusesErr
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if status.State == goose.StatePending {
+			pending = append(pending, newMigration(status.Source))
+		}
+	}
+	return pending, nil
+}
+
+// Status is a migration and whether it has been applied.
+type Status struct {
+	Migration
+	// AppliedAt is zero for a pending migration.
+	AppliedAt time.Time
+}
+
+// Applied reports whether the migration has been applied.
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// Status returns every migration, oldest first, with when it was applied.
+func (c *Client) Status(ctx context.Context) ([]Status, error) {
+	statuses, statusesErr := c.provider.Status(ctx)
+	if statusesErr != nil {
+		return nil, statusesErr
+	}
+	result := make([]Status, len(statuses))
+	for i, status := range statuses {
+		result[i] = Status{Migration: newMigration(status.Source)}
+		if status.State == goose.StateApplied {
+			result[i].AppliedAt = status.AppliedAt
+		}
+	}
+	return result, nil
+}
+
+// LatestVersion returns the version of the newest migration file at the root of fsys.
+// Badly named files are left to Validate.
+func LatestVersion(fsys fs.FS) (int64, error) {
+	files, _ := parseNames(fsys)
+	if len(files) == 0 {
+		return 0, errors.New("no migration files found")
+	}
+	return files[len(files)-1].Version, nil
+}
+
+func newMigration(source *goose.Source) Migration {
+	return Migration{Version: source.Version, Name: path.Base(source.Path)}
+}
+
+// Reset rolls back every applied migration.
+func (c *Client) Reset(ctx context.Context) error {
+	_, err := c.provider.DownTo(ctx, 0)
+	return err
+}
+
+// Version returns the version of the latest applied migration, 0 when none is.
+func (c *Client) Version(ctx context.Context) (int64, error) {
+	return c.provider.GetDBVersion(ctx)
+}
+
+// Close closes the database.
+func (c *Client) Close() error {
+	return c.provider.Close()
+}
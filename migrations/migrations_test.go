@@ -0,0 +1,386 @@
+package migrations_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/migrations"
+	"github.com/brandonrachal/gin-and-tonic/migrations/migrationstest"
+	"github.com/pressly/goose/v3"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	table = "goose_migrations"
+	// birthdayVersion is an old schema, missing the migrations after it.
+	birthdayVersion int64 = 20251012230828
+)
+
+func TestLatestVersion(t *testing.T) {
+	r := require.New(t)
+	version, err := migrations.LatestVersion(fstest.MapFS{
+		"20251012000000_create_users_table.sql":  {},
+		"20261019140000_add_request_id.sql":      {},
+		"20261019130000_create_rate_limits.sql":  {},
+		"README.md":                              {},
+		"notes_20991231000000.sql":               {},
+		"backup/20991231000000_backup_table.sql": {},
+	})
+	r.NoError(err)
+	r.Equal(int64(20261019140000), version)
+
+	_, err = migrations.LatestVersion(fstest.MapFS{})
+	r.Error(err)
+}
+
+func TestClient(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	latest, latestErr := migrations.LatestVersion(migrations.FS)
+	r.NoError(latestErr)
+
+	client, clientErr := migrations.NewClient(filepath.Join(t.TempDir(), "migrations.db"), table)
+	r.NoError(clientErr)
+	defer func() {
+		r.NoError(client.Close())
+	}()
+	version, versionErr := client.Version(ctx)
+	r.NoError(versionErr)
+	r.Zero(version)
+
+	r.NoError(client.UpAll(ctx))
+	version, versionErr = client.Version(ctx)
+	r.NoError(versionErr)
+	r.Equal(latest, version)
+	statuses, statusesErr := client.Status(ctx)
+	r.NoError(statusesErr)
+	r.Equal("20250930193101_create_user_table.sql", statuses[0].Name)
+	r.Equal(latest, statuses[len(statuses)-1].Version)
+	for _, status := range statuses {
+		r.True(status.Applied(), status.Name)
+	}
+
+	r.NoError(client.Reset(ctx))
+	version, versionErr = client.Version(ctx)
+	r.NoError(versionErr)
+	r.Zero(version)
+}
+
+// migratedTo returns a database file with the migrations up to version applied.
+func migratedTo(t *testing.T, version int64) string {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "guard.db")
+	dbConn, dbConnErr := sql.Open("sqlite3", path)
+	r.NoError(dbConnErr)
+	provider, providerErr := goose.NewProvider(goose.DialectSQLite3, dbConn, migrations.FS, goose.WithTableName(table))
+	r.NoError(providerErr)
+	_, upErr := provider.UpTo(context.Background(), version)
+	r.NoError(upErr)
+	r.NoError(provider.Close())
+	return path
+}
+
+// Rebuilding users for tenants must leave the api_keys bound to a user, which cascade when the
+// user is deleted, in place.
+func TestOrganizationsKeepUserKeys(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const rolesVersion, organizationsVersion int64 = 20261019110000, 20261019120000
+	dbConn, dbConnErr := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "keys.db")+"?_foreign_keys=on")
+	r.NoError(dbConnErr)
+	// One connection, so the pragma read below is on the one the migrations ran on
+	dbConn.SetMaxOpenConns(1)
+	provider, providerErr := goose.NewProvider(goose.DialectSQLite3, dbConn, migrations.FS, goose.WithTableName(table))
+	r.NoError(providerErr)
+	defer func() {
+		r.NoError(provider.Close())
+	}()
+	_, upErr := provider.UpTo(ctx, rolesVersion)
+	r.NoError(upErr)
+	_, insertErr := dbConn.ExecContext(ctx, "insert into users(id, first_name, last_name, email, birthday) values (7, 'Jane', 'Doe', 'jane@example.com', '1990-01-01')")
+	r.NoError(insertErr)
+	_, insertErr = dbConn.ExecContext(ctx, "insert into api_keys(name, prefix, key_hash, scopes, created_at, role, user_id) values ('jane', 'abcd', 'hash', 'users:read', 0, 'user', 7)")
+	r.NoError(insertErr)
+	userKeys := func() int {
+		var count int
+		r.NoError(dbConn.QueryRowContext(ctx, "select count(*) from api_keys where user_id = 7").Scan(&count))
+		return count
+	}
+	foreignKeys := func() bool {
+		var enabled bool
+		r.NoError(dbConn.QueryRowContext(ctx, "pragma foreign_keys").Scan(&enabled))
+		return enabled
+	}
+
+	_, upErr = provider.UpTo(ctx, organizationsVersion)
+	r.NoError(upErr)
+	r.Equal(1, userKeys())
+	r.True(foreignKeys())
+	_, downErr := provider.DownTo(ctx, rolesVersion)
+	r.NoError(downErr)
+	r.Equal(1, userKeys())
+	r.True(foreignKeys())
+}
+
+func TestGuard(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	path := migratedTo(t, birthdayVersion)
+
+	err := migrations.Guard(ctx, logger, path, table, migrations.Refuse)
+	var pendingErr *migrations.PendingError
+	r.ErrorAs(err, &pendingErr)
+	r.Equal(birthdayVersion, pendingErr.Version)
+	r.Equal("20261019090000_create_idempotency_keys_table.sql", pendingErr.Pending[0].Name)
+	r.ErrorContains(err, "the database is at version 20251012230828 and")
+
+	// Servers starting together migrate once
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = migrations.Guard(ctx, logger, path, table, migrations.Auto)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		r.NoError(err)
+	}
+	r.Equal(1, strings.Count(logs.String(), "msg=\"Applied a migration\" migration=20261019090000_create_idempotency_keys_table.sql"))
+	r.NoError(migrations.Guard(ctx, logger, path, table, migrations.Refuse))
+	r.Contains(logs.String(), "The database schema is up to date")
+
+	_, modeErr := migrations.ParseMode("always")
+	r.Error(modeErr)
+}
+
+func TestGuardWarn(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	path := migratedTo(t, birthdayVersion)
+
+	mode, modeErr := migrations.ParseMode("warn")
+	r.NoError(modeErr)
+	r.NoError(migrations.Guard(ctx, logger, path, table, mode))
+	r.Contains(logs.String(), "migration=20261019090000_create_idempotency_keys_table.sql")
+	var pendingErr *migrations.PendingError
+	r.ErrorAs(migrations.Guard(ctx, logger, path, table, migrations.Refuse), &pendingErr)
+
+	// Only the statements needing the pending migrations fail, when they are used
+	_, eagerErr := db.NewClient(path)
+	r.Error(eagerErr)
+	dbClient, dbClientErr := db.NewLazyClient(path)
+	r.NoError(dbClientErr)
+	defer func() {
+		r.NoError(dbClient.Close())
+	}()
+	_, countErr := dbClient.CountAllUsers(ctx)
+	r.NoError(countErr)
+	_, apiKeyErr := dbClient.GetAPIKeyByPrefix(ctx, "abcd")
+	r.ErrorContains(apiKeyErr, "no such table: api_keys")
+}
+
+func TestParse(t *testing.T) {
+	r := require.New(t)
+	file, parseErr := migrations.Parse([]byte(`-- Adds the email column
+-- +goose Up
+alter table users add column email varchar(255);  -- nullable for now
+update users
+set email = ''
+where email is null;
+-- +goose StatementBegin
+create trigger users_email after update on users begin
+    select 1;
+end;
+-- +goose StatementEnd
+
+-- +goose Down
+alter table users drop column email;
+`))
+	r.NoError(parseErr)
+	r.Len(file.Up, 3)
+	r.Equal(migrations.Statement{Line: 3, SQL: "alter table users add column email varchar(255);  -- nullable for now"}, file.Up[0])
+	r.Equal(4, file.Up[1].Line)
+	r.Equal("update users\nset email = ''\nwhere email is null;", file.Up[1].SQL)
+	r.Equal(8, file.Up[2].Line)
+	r.Contains(file.Up[2].SQL, "select 1;\nend;")
+	r.Equal([]migrations.Statement{{Line: 14, SQL: "alter table users drop column email;"}}, file.Down)
+	r.False(file.NoTransaction)
+
+	for content, message := range map[string]string{
+		"create table a (id integer);\n-- +goose Down\n":                      "line 1: SQL before the Up annotation",
+		"-- +goose Up\ncreate table a (id integer);\n":                        "no Down annotation",
+		"-- +goose Up\ncreate table a (id integer)\n-- +goose Down\n":         "line 2: statement doesn't end with a semicolon",
+		"-- +goose Up\n-- +goose StatementBegin\nselect 1;\n-- +goose Down\n": "line 3: StatementBegin has no StatementEnd",
+		"-- +goose Up\n-- +goose StatementEnd\n-- +goose Down\n":              "line 2: StatementEnd without StatementBegin",
+		"-- +goose Down\n-- +goose Up\n":                                      "line 1: Down annotation before the Up one",
+		"-- +goose Up\n-- +goose Down\n-- +goose Down\n":                      "line 3: second Down annotation",
+		"-- +goose Up\n-- +goose Sideways\n-- +goose Down\n":                  "line 2: unknown annotation",
+	} {
+		_, err := migrations.Parse([]byte(content))
+		r.ErrorContains(err, message, content)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	r := require.New(t)
+	r.NoError(migrations.Validate(migrations.FS))
+	r.NoError(migrations.Rehearse(context.Background(), migrations.FS))
+
+	valid := []byte("-- +goose Up\ncreate table a (id integer);\n-- +goose Down\ndrop table a;\n")
+	err := migrations.Validate(fstest.MapFS{
+		"1_create_a.sql": {Data: valid},
+		"1_create_b.sql": {Data: valid},
+		"2_no_down.sql":  {Data: []byte("-- +goose Up\nselect 1;\n")},
+		"create_c.sql":   {Data: valid},
+		"3_not_sql.md":   {Data: []byte("notes")},
+		"4_create_d.sql": {Data: valid},
+	})
+	r.ErrorContains(err, "1_create_b.sql: version 1 is also used by 1_create_a.sql")
+	r.ErrorContains(err, "2_no_down.sql: no Down annotation")
+	r.ErrorContains(err, "create_c.sql: the name must be <version>_<description>.sql")
+	r.NotContains(err.Error(), "3_not_sql.md")
+	r.NotContains(err.Error(), "4_create_d.sql")
+
+	// Parsing doesn't catch SQL sqlite rejects, the rehearsal does
+	err = migrations.Rehearse(context.Background(), fstest.MapFS{
+		"1_create_a.sql": {Data: valid},
+		"2_bad.sql":      {Data: []byte("-- +goose Up\ncreate tabel b (id integer);\n-- +goose Down\n")},
+	})
+	r.ErrorContains(err, "2_bad.sql")
+}
+
+func TestCreate(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 20, 9, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	path, createErr := migrations.Create(dir, "Add email to users!", now)
+	r.NoError(createErr)
+	r.Equal(filepath.Join(dir, "20261020073000_add_email_to_users.sql"), path)
+	data, readErr := os.ReadFile(path)
+	r.NoError(readErr)
+	file, parseErr := migrations.Parse(data)
+	r.NoError(parseErr)
+	r.Empty(file.Up)
+	r.Empty(file.Down)
+
+	// A migration created in the same second, or with the clock behind, still runs last
+	path, createErr = migrations.Create(dir, "add_phone_to_users", now)
+	r.NoError(createErr)
+	r.Equal(filepath.Join(dir, "20261020073001_add_phone_to_users.sql"), path)
+	r.NoError(migrations.Validate(os.DirFS(dir)))
+
+	_, createErr = migrations.Create(dir, "--", now)
+	r.Error(createErr)
+}
+
+func TestMigrationsAreSafe(t *testing.T) {
+	migrationstest.Lint(t, migrations.FS)
+}
+
+func TestLint(t *testing.T) {
+	r := require.New(t)
+	lint := func(content string) []string {
+		findings, err := migrations.LintFile("1_test.sql", []byte(content))
+		r.NoError(err)
+		messages := make([]string, len(findings))
+		for i, finding := range findings {
+			messages[i] = finding.String()
+		}
+		return messages
+	}
+
+	r.Equal([]string{
+		"1_test.sql:2: adding not null column users.phone without a default fails on a table with rows (not-null-without-default)",
+		"1_test.sql:4: dropping column users.fax loses its data and breaks the servers still reading it (drop-column)",
+		"1_test.sql:5: dropping table sessions loses its data (drop-table)",
+		"1_test.sql:5: the Down section doesn't recreate table sessions (irreversible-down)",
+	}, lint(`-- +goose Up
+alter table users add column phone varchar(20) not null;
+alter table users add column country char(2) not null default 'US';
+ALTER TABLE "users" DROP COLUMN fax;
+drop table if exists main.sessions;
+-- +goose Down
+alter table users drop column country;
+alter table users drop column phone;
+alter table users add column fax varchar(20);
+`))
+
+	r.Equal([]string{
+		"1_test.sql:2: there is no Down section, so the migration can't be rolled back (missing-down)",
+	}, lint("-- +goose Up\ncreate table a (id integer);\n"))
+	r.Equal([]string{
+		"1_test.sql:2: the Down section is empty, so rolling back leaves the Up changes in place (missing-down)",
+	}, lint("-- +goose Up\ncreate table a (id integer);\n-- +goose Down\n"))
+
+	r.Equal([]string{
+		"1_test.sql:3: the Down section doesn't drop table b (irreversible-down)",
+		"1_test.sql:5: the Down section doesn't drop column c.d (irreversible-down)",
+		"1_test.sql:8: the Down section doesn't drop index b_id (irreversible-down)",
+	}, lint(`-- +goose Up
+-- +goose StatementBegin
+create table a (id integer); create table b (id integer);
+alter table a add c integer;
+alter table c
+    add d integer;
+-- +goose StatementEnd
+create unique index b_id on b(id);
+-- +goose Down
+drop table a;
+`))
+
+	// Rebuilding a table, renames, scratch tables, and dropping a table its columns and indexes
+	// went to are reversible and don't lose data
+	r.Empty(lint(`-- +goose Up
+create temp table checks (n integer);
+drop table checks;
+create table users_new (id integer, email text);
+create index users_new_email on users_new(email);
+insert into users_new select id, email from users;
+drop table users;
+alter table users_new rename to users;
+alter table users rename column email to address;
+-- +goose Down
+alter table users rename column address to email;
+create table users_old (id integer, email text);
+drop table users;
+alter table users_old rename to users;
+`))
+
+	// Suppressions on the line, or in the comments above it
+	r.Equal([]string{
+		"1_test.sql:8: lint:ignore names unknown rule \"drop-everything\" (unknown-rule)",
+		"1_test.sql:9: dropping table d loses its data (drop-table)",
+	}, lint(`-- +goose Up
+drop table a; -- lint:ignore drop-table,irreversible-down nothing reads it since v2
+-- lint:ignore drop-table replaced by the events table
+-- and empty in production
+-- +goose StatementBegin
+drop table b;
+-- +goose StatementEnd
+-- lint:ignore drop-everything
+drop table d; -- lint:ignore drop-column wrong rule
+-- +goose Down
+create table b (id integer);
+create table d (id integer);
+`))
+
+	_, err := migrations.LintFile("1_test.sql", []byte("drop table a;\n"))
+	r.ErrorContains(err, "1_test.sql: line 1: SQL before the Up annotation")
+}
@@ -0,0 +1,22 @@
+// Package migrationstest fails tests on unsafe migrations.
+package migrationstest
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/brandonrachal/gin-and-tonic/migrations"
+)
+
+// Lint fails t with every finding of the migrations linter in the migration files at the root
+// of fsys, and stops it when a file can't be parsed.
+func Lint(t testing.TB, fsys fs.FS) {
+	t.Helper()
+	findings, lintErr := migrations.Lint(fsys)
+	for _, finding := range findings {
+		t.Errorf("unsafe migration - %s", finding)
+	}
+	if lintErr != nil {
+		t.Fatalf("error linting the migrations - %s", lintErr)
+	}
+}
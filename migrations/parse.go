@@ -0,0 +1,150 @@
+package migrations
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// File is a parsed goose SQL migration.
+type File struct {
+	Up   []Statement
+	Down []Statement
+	// NoTransaction is set by "-- +goose NO TRANSACTION", which runs the statements outside a
+	// transaction.
+	NoTransaction bool
+}
+
+// Statement is one SQL statement of a migration, as goose sends it to the database.
+type Statement struct {
+	// Line is where the statement starts in the file, counting from 1.
+	Line int
+	SQL  string
+}
+
+const annotationPrefix = "-- +goose "
+
+// Parse reads a migration the way goose splits it into statements. Statements end with a line
+// ending in a semicolon unless they are between StatementBegin and StatementEnd annotations.
+// Both an Up and a Down section are required.
+func Parse(data []byte) (*File, error) {
+	file, hasDown, err := parse(data)
+	if err != nil {
+		return nil, err
+	} else if !hasDown {
+		return nil, errors.New("no Down annotation, every migration must be reversible")
+	}
+	return file, nil
+}
+
+// parse is Parse without requiring a Down section, which the linter reports itself.
+func parse(data []byte) (*File, bool, error) {
+	file := &File{}
+	var (
+		section    *[]Statement
+		hasUp      bool
+		hasDown    bool
+		inBlock    bool
+		buffer     strings.Builder
+		start      int
+		lineNumber int
+	)
+	// flush ends the statement being read, which must be complete
+	flush := func() error {
+		if inBlock {
+			return fmt.Errorf("line %d: StatementBegin has no StatementEnd", start)
+		}
+		if buffer.Len() > 0 {
+			return fmt.Errorf("line %d: statement doesn't end with a semicolon", start)
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if annotation, found := strings.CutPrefix(trimmed, annotationPrefix); found {
+			switch strings.ToLower(strings.TrimSpace(annotation)) {
+			case "up":
+				if hasUp {
+					return nil, false, fmt.Errorf("line %d: second Up annotation", lineNumber)
+				} else if hasDown {
+					return nil, false, fmt.Errorf("line %d: Up annotation after the Down one", lineNumber)
+				}
+				hasUp, section = true, &file.Up
+			case "down":
+				if hasDown {
+					return nil, false, fmt.Errorf("line %d: second Down annotation", lineNumber)
+				} else if !hasUp {
+					return nil, false, fmt.Errorf("line %d: Down annotation before the Up one", lineNumber)
+				}
+				if err := flush(); err != nil {
+					return nil, false, err
+				}
+				hasDown, section = true, &file.Down
+			case "statementbegin":
+				if section == nil {
+					return nil, false, fmt.Errorf("line %d: StatementBegin before the Up annotation", lineNumber)
+				} else if inBlock {
+					return nil, false, fmt.Errorf("line %d: StatementBegin inside another one", lineNumber)
+				} else if err := flush(); err != nil {
+					return nil, false, err
+				}
+				inBlock, start = true, lineNumber+1
+			case "statementend":
+				if !inBlock {
+					return nil, false, fmt.Errorf("line %d: StatementEnd without StatementBegin", lineNumber)
+				}
+				inBlock = false
+				if buffer.Len() > 0 {
+					*section = append(*section, Statement{Line: start, SQL: strings.TrimSpace(buffer.String())})
+					buffer.Reset()
+				}
+			case "no transaction":
+				file.NoTransaction = true
+			case "envsub on", "envsub off":
+			default:
+				return nil, false, fmt.Errorf("line %d: unknown annotation %q", lineNumber, trimmed)
+			}
+			continue
+		}
+		// Blank lines and comments between statements are dropped, as goose does
+		if buffer.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		if section == nil {
+			return nil, false, fmt.Errorf("line %d: SQL before the Up annotation", lineNumber)
+		}
+		if buffer.Len() == 0 {
+			start = lineNumber
+		}
+		buffer.WriteString(line)
+		buffer.WriteString("\n")
+		if !inBlock && endsWithSemicolon(trimmed) {
+			*section = append(*section, Statement{Line: start, SQL: strings.TrimSpace(buffer.String())})
+			buffer.Reset()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	if err := flush(); err != nil {
+		return nil, false, err
+	}
+	if !hasUp {
+		return nil, false, errors.New("no Up annotation")
+	}
+	return file, hasDown, nil
+}
+
+// endsWithSemicolon ignores a trailing -- comment, like goose.
+func endsWithSemicolon(line string) bool {
+	if before, _, found := strings.Cut(line, "--"); found {
+		line = strings.TrimSpace(before)
+	}
+	return strings.HasSuffix(line, ";")
+}
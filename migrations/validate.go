@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pressly/goose/v3"
+)
+
+// Validate checks the migration files at the root of fsys without a database: the names,
+// that no two files share a version, and that each file parses with both an Up and a Down
+// section. It returns every problem found.
+func Validate(fsys fs.FS) error {
+	files, namesErr := parseNames(fsys)
+	errs := []error{namesErr}
+	seen := make(map[int64]string, len(files))
+	for _, file := range files {
+		if other, found := seen[file.Version]; found {
+			errs = append(errs, fmt.Errorf("%s: version %d is also used by %s", file.Name, file.Version, other))
+		}
+		seen[file.Version] = file.Name
+		if _, err := ParseFile(fsys, file.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseFile parses the migration called name in fsys.
+func ParseFile(fsys fs.FS, name string) (*File, error) {
+	data, dataErr := fs.ReadFile(fsys, name)
+	if dataErr != nil {
+		return nil, dataErr
+	}
+	file, parseErr := Parse(data)
+	if parseErr != nil {
+		return nil, fmt.Errorf("%s: %w", name, parseErr)
+	}
+	return file, nil
+}
+
+// Rehearse applies the migrations of fsys to an empty database, then rolls them all back, to
+// catch SQL sqlite rejects before a real database sees it.
+func Rehearse(ctx context.Context, fsys fs.FS) error {
+	dir, dirErr := os.MkdirTemp("", "migrations")
+	if dirErr != nil {
+		return dirErr
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dbConn, dbConnErr := sql.Open("sqlite3", filepath.Join(dir, "rehearsal.db"))
+	if dbConnErr != nil {
+		return dbConnErr
+	}
+	provider, providerErr := goose.NewProvider(goose.DialectSQLite3, dbConn, fsys)
+	if providerErr != nil {
+		_ = dbConn.Close()
+		return providerErr
+	}
+	defer func() {
+		_ = provider.Close()
+	}()
+	if _, err := provider.Up(ctx); err != nil {
+		return rehearsalError("applying", err)
+	}
+	if _, err := provider.DownTo(ctx, 0); err != nil {
+		return rehearsalError("rolling back", err)
+	}
+	return nil
+}
+
+// rehearsalError names the migration that failed, which the goose error only gives the
+// version of.
+func rehearsalError(action string, err error) error {
+	var partialErr *goose.PartialError
+	if errors.As(err, &partialErr) && partialErr.Failed != nil {
+		return fmt.Errorf("%s %s - %w", action, path.Base(partialErr.Failed.Source.Path), partialErr.Err)
+	}
+	return fmt.Errorf("%s the migrations - %w", action, err)
+}
@@ -1,6 +1,11 @@
 package api
 
-import "github.com/brandonrachal/gin-and-tonic/models"
+import (
+	"context"
+
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/brandonrachal/gin-and-tonic/requestid"
+)
 
 type Message struct {
 	Message string `json:"message"`
@@ -12,12 +17,20 @@ func NewMessage(message string) *Message {
 	}
 }
 
+// StatusMessage is the body of /ping.
+type StatusMessage struct {
+	Status string `json:"status"`
+}
+
 type ErrorMessage struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	RequestId string `json:"request_id,omitempty"`
 }
 
-func NewErrorMessage(error string) ErrorMessage {
-	return ErrorMessage{Error: error}
+// NewErrorMessage includes the request id from ctx, so callers can quote it when reporting the error.
+func NewErrorMessage(ctx context.Context, error string) ErrorMessage {
+	requestId, _ := requestid.FromContext(ctx)
+	return ErrorMessage{Error: error, RequestId: requestId}
 }
 
 type IdUserMessage struct {
@@ -31,6 +44,16 @@ func NewIdUserMessage(id int64) IdUserMessage {
 	}
 }
 
+type UserMessage struct {
+	User models.User `json:"user"`
+}
+
+func NewUserMessage(user models.User) UserMessage {
+	return UserMessage{
+		User: user,
+	}
+}
+
 type UsersMessage struct {
 	Users []models.User `json:"users"`
 }
@@ -60,3 +83,30 @@ func NewAgeStatsMessage(ageStats models.AgeStats) AgeStatsMessage {
 		AgeStats: ageStats,
 	}
 }
+
+// Problem is an RFC 9457 problem details body, sent as application/problem+json.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	RequestId string `json:"request_id,omitempty"`
+	// Errors lists each problem with the request, e.g. every invalid field.
+	Errors []ProblemError `json:"errors,omitempty"`
+}
+
+type ProblemError struct {
+	Location string `json:"location"`
+	Message  string `json:"message"`
+}
+
+func NewProblem(ctx context.Context, status int, title, detail string) Problem {
+	requestId, _ := requestid.FromContext(ctx)
+	return Problem{
+		Type:      "about:blank",
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		RequestId: requestId,
+	}
+}
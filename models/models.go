@@ -19,8 +19,8 @@ func GetIdUser(id int64) *IdUser {
 type CreateUser struct {
 	FirstName string               `db:"first_name" json:"first_name" form:"first_name" binding:"required"`
 	LastName  string               `db:"last_name" json:"last_name" form:"last_name" binding:"required"`
-	Email     string               `db:"email" json:"email" form:"email" binding:"required"`
-	Birthday  jsonutils.SimpleDate `db:"birthday" json:"birthday" form:"birthday" binding:"required"`
+	Email     string               `db:"email" json:"email,omitzero" form:"email" binding:"required"`
+	Birthday  jsonutils.SimpleDate `db:"birthday" json:"birthday,omitzero" form:"birthday" binding:"required"`
 }
 
 func GetCreateUser(firstName, lastName, email, birthday string) (*CreateUser, error) {
@@ -37,18 +37,37 @@ func GetCreateUser(firstName, lastName, email, birthday string) (*CreateUser, er
 	}, nil
 }
 
+// UpsertUser is the body of an upsert by email, where the email comes from the url.
+type UpsertUser struct {
+	FirstName string               `json:"first_name" form:"first_name" binding:"required"`
+	LastName  string               `json:"last_name" form:"last_name" binding:"required"`
+	Birthday  jsonutils.SimpleDate `json:"birthday" form:"birthday" binding:"required"`
+}
+
 type User struct {
 	IdUser
 	CreateUser
 }
 
+// Redact blanks the personal fields, which are then left out of the JSON.
+func (u *User) Redact() {
+	u.Email = ""
+	u.Birthday = jsonutils.SimpleDate{}
+}
+
 func (u *User) String() string {
 	return fmt.Sprintf("User: %d, \"%s\", \"%s\", \"%s\", \"%s\",", u.Id, u.FirstName, u.LastName, u.Email, u.Birthday.String())
 }
 
 type UserWithAge struct {
 	User
-	AgeInYears int `db:"age_in_years" json:"age_in_years" form:"age_in_years" binding:"required"`
+	AgeInYears int `db:"age_in_years" json:"age_in_years,omitzero" form:"age_in_years" binding:"required"`
+}
+
+// Redact also blanks the age, which would give the birthday away.
+func (u *UserWithAge) Redact() {
+	u.User.Redact()
+	u.AgeInYears = 0
 }
 
 type AgeStats struct {
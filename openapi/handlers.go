@@ -0,0 +1,49 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sync"
+)
+
+//go:embed docs.html
+var docsHTML string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsHTML))
+
+// Handler serves document as JSON. It is encoded on the first request, once every route has
+// been added.
+func Handler(document *Document) http.Handler {
+	var once sync.Once
+	var body []byte
+	var bodyErr error
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			body, bodyErr = json.MarshalIndent(document, "", "  ")
+		})
+		if bodyErr != nil {
+			http.Error(w, bodyErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ContentTypeJSON)
+		_, _ = w.Write(body)
+	})
+}
+
+// DocsHandler serves a page that renders the document found at specURL. It needs no assets
+// from outside the binary.
+func DocsHandler(title, specURL string) http.Handler {
+	var page bytes.Buffer
+	pageErr := docsTemplate.Execute(&page, struct{ Title, SpecURL string }{title, specURL})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if pageErr != nil {
+			http.Error(w, pageErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(page.Bytes())
+	})
+}
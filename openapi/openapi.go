@@ -0,0 +1,237 @@
+// Package openapi describes the API as an OpenAPI 3.1 document built from its routes and the Go
+// types of their request and response bodies.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	Version = "3.1.0"
+	// BearerAuth is the security scheme of API keys and JWTs.
+	BearerAuth = "bearerAuth"
+	// ClientCertificate is the security scheme of TLS client certificates.
+	ClientCertificate = "clientCertificate"
+	// ContentTypeJSON is the content type of bodies that don't set one.
+	ContentTypeJSON = "application/json"
+)
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower case HTTP methods to their operation.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Route describes a route registered with the router.
+type Route struct {
+	Method string
+	// Path is the router's path, e.g. /v1.0/users/by_email/:email. Its parameters become path
+	// parameters.
+	Path        string
+	OperationId string
+	Summary     string
+	Tag         string
+	// Scopes are the scopes the caller needs. Routes without scopes are public.
+	Scopes []string
+	// Headers are the request headers the route reads.
+	Headers []Parameter
+	// Request is a value of the request body's type, nil when there's no body.
+	Request any
+	// Responses are the possible responses by status code.
+	Responses map[int]Body
+}
+
+// Body describes a response body.
+type Body struct {
+	// Description defaults to the status text.
+	Description string
+	// ContentType defaults to ContentTypeJSON.
+	ContentType string
+	// Value is a value of the body's type, nil when there's no body.
+	Value any
+}
+
+// Builder collects routes into a Document.
+type Builder struct {
+	document *Document
+	// clientCertificates offers ClientCertificate next to BearerAuth.
+	clientCertificates bool
+	// components maps schema names to the type they were generated from.
+	components map[string]reflect.Type
+	// inlining holds the request types being generated, to catch recursive ones.
+	inlining map[reflect.Type]bool
+}
+
+func NewBuilder(info Info) *Builder {
+	return &Builder{
+		document: &Document{
+			OpenAPI: Version,
+			Info:    info,
+			Paths:   make(map[string]*PathItem),
+			Components: Components{
+				Schemas: make(map[string]*Schema),
+			},
+		},
+		components: make(map[string]reflect.Type),
+		inlining:   make(map[reflect.Type]bool),
+	}
+}
+
+// AcceptClientCertificates documents that authenticated routes also accept a TLS client
+// certificate instead of a bearer token.
+func (b *Builder) AcceptClientCertificates() {
+	b.clientCertificates = true
+}
+
+// Add describes route in the document.
+func (b *Builder) Add(route Route) {
+	path, pathParameters := convertPath(route.Path)
+	item, ok := b.document.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		b.document.Paths[path] = item
+	}
+	operation := &Operation{
+		OperationId: route.OperationId,
+		Summary:     route.Summary,
+		Parameters:  append(pathParameters, route.Headers...),
+		Responses:   make(map[string]*Response),
+	}
+	if route.Tag != "" {
+		operation.Tags = []string{route.Tag}
+	}
+	if route.Request != nil {
+		operation.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				ContentTypeJSON: {Schema: b.schema(reflect.TypeOf(route.Request), requestMode)},
+			},
+		}
+	}
+	for status, body := range route.Responses {
+		response := &Response{Description: body.Description}
+		if response.Description == "" {
+			response.Description = http.StatusText(status)
+		}
+		if body.Value != nil {
+			contentType := body.ContentType
+			if contentType == "" {
+				contentType = ContentTypeJSON
+			}
+			response.Content = map[string]MediaType{
+				contentType: {Schema: b.schema(reflect.TypeOf(body.Value), responseMode)},
+			}
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+	}
+	if len(route.Scopes) > 0 {
+		operation.Security = b.security(route.Scopes)
+	}
+	(*item)[strings.ToLower(route.Method)] = operation
+}
+
+func (b *Builder) security(scopes []string) []map[string][]string {
+	schemes := b.document.Components.SecuritySchemes
+	if schemes == nil {
+		schemes = make(map[string]*SecurityScheme)
+		b.document.Components.SecuritySchemes = schemes
+	}
+	schemes[BearerAuth] = &SecurityScheme{Type: "http", Scheme: "bearer", Description: "An API key or a JWT"}
+	security := []map[string][]string{{BearerAuth: scopes}}
+	if b.clientCertificates {
+		schemes[ClientCertificate] = &SecurityScheme{Type: "mutualTLS", Description: "A registered TLS client certificate"}
+		security = append(security, map[string][]string{ClientCertificate: scopes})
+	}
+	return security
+}
+
+// Document returns the document of every route added so far.
+func (b *Builder) Document() *Document {
+	return b.document
+}
+
+// Operation finds the operation of a router method and path, as returned by gin's Routes.
+func (d *Document) Operation(method, routerPath string) *Operation {
+	path, _ := convertPath(routerPath)
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// convertPath turns the router's :name and *name parameters into OpenAPI {name} ones.
+func convertPath(routerPath string) (string, []Parameter) {
+	var parameters []Parameter
+	segments := strings.Split(routerPath, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	return strings.Join(segments, "/"), parameters
+}
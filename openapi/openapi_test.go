@@ -0,0 +1,124 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brandonrachal/go-toolbox/jsonutils"
+	"github.com/stretchr/testify/require"
+)
+
+type testId struct {
+	Id int64 `json:"id" binding:"required"`
+}
+
+type testPerson struct {
+	testId
+	Name     string               `json:"name" binding:"required"`
+	Nickname string               `json:"nickname,omitempty"`
+	Birthday jsonutils.SimpleDate `json:"birthday,omitzero" binding:"required"`
+	Friends  []*testPerson        `json:"friends"`
+	Secret   string               `json:"-"`
+}
+
+func TestSchemas(t *testing.T) {
+	r := require.New(t)
+	builder := NewBuilder(Info{Title: "test", Version: "1"})
+	builder.Add(Route{
+		Method:      http.MethodPut,
+		Path:        "/people/:name/*rest",
+		OperationId: "putPerson",
+		Scopes:      []string{"people:write"},
+		Request:     testPerson{},
+		Responses:   map[int]Body{http.StatusOK: {Value: testPerson{}}, http.StatusNoContent: {}},
+	})
+	document := builder.Document()
+	operation := document.Operation("PUT", "/people/:name/*rest")
+	r.NotNil(operation)
+	r.Equal(operation, (*document.Paths["/people/{name}/{rest}"])["put"])
+	r.Equal([]string{"name", "rest"}, []string{operation.Parameters[0].Name, operation.Parameters[1].Name})
+	r.Equal([]map[string][]string{{BearerAuth: {"people:write"}}}, operation.Security)
+
+	// Requests are inlined and follow the binding tags
+	request := operation.RequestBody.Content[ContentTypeJSON].Schema
+	r.Equal([]string{"id", "name", "birthday"}, request.Required)
+	r.Equal(&Schema{Type: "string", Format: "date"}, request.Properties["birthday"])
+	r.NotContains(request.Properties, "Secret")
+	r.Equal("#/components/schemas/testPersonRequest", request.Properties["friends"].Items.AnyOf[0].Ref)
+	r.Equal(request.Required, document.Components.Schemas["testPersonRequest"].Required)
+
+	// Responses are components and follow omitempty and omitzero
+	r.Equal("#/components/schemas/testPerson", operation.Responses["200"].Content[ContentTypeJSON].Schema.Ref)
+	r.Nil(operation.Responses["204"].Content)
+	r.Equal("No Content", operation.Responses["204"].Description)
+	person := document.Components.Schemas["testPerson"]
+	r.Equal([]string{"id", "name", "friends"}, person.Required)
+	friend := person.Properties["friends"].Items
+	r.Equal([]*Schema{{Ref: "#/components/schemas/testPerson"}, {Type: "null"}}, friend.AnyOf)
+}
+
+func TestHandlers(t *testing.T) {
+	r := require.New(t)
+	builder := NewBuilder(Info{Title: "test", Version: "1"})
+	builder.Add(Route{Method: http.MethodGet, Path: "/ping", OperationId: "ping"})
+
+	w := httptest.NewRecorder()
+	Handler(builder.Document()).ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	r.Equal(ContentTypeJSON, w.Header().Get("Content-Type"))
+	var document Document
+	r.NoError(json.Unmarshal(w.Body.Bytes(), &document))
+	r.Equal("ping", document.Operation("GET", "/ping").OperationId)
+
+	w = httptest.NewRecorder()
+	DocsHandler("<test>", "/spec.json").ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	r.Contains(w.Body.String(), "<title>&lt;test&gt;</title>")
+	r.Contains(w.Body.String(), `const specURL = "/spec.json";`)
+}
+
+func TestValidate(t *testing.T) {
+	r := require.New(t)
+	builder := NewBuilder(Info{Title: "test", Version: "1"})
+	builder.Add(Route{
+		Method:      http.MethodPut,
+		Path:        "/people/:name",
+		OperationId: "putPerson",
+		Headers:     []Parameter{{Name: "X-Count", In: "header", Required: true, Schema: &Schema{Type: "integer"}}},
+		Request:     testPerson{},
+		Responses:   map[int]Body{http.StatusOK: {Value: testPerson{}}, http.StatusNoContent: {}},
+	})
+	document := builder.Document()
+	operation := document.Operation("PUT", "/people/:name")
+	validateRequest := func(header map[string]string, body string) []ValidationError {
+		req := httptest.NewRequest("PUT", "/people/ann", nil)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		return document.ValidateRequest(operation, req, map[string]string{"name": "ann"}, []byte(body))
+	}
+
+	r.Empty(validateRequest(map[string]string{"X-Count": "2", "Content-Type": "application/json; charset=utf-8"},
+		`{"id": 1, "name": "Ann", "birthday": "1990-01-01", "friends": [null, {"id": 2, "name": "Bo", "birthday": "1991-02-03"}]}`))
+	r.Equal([]ValidationError{
+		{Location: "header X-Count", Message: `"two" isn't a number`},
+		{Location: "body.birthday", Message: "is required"},
+		{Location: "body.friends[0].name", Message: "is required"},
+		{Location: "body.friends[0].birthday", Message: "is required"},
+		{Location: "body.friends[0].id", Message: "9223372036854775808 isn't an int64"},
+		{Location: "body.id", Message: "is number, not integer"},
+	}, validateRequest(map[string]string{"X-Count": "two", "Content-Type": "application/json"}, `{"id": 1.5, "name": "Ann", "friends": [{"id": 9223372036854775808}]}`))
+	r.Equal([]ValidationError{{Location: "header X-Count", Message: "is required"}, {Location: "body", Message: "is required"}}, validateRequest(nil, ""))
+	r.Equal([]ValidationError{{Location: "header Content-Type", Message: "text/plain isn't application/json"}},
+		validateRequest(map[string]string{"X-Count": "2", "Content-Type": "text/plain"}, "Ann"))
+
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	r.Empty(document.ValidateResponse(operation, http.StatusOK, jsonHeader, []byte(`{"id": 1, "name": "Ann", "friends": null}`)))
+	r.Empty(document.ValidateResponse(operation, http.StatusNoContent, http.Header{}, nil))
+	r.Equal([]ValidationError{{Location: "response.friends", Message: "is required"}},
+		document.ValidateResponse(operation, http.StatusOK, jsonHeader, []byte(`{"id": 1, "name": "Ann"}`)))
+	r.Equal([]ValidationError{{Location: "status", Message: "500 isn't a documented response"}},
+		document.ValidateResponse(operation, http.StatusInternalServerError, jsonHeader, []byte(`{}`)))
+	r.Equal([]ValidationError{{Location: "response", Message: "isn't valid JSON: unexpected EOF"}},
+		document.ValidateResponse(operation, http.StatusOK, jsonHeader, []byte(`{"id": 1`)))
+}
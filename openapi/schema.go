@@ -0,0 +1,176 @@
+package openapi
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brandonrachal/go-toolbox/jsonutils"
+)
+
+const componentsPrefix = "#/components/schemas/"
+
+// Schema is a JSON Schema. Type is a string, or a list of them for nullable values.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+}
+
+// mode decides which properties of a struct are required.
+type mode int
+
+const (
+	// requestMode requires the fields with a `binding:"required"` tag, like gin's validation.
+	// Structs are inlined, except recursive ones, because the same type can have different
+	// required fields in a response.
+	requestMode mode = iota
+	// responseMode requires the fields encoding/json always writes, those without omitempty or
+	// omitzero. Named structs become components.
+	responseMode
+)
+
+var (
+	simpleDateType = reflect.TypeFor[jsonutils.SimpleDate]()
+	timeType       = reflect.TypeFor[time.Time]()
+)
+
+func (b *Builder) schema(t reflect.Type, m mode) *Schema {
+	switch t {
+	case simpleDateType:
+		return &Schema{Type: "string", Format: "date"}
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(b.schema(t.Elem(), m))
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int32, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: b.schema(t.Elem(), m)}
+	case reflect.Slice:
+		// encoding/json writes nil slices and maps as null
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: []string{"string", "null"}, Format: "byte"}
+		}
+		return &Schema{Type: []string{"array", "null"}, Items: b.schema(t.Elem(), m)}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: b.schema(t.Elem(), m)}
+	case reflect.Struct:
+		if t.Name() == "" || (m == requestMode && !b.inlining[t]) {
+			return b.object(t, m)
+		}
+		return &Schema{Ref: b.component(t, m)}
+	default:
+		// Any JSON value
+		return &Schema{}
+	}
+}
+
+// component adds the schema of t to the components and returns its reference. Request schemas
+// only become components when they are recursive, and are named <type>Request.
+func (b *Builder) component(t reflect.Type, m mode) string {
+	suffix := ""
+	if m == requestMode {
+		suffix = "Request"
+	}
+	name := t.Name() + suffix
+	if existing, ok := b.components[name]; ok && existing != t {
+		// Another package has a type of the same name
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+	if _, ok := b.components[name]; !ok {
+		b.components[name] = t
+		// Set before generating so recursive types find it
+		b.document.Components.Schemas[name] = &Schema{}
+		b.document.Components.Schemas[name] = b.object(t, m)
+	}
+	return componentsPrefix + name
+}
+
+func (b *Builder) object(t reflect.Type, m mode) *Schema {
+	if m == requestMode {
+		// A type met again while it is inlined is recursive
+		b.inlining[t] = true
+		defer delete(b.inlining, t)
+	}
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	b.addFields(schema, t, m)
+	return schema
+}
+
+// addFields adds the JSON properties of struct t to schema. Embedded structs without a name
+// are flattened like encoding/json does.
+func (b *Builder) addFields(schema *Schema, t reflect.Type, m mode) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.addFields(schema, embedded, m)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = b.schema(field.Type, m)
+		if isRequired(field, options, m) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+func isRequired(field reflect.StructField, jsonOptions string, m mode) bool {
+	if m == requestMode {
+		return slices.Contains(strings.Split(field.Tag.Get("binding"), ","), "required")
+	}
+	options := strings.Split(jsonOptions, ",")
+	return !slices.Contains(options, "omitempty") && !slices.Contains(options, "omitzero")
+}
+
+// nullable lets schema also be null.
+func nullable(schema *Schema) *Schema {
+	switch t := schema.Type.(type) {
+	case string:
+		nullableSchema := *schema
+		nullableSchema.Type = []string{t, "null"}
+		return &nullableSchema
+	case nil:
+		if schema.Ref == "" {
+			// Already anything
+			return schema
+		}
+	}
+	return &Schema{AnyOf: []*Schema{schema, {Type: "null"}}}
+}
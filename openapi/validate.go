@@ -0,0 +1,305 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError is one way a request or response breaks the document.
+type ValidationError struct {
+	// Location is where the problem is, e.g. "body.users[2].birthday", "header X-Tenant" or
+	// "path email".
+	Location string
+	Message  string
+}
+
+func (e ValidationError) Error() string {
+	return e.Location + ": " + e.Message
+}
+
+// ValidateRequest checks req against operation. pathParams are the values of the route's path
+// parameters and body is the request body, which req.Body no longer has to hold.
+func (d *Document) ValidateRequest(operation *Operation, req *http.Request, pathParams map[string]string, body []byte) []ValidationError {
+	var errs []ValidationError
+	query := req.URL.Query()
+	for _, parameter := range operation.Parameters {
+		var value string
+		var ok bool
+		switch parameter.In {
+		case "path":
+			value, ok = pathParams[parameter.Name]
+		case "query":
+			ok = query.Has(parameter.Name)
+			value = query.Get(parameter.Name)
+		case "header":
+			values := req.Header.Values(parameter.Name)
+			ok = len(values) > 0
+			if ok {
+				value = values[0]
+			}
+		default:
+			continue
+		}
+		location := parameter.In + " " + parameter.Name
+		if !ok || (parameter.In == "path" && value == "") {
+			if parameter.Required {
+				errs = append(errs, ValidationError{Location: location, Message: "is required"})
+			}
+			continue
+		}
+		errs = append(errs, d.validateParameter(parameter.Schema, value, location)...)
+	}
+	if operation.RequestBody == nil {
+		return errs
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if operation.RequestBody.Required {
+			errs = append(errs, ValidationError{Location: "body", Message: "is required"})
+		}
+		return errs
+	}
+	mediaType, schema, contentErr := findMedia(operation.RequestBody.Content, req.Header.Get("Content-Type"))
+	if contentErr != nil {
+		return append(errs, ValidationError{Location: "header Content-Type", Message: contentErr.Error()})
+	}
+	return append(errs, d.validateBody(mediaType, schema, body, "body")...)
+}
+
+// ValidateResponse checks a response to operation.
+func (d *Document) ValidateResponse(operation *Operation, status int, header http.Header, body []byte) []ValidationError {
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = operation.Responses["default"]
+	}
+	if !ok {
+		return []ValidationError{{Location: "status", Message: fmt.Sprintf("%d isn't a documented response", status)}}
+	}
+	if len(response.Content) == 0 {
+		if len(body) > 0 {
+			return []ValidationError{{Location: "response", Message: fmt.Sprintf("%d responses have no body", status)}}
+		}
+		return nil
+	}
+	mediaType, schema, contentErr := findMedia(response.Content, header.Get("Content-Type"))
+	if contentErr != nil {
+		return []ValidationError{{Location: "header Content-Type", Message: contentErr.Error()}}
+	}
+	return d.validateBody(mediaType, schema, body, "response")
+}
+
+// findMedia finds the schema of a Content-Type header in content.
+func findMedia(content map[string]MediaType, contentType string) (string, *Schema, error) {
+	mediaType, _, mediaTypeErr := mime.ParseMediaType(contentType)
+	if mediaTypeErr != nil {
+		return "", nil, fmt.Errorf("%q isn't a media type", contentType)
+	}
+	media, ok := content[mediaType]
+	if !ok {
+		return "", nil, fmt.Errorf("%s isn't %s", mediaType, strings.Join(slices.Sorted(maps.Keys(content)), " or "))
+	}
+	return mediaType, media.Schema, nil
+}
+
+// validateBody checks JSON bodies against schema. Other bodies aren't checked.
+func (d *Document) validateBody(mediaType string, schema *Schema, body []byte, location string) []ValidationError {
+	if mediaType != ContentTypeJSON && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []ValidationError{{Location: location, Message: "isn't valid JSON: " + err.Error()}}
+	}
+	if decoder.More() {
+		return []ValidationError{{Location: location, Message: "has more than one JSON value"}}
+	}
+	return d.Validate(schema, value, location)
+}
+
+// validateParameter checks a path, query or header value, converting it to the schema's type.
+func (d *Document) validateParameter(schema *Schema, raw, location string) []ValidationError {
+	var value any = raw
+	types := schemaTypes(d.resolve(schema))
+	switch {
+	case slices.Contains(types, "integer"), slices.Contains(types, "number"):
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return []ValidationError{{Location: location, Message: fmt.Sprintf("%q isn't a number", raw)}}
+		}
+		value = json.Number(raw)
+	case slices.Contains(types, "boolean"):
+		boolean, err := strconv.ParseBool(raw)
+		if err != nil {
+			return []ValidationError{{Location: location, Message: fmt.Sprintf("%q isn't a boolean", raw)}}
+		}
+		value = boolean
+	}
+	return d.Validate(schema, value, location)
+}
+
+// Validate checks a value decoded from JSON with json.Decoder.UseNumber against schema.
+func (d *Document) Validate(schema *Schema, value any, location string) []ValidationError {
+	schema = d.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+	if len(schema.AnyOf) > 0 {
+		for _, option := range schema.AnyOf {
+			if len(d.Validate(option, value, location)) == 0 {
+				return nil
+			}
+		}
+		// Report the errors of the option with the right type, e.g. the object of a nullable one
+		for _, option := range schema.AnyOf {
+			if types := schemaTypes(d.resolve(option)); slices.Contains(types, jsonType(value)) {
+				return d.Validate(option, value, location)
+			}
+		}
+		return []ValidationError{{Location: location, Message: "doesn't match any allowed schema"}}
+	}
+	if types := schemaTypes(schema); len(types) > 0 && !typeAllowed(types, value) {
+		return []ValidationError{{Location: location, Message: fmt.Sprintf("is %s, not %s", jsonType(value), strings.Join(types, " or "))}}
+	}
+	switch value := value.(type) {
+	case string:
+		if err := checkStringFormat(schema.Format, value); err != nil {
+			return []ValidationError{{Location: location, Message: err.Error()}}
+		}
+	case json.Number:
+		if err := checkNumberFormat(schema.Format, value); err != nil {
+			return []ValidationError{{Location: location, Message: err.Error()}}
+		}
+	case []any:
+		var errs []ValidationError
+		if schema.Items != nil {
+			for i, item := range value {
+				errs = append(errs, d.Validate(schema.Items, item, fmt.Sprintf("%s[%d]", location, i))...)
+			}
+		}
+		return errs
+	case map[string]any:
+		var errs []ValidationError
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				errs = append(errs, ValidationError{Location: location + "." + name, Message: "is required"})
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			if property, ok := schema.Properties[name]; ok {
+				errs = append(errs, d.Validate(property, value[name], location+"."+name)...)
+			} else if schema.AdditionalProperties != nil {
+				errs = append(errs, d.Validate(schema.AdditionalProperties, value[name], location+"."+name)...)
+			}
+		}
+		return errs
+	}
+	return nil
+}
+
+// resolve follows $ref to the component schema.
+func (d *Document) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, componentsPrefix)]
+	}
+	return schema
+}
+
+// schemaTypes lists the types of schema, built in Go or decoded from JSON.
+func schemaTypes(schema *Schema) []string {
+	if schema == nil {
+		return nil
+	}
+	switch t := schema.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []any:
+		var types []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func typeAllowed(types []string, value any) bool {
+	actual := jsonType(value)
+	if slices.Contains(types, actual) {
+		return true
+	}
+	if number, ok := value.(json.Number); ok {
+		if slices.Contains(types, "number") {
+			return true
+		}
+		// 1.0 is an integer too
+		float, err := number.Float64()
+		return err == nil && slices.Contains(types, "integer") && float == math.Trunc(float)
+	}
+	return false
+}
+
+func checkStringFormat(format, value string) error {
+	var err error
+	switch format {
+	case "date":
+		_, err = time.Parse(time.DateOnly, value)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, value)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%q isn't a %s", value, format)
+	}
+	return nil
+}
+
+func checkNumberFormat(format string, value json.Number) error {
+	bits := 0
+	switch format {
+	case "int32":
+		bits = 32
+	case "int64":
+		bits = 64
+	default:
+		return nil
+	}
+	if _, err := strconv.ParseInt(value.String(), 10, bits); err != nil {
+		return fmt.Errorf("%s isn't an %s", value, format)
+	}
+	return nil
+}
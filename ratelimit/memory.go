@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	rate      float64
+	burst     int
+}
+
+func (b *bucket) refill(now time.Time) float64 {
+	return math.Min(float64(b.burst), b.tokens+math.Max(0, now.Sub(b.updatedAt).Seconds())*b.rate)
+}
+
+type quotaKey struct {
+	key string
+	day string
+}
+
+// MemoryStore is a Store for a single process. When a new day starts, quota counters from
+// earlier days and buckets that have refilled are dropped.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	quotas  map[quotaKey]int
+	day     string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		quotas:  make(map[quotaKey]int),
+	}
+}
+
+func (s *MemoryStore) TakeToken(_ context.Context, key string, rate float64, burst int, now time.Time) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purge(now)
+	b, found := s.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burst
+	b.tokens = b.refill(now)
+	if now.After(b.updatedAt) {
+		b.updatedAt = now
+	}
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+func (s *MemoryStore) IncrementQuota(_ context.Context, key, day string, quota int) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := quotaKey{key: key, day: day}
+	if s.quotas[k] >= quota {
+		return s.quotas[k], false, nil
+	}
+	s.quotas[k]++
+	return s.quotas[k], true, nil
+}
+
+// purge drops the quota counters of earlier days and the full buckets when now starts a new
+// UTC day. s.mu must be held.
+func (s *MemoryStore) purge(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if day == s.day {
+		return
+	}
+	s.day = day
+	for k := range s.quotas {
+		if k.day < day {
+			delete(s.quotas, k)
+		}
+	}
+	for bucketKey, b := range s.buckets {
+		if b.refill(now) >= float64(b.burst) {
+			delete(s.buckets, bucketKey)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyBy picks what a client's buckets are keyed by.
+type KeyBy string
+
+const (
+	// KeyByCredential keys authenticated requests by their principal and the rest by client IP.
+	KeyByCredential KeyBy = "credential"
+	// KeyByIP keys every request by client IP.
+	KeyByIP KeyBy = "ip"
+)
+
+// ParseKeyBy parses "credential" or "ip".
+func ParseKeyBy(value string) (KeyBy, error) {
+	switch keyBy := KeyBy(strings.ToLower(strings.TrimSpace(value))); keyBy {
+	case KeyByCredential, KeyByIP:
+		return keyBy, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit key %q", value)
+	}
+}
+
+// Limit is a token bucket that refills at Rate tokens a second and holds at most Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses "<rate>:<burst>", e.g. "10:20".
+func ParseLimit(value string) (Limit, error) {
+	rateValue, burstValue, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found {
+		return Limit{}, fmt.Errorf("rate limit %q is not <rate>:<burst>", value)
+	}
+	rate, rateErr := strconv.ParseFloat(rateValue, 64)
+	if rateErr != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q has an invalid rate", value)
+	}
+	burst, burstErr := strconv.Atoi(burstValue)
+	if burstErr != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("rate limit %q has an invalid burst", value)
+	}
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseRouteLimits parses comma separated "<METHOD> <route>=<rate>:<burst>" overrides, e.g.
+// "GET /v1.0/users_with_age=1:5,POST /v1.0/user=2:2".
+func ParseRouteLimits(value string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		route, limitValue, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("route rate limit %q is not <METHOD> <route>=<rate>:<burst>", entry)
+		}
+		limit, limitErr := ParseLimit(limitValue)
+		if limitErr != nil {
+			return nil, limitErr
+		}
+		limits[RouteKey(strings.Fields(route)...)] = limit
+	}
+	return limits, nil
+}
+
+// RouteKey is the Config.Routes key for a method and route template, e.g. "GET /v1.0/user".
+func RouteKey(parts ...string) string {
+	if len(parts) > 0 {
+		parts[0] = strings.ToUpper(parts[0])
+	}
+	return strings.Join(parts, " ")
+}
+
+// Config configures a Limiter.
+type Config struct {
+	// Default applies to every route without an override. Each client shares one bucket across
+	// those routes.
+	Default Limit
+	// Routes overrides Default for a route, keyed by RouteKey. Each override has its own bucket.
+	Routes map[string]Limit
+	// DailyQuota caps each client's requests per UTC day. Zero means no quota.
+	DailyQuota int
+	KeyBy      KeyBy
+	// IPLimit applies to each client IP before authentication, see AllowIP. Defaults to Default.
+	IPLimit Limit
+}
+
+// Store keeps token buckets and quota counters. *db.Client and MemoryStore implement it.
+type Store interface {
+	// TakeToken takes one token from bucket key and returns the tokens left and whether one
+	// could be taken.
+	TakeToken(ctx context.Context, key string, rate float64, burst int, now time.Time) (float64, bool, error)
+	// IncrementQuota counts one use of quota key on day unless quota uses were already counted.
+	IncrementQuota(ctx context.Context, key, day string, quota int) (int, bool, error)
+}
+
+// Decision is the outcome of Limiter.Allow.
+type Decision struct {
+	Allowed bool
+	// Limit is the burst, or the daily quota when that was exceeded.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again, or until the quota resets.
+	Reset time.Duration
+	// RetryAfter is how long until the request would be allowed. Zero when Allowed.
+	RetryAfter    time.Duration
+	QuotaExceeded bool
+}
+
+// Limiter decides whether a client may make a request.
+type Limiter struct {
+	store  Store
+	config Config
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, config Config) *Limiter {
+	if config.KeyBy == "" {
+		config.KeyBy = KeyByCredential
+	}
+	if config.IPLimit.Rate == 0 {
+		config.IPLimit = config.Default
+	}
+	return &Limiter{
+		store:  store,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+func (l *Limiter) KeyBy() KeyBy {
+	return l.config.KeyBy
+}
+
+// Allow takes a token from client's bucket for route, a RouteKey, and then counts the request
+// against client's daily quota.
+func (l *Limiter) Allow(ctx context.Context, client, route string) (*Decision, error) {
+	now := l.now()
+	limit, bucketKey := l.config.Default, client+"|*"
+	if routeLimit, found := l.config.Routes[route]; found {
+		limit, bucketKey = routeLimit, client+"|"+route
+	}
+	decision, takeErr := l.take(ctx, bucketKey, limit, now)
+	if takeErr != nil {
+		return nil, takeErr
+	}
+	if !decision.Allowed || l.config.DailyQuota <= 0 {
+		return decision, nil
+	}
+	day := now.UTC()
+	used, counted, quotaErr := l.store.IncrementQuota(ctx, client, day.Format(time.DateOnly), l.config.DailyQuota)
+	if quotaErr != nil {
+		return nil, quotaErr
+	}
+	if counted {
+		return decision, nil
+	}
+	untilTomorrow := day.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(day)
+	return &Decision{
+		Limit:         l.config.DailyQuota,
+		Remaining:     max(0, l.config.DailyQuota-used),
+		Reset:         untilTomorrow,
+		RetryAfter:    untilTomorrow,
+		QuotaExceeded: true,
+	}, nil
+}
+
+// AllowIP takes a token from the IPLimit bucket of client, a client IP. It runs before the
+// request is authenticated, so unauthenticated floods are limited too, and counts nothing
+// against the daily quota.
+func (l *Limiter) AllowIP(ctx context.Context, client string) (*Decision, error) {
+	return l.take(ctx, client+"|ip", l.config.IPLimit, l.now())
+}
+
+func (l *Limiter) take(ctx context.Context, bucketKey string, limit Limit, now time.Time) (*Decision, error) {
+	tokens, allowed, takeErr := l.store.TakeToken(ctx, bucketKey, limit.Rate, limit.Burst, now)
+	if takeErr != nil {
+		return nil, takeErr
+	}
+	decision := &Decision{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		decision.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	return decision, nil
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Max(0, value) * float64(time.Second))
+}
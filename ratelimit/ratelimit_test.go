@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC)
+	limiter := NewLimiter(NewMemoryStore(), Config{
+		Default: Limit{Rate: 1, Burst: 2},
+		Routes:  map[string]Limit{RouteKey("POST", "/v1.0/user"): {Rate: 0.5, Burst: 1}},
+	})
+	limiter.now = func() time.Time { return now }
+
+	for _, remaining := range []int{1, 0} {
+		decision, allowErr := limiter.Allow(ctx, "api_key:1", RouteKey("GET", "/v1.0/users"))
+		r.NoError(allowErr)
+		r.True(decision.Allowed)
+		r.Equal(remaining, decision.Remaining)
+	}
+	decision, allowErr := limiter.Allow(ctx, "api_key:1", RouteKey("GET", "/v1.0/user"))
+	r.NoError(allowErr)
+	r.False(decision.Allowed)
+	r.Equal(time.Second, decision.RetryAfter)
+	r.Equal(2*time.Second, decision.Reset)
+
+	decision, allowErr = limiter.Allow(ctx, "api_key:1", RouteKey("POST", "/v1.0/user"))
+	r.NoError(allowErr)
+	r.True(decision.Allowed)
+	decision, allowErr = limiter.Allow(ctx, "api_key:1", RouteKey("POST", "/v1.0/user"))
+	r.NoError(allowErr)
+	r.False(decision.Allowed)
+	r.Equal(2*time.Second, decision.RetryAfter)
+
+	decision, allowErr = limiter.Allow(ctx, "api_key:2", RouteKey("GET", "/v1.0/users"))
+	r.NoError(allowErr)
+	r.True(decision.Allowed)
+
+	// Tokens refill over time
+	now = now.Add(time.Second)
+	decision, allowErr = limiter.Allow(ctx, "api_key:1", RouteKey("GET", "/v1.0/users"))
+	r.NoError(allowErr)
+	r.True(decision.Allowed)
+	r.Equal(0, decision.Remaining)
+}
+
+func TestLimiterDailyQuota(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(NewMemoryStore(), Config{
+		Default:    Limit{Rate: 100, Burst: 100},
+		DailyQuota: 2,
+	})
+	limiter.now = func() time.Time { return now }
+
+	for range 2 {
+		decision, allowErr := limiter.Allow(ctx, "api_key:1", "GET /v1.0/users")
+		r.NoError(allowErr)
+		r.True(decision.Allowed)
+	}
+	decision, allowErr := limiter.Allow(ctx, "api_key:1", "GET /v1.0/users")
+	r.NoError(allowErr)
+	r.False(decision.Allowed)
+	r.True(decision.QuotaExceeded)
+	r.Equal(2, decision.Limit)
+	r.Equal(0, decision.Remaining)
+	r.Equal(time.Hour, decision.RetryAfter)
+
+	// Quotas reset at midnight UTC
+	now = now.Add(time.Hour)
+	decision, allowErr = limiter.Allow(ctx, "api_key:1", "GET /v1.0/users")
+	r.NoError(allowErr)
+	r.True(decision.Allowed)
+}
+
+func TestLimiterAllowIP(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), Config{
+		Default:    Limit{Rate: 1, Burst: 5},
+		DailyQuota: 1,
+		IPLimit:    Limit{Rate: 1, Burst: 1},
+	})
+	limiter.now = func() time.Time { return time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) }
+
+	decision, allowErr := limiter.AllowIP(ctx, "ip:192.0.2.1")
+	r.NoError(allowErr)
+	r.True(decision.Allowed)
+	r.Equal(1, decision.Limit)
+	decision, allowErr = limiter.AllowIP(ctx, "ip:192.0.2.1")
+	r.NoError(allowErr)
+	r.False(decision.Allowed)
+	// The IP bucket and the daily quota are separate from the client's
+	decision, allowErr = limiter.Allow(ctx, "ip:192.0.2.1", "GET /v1.0/users")
+	r.NoError(allowErr)
+	r.True(decision.Allowed)
+	r.Equal(5, decision.Limit)
+}
+
+func TestParseRouteLimits(t *testing.T) {
+	r := require.New(t)
+	limits, limitsErr := ParseRouteLimits("get /v1.0/users_with_age=1:5, POST /v1.0/user=0.5:2")
+	r.NoError(limitsErr)
+	r.Equal(map[string]Limit{
+		"GET /v1.0/users_with_age": {Rate: 1, Burst: 5},
+		"POST /v1.0/user":          {Rate: 0.5, Burst: 2},
+	}, limits)
+	_, limitsErr = ParseRouteLimits("GET /v1.0/users=fast")
+	r.Error(limitsErr)
+	_, limitErr := ParseLimit("1:0")
+	r.Error(limitErr)
+}
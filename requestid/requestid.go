@@ -0,0 +1,65 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header carries request ids in and out of the service.
+const Header = "X-Request-ID"
+
+const maxLength = 128
+
+// New returns a random request id.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether a caller supplied id is safe to log and echo: non empty, at most 128
+// characters and printable ASCII.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type idKey struct{}
+
+// WithId returns a copy of ctx carrying request id.
+func WithId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the request id set by WithId.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(idKey{}).(string)
+	return id, ok
+}
+
+// Transport sets the X-Request-ID header of outgoing requests whose context carries a request
+// id, so calls to other services can be correlated with the request that made them.
+type Transport struct {
+	// Base defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if id, ok := FromContext(req.Context()); ok && req.Header.Get(Header) == "" {
+		// RoundTrippers must not modify the request they're given.
+		req = req.Clone(req.Context())
+		req.Header.Set(Header, id)
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,40 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValid(t *testing.T) {
+	r := require.New(t)
+	r.True(Valid(New()))
+	r.True(Valid("req-123"))
+	r.False(Valid(""))
+	r.False(Valid(strings.Repeat("a", maxLength+1)))
+	r.False(Valid("line\nbreak"))
+}
+
+func TestTransport(t *testing.T) {
+	r := require.New(t)
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = append(received, req.Header.Get(Header))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	for _, ctx := range []context.Context{WithId(context.Background(), "request-1"), context.Background()} {
+		req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		r.NoError(reqErr)
+		resp, respErr := client.Do(req)
+		r.NoError(respErr)
+		r.NoError(resp.Body.Close())
+		r.Empty(req.Header.Get(Header))
+	}
+	r.Equal([]string{"request-1", ""}, received)
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryObserver is a db.QueryObserver that wraps each statement in a client span.
+type QueryObserver struct {
+	tracer trace.Tracer
+}
+
+func NewQueryObserver(provider trace.TracerProvider) *QueryObserver {
+	return &QueryObserver{tracer: Tracer(provider)}
+}
+
+func (o *QueryObserver) ObserveQuery(ctx context.Context, name, query string) (context.Context, func(error)) {
+	ctx, span := o.tracer.Start(ctx, "db "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "sqlite"),
+			attribute.String("db.operation.name", name),
+			attribute.String("db.query.text", SanitizeSQL(query)),
+		),
+	)
+	return ctx, func(err error) {
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+var (
+	stringLiteralPattern  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numericLiteralPattern = regexp.MustCompile(`\??\b\d+(?:\.\d+)?\b`)
+	whitespacePattern     = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL replaces literals in query with ? so spans never carry values, and collapses
+// whitespace. Numbered placeholders like ?1 are kept.
+func SanitizeSQL(query string) string {
+	query = stringLiteralPattern.ReplaceAllString(query, "?")
+	query = numericLiteralPattern.ReplaceAllStringFunc(query, func(literal string) string {
+		if strings.HasPrefix(literal, "?") {
+			return literal
+		}
+		return "?"
+	})
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(query, " "))
+}
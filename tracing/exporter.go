@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// WriterExporter writes each span as a line of JSON, for local development and tests.
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter appends spans to the file at path, which Shutdown closes.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, fileErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if fileErr != nil {
+		return nil, fileErr
+	}
+	return &WriterExporter{w: file, closer: file}, nil
+}
+
+// ExportedSpan is the JSON written for each span.
+type ExportedSpan struct {
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	TraceId      string         `json:"trace_id"`
+	SpanId       string         `json:"span_id"`
+	ParentSpanId string         `json:"parent_span_id,omitempty"`
+	Start        time.Time      `json:"start"`
+	Duration     time.Duration  `json:"duration_ns"`
+	Status       string         `json:"status"`
+	StatusDetail string         `json:"status_detail,omitempty"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+}
+
+func (e *WriterExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		exported := ExportedSpan{
+			Name:         span.Name(),
+			Kind:         span.SpanKind().String(),
+			TraceId:      span.SpanContext().TraceID().String(),
+			SpanId:       span.SpanContext().SpanID().String(),
+			Start:        span.StartTime(),
+			Duration:     span.EndTime().Sub(span.StartTime()),
+			Status:       span.Status().Code.String(),
+			StatusDetail: span.Status().Description,
+		}
+		if span.Parent().IsValid() {
+			exported.ParentSpanId = span.Parent().SpanID().String()
+		}
+		if attributes := span.Attributes(); len(attributes) > 0 {
+			exported.Attributes = make(map[string]any, len(attributes))
+			for _, attribute := range attributes {
+				exported.Attributes[string(attribute.Key)] = attribute.Value.AsInterface()
+			}
+		}
+		if err := encoder.Encode(exported); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown closes the file of a NewFileExporter.
+func (e *WriterExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names the tracer of the spans the service creates.
+const InstrumentationName = "github.com/brandonrachal/gin-and-tonic"
+
+// Propagator reads and writes W3C traceparent, tracestate and baggage headers.
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Exporter picks where spans are sent.
+type Exporter string
+
+const (
+	// ExporterNone records no spans. Incoming trace context is still passed on.
+	ExporterNone Exporter = "none"
+	// ExporterOTLP sends spans over OTLP/HTTP, configured with the standard OTEL_EXPORTER_OTLP_*
+	// environment variables.
+	ExporterOTLP Exporter = "otlp"
+	// ExporterStdout writes spans to stdout as JSON lines.
+	ExporterStdout Exporter = "stdout"
+	// ExporterFile appends spans to Config.File as JSON lines.
+	ExporterFile Exporter = "file"
+)
+
+// ParseExporter parses "none", "otlp", "stdout" or "file".
+func ParseExporter(value string) (Exporter, error) {
+	switch exporter := Exporter(strings.ToLower(strings.TrimSpace(value))); exporter {
+	case ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile:
+		return exporter, nil
+	default:
+		return "", fmt.Errorf("unknown tracing exporter %q", value)
+	}
+}
+
+type Config struct {
+	Exporter    Exporter
+	File        string
+	ServiceName string
+	// SampleRatio is the share of new traces recorded, from 0 to 1. Requests that arrive with a
+	// sampled trace context are always recorded.
+	SampleRatio float64
+	// Headers are added to OTLP requests, e.g. a collector API key.
+	Headers map[string]string
+}
+
+// ParseHeaders parses comma separated name=value pairs, e.g. "x-api-key=abc,x-team=ops".
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, headerValue, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("tracing header %q must be name=value", pair)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	}
+	return headers, nil
+}
+
+// NewTracerProvider returns a provider exporting spans as config says. Shut it down to flush
+// the spans still buffered.
+func NewTracerProvider(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", config.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	}
+	switch config.Exporter {
+	case ExporterNone, "":
+	case ExporterOTLP:
+		var otlpOptions []otlptracehttp.Option
+		if len(config.Headers) > 0 {
+			otlpOptions = append(otlpOptions, otlptracehttp.WithHeaders(config.Headers))
+		}
+		exporter, exporterErr := otlptracehttp.New(ctx, otlpOptions...)
+		if exporterErr != nil {
+			return nil, exporterErr
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case ExporterStdout:
+		options = append(options, sdktrace.WithBatcher(NewWriterExporter(os.Stdout)))
+	case ExporterFile:
+		if config.File == "" {
+			return nil, errors.New("the file tracing exporter needs a file")
+		}
+		exporter, exporterErr := NewFileExporter(config.File)
+		if exporterErr != nil {
+			return nil, exporterErr
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", config.Exporter)
+	}
+	return sdktrace.NewTracerProvider(options...), nil
+}
+
+// Tracer returns the service's tracer from provider.
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	return provider.Tracer(InstrumentationName)
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	r := require.New(t)
+	r.Equal("select id from users where tenant_id = ? and email = ?",
+		SanitizeSQL("select id from users\n\t\twhere tenant_id = ? and email = ?"))
+	r.Equal("insert into organizations(slug, created_at) values (?, strftime(?, ?))",
+		SanitizeSQL("insert into organizations(slug, created_at) values ('acme', strftime('%s', 'now'))"))
+	r.Equal("select * from t1 where a = ? and b = ?1 and c < ?",
+		SanitizeSQL("select * from t1 where a = 42 and b = ?1 and c < 0.5"))
+	r.Equal("select ? from users where name = ?", SanitizeSQL("select 1 from users where name = 'O''Brien'"))
+}
+
+func TestParseHeaders(t *testing.T) {
+	r := require.New(t)
+	headers, err := ParseHeaders("x-api-key=abc=, x-team = ops,")
+	r.NoError(err)
+	r.Equal(map[string]string{"x-api-key": "abc=", "x-team": "ops"}, headers)
+	_, err = ParseHeaders("x-api-key")
+	r.Error(err)
+}
+
+func TestQueryObserver(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(NewWriterExporter(&buf)))
+	observer := NewQueryObserver(provider)
+
+	parentCtx, parent := Tracer(provider).Start(context.Background(), "GET /v1.0/user")
+	_, done := observer.ObserveQuery(parentCtx, "get_user", "select * from users where id = ? and name = 'x'")
+	done(sql.ErrNoRows)
+	_, done = observer.ObserveQuery(parentCtx, "create_user", "insert into users(email) values (?)")
+	done(errors.New("UNIQUE constraint failed"))
+	parent.End()
+	r.NoError(provider.Shutdown(context.Background()))
+
+	var spans []ExportedSpan
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var span ExportedSpan
+		r.NoError(decoder.Decode(&span))
+		spans = append(spans, span)
+	}
+	r.Len(spans, 3)
+	getUser, createUser, request := spans[0], spans[1], spans[2]
+	r.Equal("db get_user", getUser.Name)
+	r.Equal("client", getUser.Kind)
+	r.Equal(request.SpanId, getUser.ParentSpanId)
+	r.Equal(request.TraceId, getUser.TraceId)
+	r.Equal("select * from users where id = ? and name = ?", getUser.Attributes["db.query.text"])
+	r.Equal("Unset", getUser.Status)
+	r.Equal("Error", createUser.Status)
+	r.Equal("UNIQUE constraint failed", createUser.StatusDetail)
+}
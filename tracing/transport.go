@@ -0,0 +1,25 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Transport adds the trace context of each outgoing request's context to its headers, so the
+// called service can continue the trace.
+type Transport struct {
+	// Base defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// RoundTrippers must not modify the request they're given.
+	req = req.Clone(req.Context())
+	Propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return base.RoundTrip(req)
+}
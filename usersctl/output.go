@@ -0,0 +1,172 @@
+package usersctl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brandonrachal/gin-and-tonic/models"
+)
+
+type Format string
+
+const (
+	Table Format = "table"
+	JSON  Format = "json"
+	CSV   Format = "csv"
+)
+
+// Formats are the values of --output, for completions.
+var Formats = []string{string(Table), string(JSON), string(CSV)}
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(strings.ToLower(format)) {
+	case Table:
+		return Table, nil
+	case JSON:
+		return JSON, nil
+	case CSV:
+		return CSV, nil
+	}
+	return "", fmt.Errorf("unknown output format %q", format)
+}
+
+var (
+	userColumns        = []string{"id", "first_name", "last_name", "email", "birthday"}
+	userWithAgeColumns = append(userColumns[:len(userColumns):len(userColumns)], "age_in_years")
+	ageStatsColumns    = []string{"bracket", "users"}
+)
+
+func userRow(user models.User) []string {
+	return []string{strconv.FormatInt(user.Id, 10), user.FirstName, user.LastName, user.Email, birthday(user)}
+}
+
+func userWithAgeRow(user models.UserWithAge) []string {
+	return append(userRow(user.User), strconv.Itoa(user.AgeInYears))
+}
+
+// birthday is empty rather than the zero date when the API redacted it.
+func birthday(user models.User) string {
+	if user.Birthday.ToTime().IsZero() {
+		return ""
+	}
+	return user.Birthday.String()
+}
+
+// ageBracket is a row of the stats table, named like its json field.
+type ageBracket struct {
+	Name  string
+	Users int
+}
+
+func ageBrackets(stats models.AgeStats) []ageBracket {
+	return []ageBracket{
+		{"preteen", stats.Preteen},
+		{"teens", stats.Teen},
+		{"twenties", stats.Twenties},
+		{"thirties", stats.Thirties},
+		{"forties", stats.Forties},
+		{"fifties", stats.Fifties},
+		{"sixties", stats.Sixties},
+		{"seventies", stats.Seventies},
+		{"eighties", stats.Eighties},
+		{"nineties", stats.Nineties},
+		{"centurion", stats.Centurion},
+	}
+}
+
+func ageBracketRow(bracket ageBracket) []string {
+	return []string{bracket.Name, strconv.Itoa(bracket.Users)}
+}
+
+type printer struct {
+	format Format
+	out    io.Writer
+}
+
+// printRows prints items as a table, CSV or a JSON array, as they come. row gives the columns
+// of an item for tables and CSV, while JSON uses the item's own encoding.
+func printRows[T any](p printer, columns []string, items iter.Seq2[T, error], row func(T) []string) error {
+	switch p.format {
+	case JSON:
+		separator := "[\n  "
+		for item, err := range items {
+			if err != nil {
+				return err
+			}
+			data, dataErr := json.MarshalIndent(item, "  ", "  ")
+			if dataErr != nil {
+				return dataErr
+			}
+			if _, err = fmt.Fprintf(p.out, "%s%s", separator, data); err != nil {
+				return err
+			}
+			separator = ",\n  "
+		}
+		if separator == "[\n  " {
+			_, err := fmt.Fprintln(p.out, "[]")
+			return err
+		}
+		_, err := fmt.Fprintln(p.out, "\n]")
+		return err
+	case CSV:
+		writer := csv.NewWriter(p.out)
+		if err := writer.Write(columns); err != nil {
+			return err
+		}
+		for item, err := range items {
+			if err != nil {
+				return err
+			}
+			if err = writer.Write(row(item)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		writer := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = strings.ToUpper(strings.ReplaceAll(column, "_", " "))
+		}
+		if _, err := fmt.Fprintln(writer, strings.Join(header, "\t")); err != nil {
+			return err
+		}
+		for item, err := range items {
+			if err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintln(writer, strings.Join(row(item), "\t")); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	}
+}
+
+// printRow prints item as a one row table or CSV, or as a JSON object.
+func printRow[T any](p printer, columns []string, item T, row func(T) []string) error {
+	if p.format == JSON {
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(item)
+	}
+	return printRows(p, columns, values([]T{item}), row)
+}
+
+// values yields the items of a slice with no errors.
+func values[T any](items []T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package usersctl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/models"
+)
+
+// Store is where the commands read and write users, the database or the API. *client.Client
+// implements it.
+type Store interface {
+	CreateUser(ctx context.Context, user models.CreateUser) (int64, error)
+	GetUser(ctx context.Context, id int64) (*models.User, error)
+	UpdateUser(ctx context.Context, user models.User) error
+	DeleteUser(ctx context.Context, id int64) error
+	ListUsers(ctx context.Context) iter.Seq2[models.User, error]
+	UsersWithAge(ctx context.Context) ([]models.UserWithAge, error)
+	AgeStats(ctx context.Context) (*models.AgeStats, error)
+}
+
+// dbStore works on the users of one tenant in the database file, without going through the
+// API and its permission checks.
+type dbStore struct {
+	dbClient *db.Client
+	tenantId int64
+}
+
+func (s *dbStore) CreateUser(ctx context.Context, user models.CreateUser) (int64, error) {
+	result, resultErr := s.dbClient.CreateUser(db.WithTenant(ctx, s.tenantId), user.FirstName, user.LastName, user.Email, user.Birthday.ToTime())
+	if resultErr != nil {
+		return 0, resultErr
+	}
+	return result.LastInsertId()
+}
+
+func (s *dbStore) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	user, userErr := s.dbClient.GetUser(db.WithTenant(ctx, s.tenantId), id)
+	if errors.Is(userErr, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no user with id %d", id)
+	}
+	return user, userErr
+}
+
+func (s *dbStore) UpdateUser(ctx context.Context, user models.User) error {
+	result, resultErr := s.dbClient.UpdateUser(db.WithTenant(ctx, s.tenantId), user.Id, user.FirstName, user.LastName, user.Email, user.Birthday.ToTime())
+	if resultErr != nil {
+		return resultErr
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("no user with id %d", user.Id)
+	}
+	return nil
+}
+
+func (s *dbStore) DeleteUser(ctx context.Context, id int64) error {
+	result, resultErr := s.dbClient.DeleteUser(db.WithTenant(ctx, s.tenantId), id)
+	if resultErr != nil {
+		return resultErr
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("no user with id %d", id)
+	}
+	return nil
+}
+
+func (s *dbStore) ListUsers(ctx context.Context) iter.Seq2[models.User, error] {
+	return s.dbClient.IterUsers(db.WithTenant(ctx, s.tenantId))
+}
+
+func (s *dbStore) UsersWithAge(ctx context.Context) ([]models.UserWithAge, error) {
+	return s.dbClient.GetUsersWithAge(db.WithTenant(ctx, s.tenantId))
+}
+
+func (s *dbStore) AgeStats(ctx context.Context) (*models.AgeStats, error) {
+	return s.dbClient.GetAgeStats(db.WithTenant(ctx, s.tenantId))
+}
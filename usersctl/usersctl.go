@@ -0,0 +1,431 @@
+// Package usersctl is the usersctl command, which manages users either directly in the
+// database file or remotely through the API.
+package usersctl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/brandonrachal/gin-and-tonic/client"
+	"github.com/brandonrachal/gin-and-tonic/db"
+	"github.com/brandonrachal/gin-and-tonic/internal"
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/brandonrachal/go-toolbox/jsonutils"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// ServerEnv and TokenEnv are the defaults of --server and --token, so the token stays out
+	// of the shell history.
+	ServerEnv = "USERSCTL_SERVER"
+	TokenEnv  = "USERSCTL_TOKEN"
+
+	defaultTenant = "default"
+)
+
+// app holds the global flags.
+type app struct {
+	env    string
+	dbPath string
+	server string
+	token  string
+	tenant string
+	output string
+	yes    bool
+}
+
+// NewCommand returns the root command. It reads and writes through the command's
+// InOrStdin, OutOrStdout and ErrOrStderr.
+func NewCommand() *cobra.Command {
+	a := &app{}
+	root := &cobra.Command{
+		Use:   "usersctl",
+		Short: "Manage users in the database or through the API",
+		Long: `Manage users in the database or through the API.
+
+Without --server the commands work on the database file of --env, or --db, as the
+organization of --tenant. With --server they call the API with --token, which is
+then checked for the scopes and role each command needs.`,
+		SilenceUsage: true,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.env, "env", "prod", "database environment: prod, dev or test")
+	flags.StringVar(&a.dbPath, "db", "", "database file to use instead of the one of --env")
+	flags.StringVar(&a.server, "server", os.Getenv(ServerEnv), "base url of the API to call instead of using the database, $"+ServerEnv)
+	flags.StringVar(&a.token, "token", os.Getenv(TokenEnv), "API key or JWT for --server, $"+TokenEnv)
+	flags.StringVar(&a.tenant, "tenant", "", "slug of the organization, \"default\" for the database and the token's own for the API")
+	flags.StringVarP(&a.output, "output", "o", string(Table), "output format: table, json or csv")
+	flags.BoolVarP(&a.yes, "yes", "y", false, "don't ask before deleting")
+	_ = root.RegisterFlagCompletionFunc("env", cobra.FixedCompletions([]string{"prod", "dev", "test"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = root.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(Formats, cobra.ShellCompDirectiveNoFileComp))
+	root.MarkFlagsMutuallyExclusive("db", "server")
+
+	root.AddCommand(
+		a.createCommand(),
+		a.getCommand(),
+		a.updateCommand(),
+		a.deleteCommand(),
+		a.listCommand(),
+		a.searchCommand(),
+		a.statsCommand(),
+	)
+	return root
+}
+
+// open returns the store the flags point at and a function closing it.
+func (a *app) open(ctx context.Context) (Store, func(), error) {
+	if a.server != "" {
+		apiClient, apiClientErr := client.New(client.Config{BaseURL: a.server, Token: a.token, Tenant: a.tenant})
+		if apiClientErr != nil {
+			return nil, nil, apiClientErr
+		}
+		return apiClient, func() {}, nil
+	}
+	path := a.dbPath
+	if path == "" {
+		if !internal.IsEnv(a.env) {
+			return nil, nil, fmt.Errorf("unknown env %q", a.env)
+		}
+		path = internal.DBPath(a.env)
+	}
+	// Opening a missing file would create an empty database
+	if _, statErr := os.Stat(path); statErr != nil {
+		return nil, nil, fmt.Errorf("opening the database - %w", statErr)
+	}
+	dbClient, dbClientErr := db.NewClient(path)
+	if dbClientErr != nil {
+		return nil, nil, fmt.Errorf("opening the database - %w", dbClientErr)
+	}
+	slug := a.tenant
+	if slug == "" {
+		slug = defaultTenant
+	}
+	organization, organizationErr := dbClient.GetOrganizationBySlug(ctx, slug)
+	if organizationErr != nil {
+		_ = dbClient.Close()
+		return nil, nil, fmt.Errorf("looking up tenant %q - %w", slug, organizationErr)
+	}
+	return &dbStore{dbClient: dbClient, tenantId: organization.Id}, func() {
+		_ = dbClient.Close()
+	}, nil
+}
+
+// run opens the store for a command and prints with the --output format.
+func (a *app) run(fn func(ctx context.Context, cmd *cobra.Command, store Store, p printer, args []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, formatErr := ParseFormat(a.output)
+		if formatErr != nil {
+			return formatErr
+		}
+		store, closeStore, storeErr := a.open(cmd.Context())
+		if storeErr != nil {
+			return storeErr
+		}
+		defer closeStore()
+		return fn(cmd.Context(), cmd, store, printer{format: format, out: cmd.OutOrStdout()}, args)
+	}
+}
+
+// userFlags are the fields of a user set on the command line.
+type userFlags struct {
+	firstName string
+	lastName  string
+	email     string
+	birthday  string
+}
+
+func addUserFlags(cmd *cobra.Command) *userFlags {
+	f := &userFlags{}
+	cmd.Flags().StringVar(&f.firstName, "first-name", "", "first name")
+	cmd.Flags().StringVar(&f.lastName, "last-name", "", "last name")
+	cmd.Flags().StringVar(&f.email, "email", "", "email address")
+	cmd.Flags().StringVar(&f.birthday, "birthday", "", "birthday as "+jsonutils.SimpleDateFormat)
+	return f
+}
+
+// apply copies the flags that were set onto user and validates it like the API does.
+func (f *userFlags) apply(cmd *cobra.Command, user *models.CreateUser) error {
+	flags := cmd.Flags()
+	if flags.Changed("first-name") {
+		user.FirstName = strings.TrimSpace(f.firstName)
+	}
+	if flags.Changed("last-name") {
+		user.LastName = strings.TrimSpace(f.lastName)
+	}
+	if flags.Changed("email") {
+		user.Email = strings.TrimSpace(f.email)
+	}
+	if flags.Changed("birthday") {
+		if birthdayErr := user.Birthday.UnmarshalJSON([]byte(strings.TrimSpace(f.birthday))); birthdayErr != nil {
+			return fmt.Errorf("birthday %q is not a %s date", f.birthday, jsonutils.SimpleDateFormat)
+		}
+	}
+	return binding.Validator.ValidateStruct(user)
+}
+
+func (a *app) createCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create --first-name name --last-name name --email email --birthday date",
+		Short: "Create a user and print it",
+		Args:  cobra.NoArgs,
+	}
+	fields := addUserFlags(cmd)
+	for _, name := range []string{"first-name", "last-name", "email", "birthday"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+	cmd.RunE = a.run(func(ctx context.Context, cmd *cobra.Command, store Store, p printer, _ []string) error {
+		var user models.CreateUser
+		if err := fields.apply(cmd, &user); err != nil {
+			return err
+		}
+		id, createErr := store.CreateUser(ctx, user)
+		if createErr != nil {
+			return createErr
+		}
+		return printUser(ctx, store, p, id)
+	})
+	return cmd
+}
+
+func (a *app) getCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:               "get <id>",
+		Short:             "Print a user",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: a.completeIds(1),
+		RunE: a.run(func(ctx context.Context, _ *cobra.Command, store Store, p printer, args []string) error {
+			id, idErr := parseId(args[0])
+			if idErr != nil {
+				return idErr
+			}
+			return printUser(ctx, store, p, id)
+		}),
+	}
+}
+
+func (a *app) updateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "update <id> [--first-name name] [--last-name name] [--email email] [--birthday date]",
+		Short:             "Change some fields of a user and print it",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: a.completeIds(1),
+	}
+	fields := addUserFlags(cmd)
+	cmd.MarkFlagsOneRequired("first-name", "last-name", "email", "birthday")
+	cmd.RunE = a.run(func(ctx context.Context, cmd *cobra.Command, store Store, p printer, args []string) error {
+		id, idErr := parseId(args[0])
+		if idErr != nil {
+			return idErr
+		}
+		user, userErr := store.GetUser(ctx, id)
+		if userErr != nil {
+			return userErr
+		}
+		if err := fields.apply(cmd, &user.CreateUser); err != nil {
+			return err
+		}
+		if err := store.UpdateUser(ctx, *user); err != nil {
+			return err
+		}
+		return printUser(ctx, store, p, id)
+	})
+	return cmd
+}
+
+func (a *app) deleteCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:               "delete <id>...",
+		Short:             "Delete users, after asking unless --yes is set",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: a.completeIds(-1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, store Store, _ printer, args []string) error {
+			users := make([]*models.User, len(args))
+			for i, arg := range args {
+				id, idErr := parseId(arg)
+				if idErr != nil {
+					return idErr
+				}
+				user, userErr := store.GetUser(ctx, id)
+				if userErr != nil {
+					return userErr
+				}
+				users[i] = user
+			}
+			if !a.yes {
+				var prompt strings.Builder
+				fmt.Fprintf(&prompt, "About to delete %d user(s):\n", len(users))
+				for _, user := range users {
+					fmt.Fprintf(&prompt, "  %s\n", describe(*user))
+				}
+				prompt.WriteString("Continue?")
+				confirmed, confirmErr := confirm(cmd.InOrStdin(), cmd.ErrOrStderr(), prompt.String())
+				if confirmErr != nil {
+					return confirmErr
+				} else if !confirmed {
+					return errors.New("nothing deleted")
+				}
+			}
+			for _, user := range users {
+				if err := store.DeleteUser(ctx, user.Id); err != nil {
+					return fmt.Errorf("deleting user %d - %w", user.Id, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Deleted user %d\n", user.Id)
+			}
+			return nil
+		}),
+	}
+}
+
+func (a *app) listCommand() *cobra.Command {
+	var withAge bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Print every user",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, _ *cobra.Command, store Store, p printer, _ []string) error {
+			if !withAge {
+				return printRows(p, userColumns, store.ListUsers(ctx), userRow)
+			}
+			users, usersErr := store.UsersWithAge(ctx)
+			if usersErr != nil {
+				return usersErr
+			}
+			return printRows(p, userWithAgeColumns, values(users), userWithAgeRow)
+		}),
+	}
+	cmd.Flags().BoolVar(&withAge, "with-age", false, "add each user's age in years")
+	return cmd
+}
+
+func (a *app) searchCommand() *cobra.Command {
+	var minAge, maxAge int
+	cmd := &cobra.Command{
+		Use:   "search [query] [--min-age years] [--max-age years]",
+		Short: "Print the users whose name or email contains query, within an age range",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, store Store, p printer, args []string) error {
+			query := ""
+			if len(args) == 1 {
+				query = strings.ToLower(strings.TrimSpace(args[0]))
+			}
+			minAgeSet, maxAgeSet := cmd.Flags().Changed("min-age"), cmd.Flags().Changed("max-age")
+			if query == "" && !minAgeSet && !maxAgeSet {
+				return errors.New("expected a query, --min-age or --max-age")
+			}
+			users, usersErr := store.UsersWithAge(ctx)
+			if usersErr != nil {
+				return usersErr
+			}
+			users = slices.DeleteFunc(users, func(user models.UserWithAge) bool {
+				return (minAgeSet && user.AgeInYears < minAge) || (maxAgeSet && user.AgeInYears > maxAge) || !matches(user.User, query)
+			})
+			return printRows(p, userWithAgeColumns, values(users), userWithAgeRow)
+		}),
+	}
+	cmd.Flags().IntVar(&minAge, "min-age", 0, "youngest age in years to include")
+	cmd.Flags().IntVar(&maxAge, "max-age", 0, "oldest age in years to include")
+	return cmd
+}
+
+// matches reports whether the full name or email of user contains the lower case query.
+func matches(user models.User, query string) bool {
+	if query == "" {
+		return true
+	}
+	for _, field := range []string{user.FirstName + " " + user.LastName, user.Email} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *app) statsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Print how many users are in each age bracket",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, _ *cobra.Command, store Store, p printer, _ []string) error {
+			stats, statsErr := store.AgeStats(ctx)
+			if statsErr != nil {
+				return statsErr
+			}
+			if p.format == JSON {
+				return printRow(p, nil, *stats, nil)
+			}
+			return printRows(p, ageStatsColumns, values(ageBrackets(*stats)), ageBracketRow)
+		}),
+	}
+}
+
+func printUser(ctx context.Context, store Store, p printer, id int64) error {
+	user, userErr := store.GetUser(ctx, id)
+	if userErr != nil {
+		return userErr
+	}
+	return printRow(p, userColumns, *user, userRow)
+}
+
+// completeIds completes user ids, described by name and email, for up to count arguments or
+// any number when count is negative.
+func (a *app) completeIds(count int) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		if count >= 0 && len(args) >= count {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		store, closeStore, storeErr := a.open(cmd.Context())
+		if storeErr != nil {
+			cobra.CompErrorln(storeErr.Error())
+			return nil, cobra.ShellCompDirectiveError
+		}
+		defer closeStore()
+		var completions []cobra.Completion
+		for user, err := range store.ListUsers(cmd.Context()) {
+			if err != nil {
+				cobra.CompErrorln(err.Error())
+				return nil, cobra.ShellCompDirectiveError
+			}
+			id := strconv.FormatInt(user.Id, 10)
+			if strings.HasPrefix(id, toComplete) && !slices.Contains(args, id) {
+				completions = append(completions, cobra.CompletionWithDesc(id, describe(user)))
+			}
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// describe names a user in prompts and completions.
+func describe(user models.User) string {
+	description := fmt.Sprintf("%d %s %s", user.Id, user.FirstName, user.LastName)
+	if user.Email != "" {
+		description += " <" + user.Email + ">"
+	}
+	return description
+}
+
+// confirm asks a yes or no question, and anything but yes, including no input, is a no.
+func confirm(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, answerErr := bufio.NewReader(in).ReadString('\n')
+	if answerErr != nil && !errors.Is(answerErr, io.EOF) {
+		return false, answerErr
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func parseId(arg string) (int64, error) {
+	id, idErr := strconv.ParseInt(arg, 10, 64)
+	if idErr != nil || id <= 0 {
+		return 0, fmt.Errorf("%q is not a user id", arg)
+	}
+	return id, nil
+}
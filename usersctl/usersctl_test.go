@@ -0,0 +1,186 @@
+package usersctl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brandonrachal/gin-and-tonic/controllers"
+	"github.com/brandonrachal/gin-and-tonic/internal/testenv"
+	"github.com/brandonrachal/gin-and-tonic/logging"
+	"github.com/brandonrachal/gin-and-tonic/models"
+	"github.com/brandonrachal/gin-and-tonic/usersctl"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	dbPath    string
+	serverURL string
+	apiKey    string
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	exitCode := testMain(m)
+	os.Exit(exitCode)
+}
+
+// testMain migrates a database of its own and serves the API from it, so both backends see the
+// same users.
+func testMain(m *testing.M) int {
+	logger := logging.New(os.Stdout, logging.FormatText, slog.LevelError)
+	env, envErr := testenv.New(context.Background(), "usersctl_test", logger, controllers.RouterOptions{})
+	if envErr != nil {
+		log.Printf("Error: Couldn't set up the test env - %s\n", envErr)
+		return 1
+	}
+	defer func() {
+		_ = env.Close()
+	}()
+	server := httptest.NewServer(env.Router)
+	defer server.Close()
+	dbPath, serverURL, apiKey = env.Path, server.URL, env.APIKey
+	return m.Run()
+}
+
+// backends return the flags selecting the database or the API.
+var backends = map[string]func() []string{
+	"db": func() []string {
+		return []string{"--db", dbPath}
+	},
+	"server": func() []string {
+		return []string{"--server", serverURL, "--token", apiKey}
+	},
+}
+
+// run runs usersctl with args after the backend flags, answering prompts with stdin.
+func run(backend []string, stdin string, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := usersctl.NewCommand()
+	cmd.SetArgs(append(slices.Clone(backend), args...))
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestUsersctl(t *testing.T) {
+	for name, flags := range backends {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+			backend := flags()
+			email := name + "@example.com"
+
+			stdout, _, err := run(backend, "", "create", "--first-name", "Ada", "--last-name", "Lovelace", "--email", email, "--birthday", "1985-12-10", "-o", "json")
+			r.NoError(err)
+			var created models.User
+			r.NoError(json.Unmarshal([]byte(stdout), &created))
+			r.NotZero(created.Id)
+			r.Equal("1985-12-10", created.Birthday.String())
+			id := strconv.FormatInt(created.Id, 10)
+
+			_, _, err = run(backend, "", "create", "--first-name", "Ada", "--last-name", "Lovelace", "--email", email, "--birthday", "10/12/1985")
+			r.ErrorContains(err, "is not a 2006-01-02 date")
+
+			stdout, _, err = run(backend, "", "update", id, "--first-name", "Augusta", "-o", "csv")
+			r.NoError(err)
+			records, recordsErr := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+			r.NoError(recordsErr)
+			r.Equal([][]string{
+				{"id", "first_name", "last_name", "email", "birthday"},
+				{id, "Augusta", "Lovelace", email, "1985-12-10"},
+			}, records)
+			_, _, err = run(backend, "", "update", id)
+			r.Error(err)
+
+			stdout, _, err = run(backend, "", "get", id)
+			r.NoError(err)
+			lines := strings.Split(strings.TrimSpace(stdout), "\n")
+			r.Len(lines, 2)
+			r.Equal([]string{"ID", "FIRST", "NAME", "LAST", "NAME", "EMAIL", "BIRTHDAY"}, strings.Fields(lines[0]))
+			r.Equal([]string{id, "Augusta", "Lovelace", email, "1985-12-10"}, strings.Fields(lines[1]))
+
+			stdout, _, err = run(backend, "", "list", "-o", "json")
+			r.NoError(err)
+			var users []models.User
+			r.NoError(json.Unmarshal([]byte(stdout), &users))
+			r.Contains(users, models.User{IdUser: created.IdUser, CreateUser: models.CreateUser{FirstName: "Augusta", LastName: "Lovelace", Email: email, Birthday: created.Birthday}})
+
+			stdout, _, err = run(backend, "", "search", "augusta LOVE", "-o", "csv")
+			r.NoError(err)
+			records, recordsErr = csv.NewReader(strings.NewReader(stdout)).ReadAll()
+			r.NoError(recordsErr)
+			r.Equal("age_in_years", records[0][5])
+			r.True(slices.ContainsFunc(records[1:], func(record []string) bool {
+				return record[0] == id && record[3] == email
+			}))
+			stdout, _, err = run(backend, "", "search", name+"@", "--max-age", "20", "-o", "json")
+			r.NoError(err)
+			r.Equal("[]\n", stdout)
+			_, _, err = run(backend, "", "search")
+			r.Error(err)
+
+			stdout, _, err = run(backend, "", "stats", "-o", "json")
+			r.NoError(err)
+			var stats models.AgeStats
+			r.NoError(json.Unmarshal([]byte(stdout), &stats))
+			r.NotEqual(models.AgeStats{}, stats)
+			stdout, _, err = run(backend, "", "stats")
+			r.NoError(err)
+			r.Contains(stdout, "thirties")
+
+			stdout, stderr, err := run(backend, "n\n", "delete", id)
+			r.ErrorContains(err, "nothing deleted")
+			r.Contains(stderr, "Augusta Lovelace <"+email+">")
+			r.Empty(stdout)
+			_, _, err = run(backend, "", "get", id)
+			r.NoError(err)
+
+			stdout, _, err = run(backend, "yes\n", "delete", id)
+			r.NoError(err)
+			r.Equal("Deleted user "+id+"\n", stdout)
+			_, _, err = run(backend, "", "get", id)
+			r.Error(err)
+			_, _, err = run(backend, "", "delete", "--yes", id)
+			r.Error(err)
+		})
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	r := require.New(t)
+	backend := backends["db"]()
+	stdout, _, err := run(backend, "", "create", "--first-name", "Grace", "--last-name", "Hopper", "--email", "grace@example.com", "--birthday", "1986-12-09", "-o", "csv")
+	r.NoError(err)
+	records, recordsErr := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	r.NoError(recordsErr)
+	id := records[1][0]
+
+	stdout, _, err = run(backend, "", "__complete", "get", "")
+	r.NoError(err)
+	r.Contains(stdout, id+"\t"+id+" Grace Hopper <grace@example.com>\n")
+	stdout, _, err = run(backend, "", "__complete", "delete", id, "")
+	r.NoError(err)
+	r.NotContains(stdout, id+"\t")
+	stdout, _, err = run(backend, "", "__complete", "list", "--output", "")
+	r.NoError(err)
+	r.Contains(stdout, "table\njson\ncsv\n")
+
+	stdout, _, err = run(backend, "", "completion", "bash")
+	r.NoError(err)
+	r.Contains(stdout, "__start_usersctl")
+
+	_, _, err = run(backend, "", "delete", "--yes", id)
+	r.NoError(err)
+}